@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// expandAlias resolves a command name that isn't built in. As with git, a
+// gitr-<name> plugin on PATH comes first, then the alias.<name> config
+// entries. An alias may name another alias or a plugin; looping back to one
+// already expanded is an error. Built-in commands can't be overridden.
+func expandAlias(app *cli.App, name string, args []string) (*cli.Command, []string, error) {
+	var chain []string
+	for {
+		if cmd := app.Lookup(name); cmd != nil {
+			return cmd, args, nil
+		}
+		if cmd := findPlugin(name); cmd != nil {
+			return cmd, args, nil
+		}
+		if strings.ContainsAny(name, ". ") {
+			return nil, nil, nil
+		}
+
+		for _, seen := range chain {
+			if seen == name {
+				return nil, nil, fmt.Errorf("alias loop: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+		chain = append(chain, name)
+
+		definition, err := config.Get("alias." + name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if definition == "" {
+			if len(chain) > 1 {
+				return nil, nil, fmt.Errorf("alias '%s' expands to unknown command '%s'", chain[0], name)
+			}
+			return nil, nil, nil
+		}
+
+		if shell, ok := strings.CutPrefix(definition, "!"); ok {
+			return shellAlias(name, shell), args, nil
+		}
+
+		words, err := splitWords(definition)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad alias.%s: %w", name, err)
+		}
+		if len(words) == 0 {
+			return nil, nil, fmt.Errorf("alias.%s is empty", name)
+		}
+		name, args = words[0], append(words[1:], args...)
+	}
+}
+
+// shellAlias runs a "!" alias with sh, passing the extra arguments as "$@".
+// Like git, it runs from the top of the repository with GITR_PREFIX set to
+// the directory gitr was started in, relative to the top.
+func shellAlias(name, script string) *cli.Command {
+	return &cli.Command{
+		Name:    name,
+		Summary: "!" + script,
+		NoRepo:  true,
+		RawArgs: true,
+		Run: func(ctx *cli.Context, args []string) error {
+			command := script
+			if len(args) > 0 {
+				command += ` "$@"`
+			}
+			cmd := exec.Command("sh", append([]string{"-c", command, name}, args...)...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = ctx.Stdout
+			cmd.Stderr = ctx.Stderr
+
+			if root, err := repo.GetGitrRoot(); err == nil {
+				cwd, _ := os.Getwd()
+				prefix, _ := filepath.Rel(root, cwd)
+				if prefix == "." {
+					prefix = ""
+				} else if prefix != "" {
+					prefix = filepath.ToSlash(prefix) + "/"
+				}
+				cmd.Dir = root
+				cmd.Env = append(os.Environ(), "GITR_PREFIX="+prefix)
+			}
+
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return &cli.ExitError{Code: exitErr.ExitCode(), Err: fmt.Errorf("alias '%s' exited with status %d", name, exitErr.ExitCode())}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to run alias '%s': %w", name, err)
+			}
+			return nil
+		},
+	}
+}
+
+// listAliases returns the configured aliases for help and completion
+func listAliases() map[string]string {
+	aliases, err := config.Aliases()
+	if err != nil {
+		return nil
+	}
+	return aliases
+}
+
+// splitWords splits an alias definition into words the way a shell would,
+// honouring single quotes, double quotes and backslash escapes
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0:
+				i++
+				word.WriteByte(s[i])
+			default:
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			if i+1 < len(s) {
+				i++
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
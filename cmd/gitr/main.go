@@ -1,121 +1,58 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/commands"
-	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+	app := &cli.App{
+		Name:     "gitr",
+		Summary:  "Git but it's actually just an LLM trying its best",
+		Commands: commands.Commands(),
+		Footer:   footer,
+
+		CompleteFlag: commands.CompleteFlag,
+		Aliases:      listAliases,
 	}
-
-	command := os.Args[1]
-	args := os.Args[2:]
-
-	var err error
-
-	switch command {
-	case "init":
-		err = commands.Init()
-
-	case "config":
-		err = commands.Config(args)
-
-	case "add":
-		err = requireGitrRepo(commands.Add, args)
-
-	case "commit":
-		err = requireGitrRepo(commands.Commit, args)
-
-	case "status":
-		err = requireGitrRepo(func([]string) error { return commands.Status() }, []string{})
-
-	case "log":
-		err = requireGitrRepo(func([]string) error { return commands.Log() }, []string{})
-
-	case "diff":
-		err = requireGitrRepo(func([]string) error { return commands.Diff() }, []string{})
-
-	case "branch":
-		err = requireGitrRepo(commands.Branch, args)
-
-	case "checkout":
-		err = requireGitrRepo(commands.Checkout, args)
-
-	case "merge":
-		err = requireGitrRepo(commands.Merge, args)
-
-	case "push":
-		err = requireGitrRepo(commands.Push, args)
-
-	case "pull":
-		err = requireGitrRepo(commands.Pull, args)
-
-	case "remote":
-		if len(args) > 0 && args[0] == "create" {
-			err = commands.RemoteCreate(args[1:])
-		} else {
-			err = fmt.Errorf("usage: gitr remote create <name>")
-		}
-
-	case "help", "--help", "-h":
-		printUsage()
-		return
-
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
-		printUsage()
-		os.Exit(1)
-	}
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	app.Plugins = func() map[string]string {
+		return listPlugins(app)
 	}
-}
-
-func requireGitrRepo(fn func([]string) error, args []string) error {
-	if _, err := repo.GetGitrRoot(); err != nil {
-		return fmt.Errorf("not a gitr repository (or any parent up to mount point)\nRun 'gitr init' to create one")
+	app.Resolve = func(name string, args []string) (*cli.Command, []string, error) {
+		return expandAlias(app, name, args)
 	}
-	return fn(args)
+	os.Exit(app.Run(os.Args[1:]))
 }
 
-func printUsage() {
-	fmt.Print(`gitr - Git but it's actually just an LLM trying its best
-
-Usage:
-  gitr <command> [args]
-
-Commands:
-  init                Initialize a new gitr repository
-  config set <key> <value>   Set configuration value
-  config get <key>           Get configuration value
-  add .               Stage all files (only '.' is supported)
-  commit -m "msg"     Create a commit with a message
-  status              Show working tree status
-  log                 Show commit history
-  diff                Show changes
-  branch              List branches
-  branch <name>       Create a new branch
-  branch -d <name>    Delete a branch
-  checkout <branch>   Switch to a branch
-  checkout -b <name>  Create and switch to a new branch
-  merge <branch>      Merge a branch into the current branch
-  push                Push to remote repository
-  pull                Pull from remote repository
-  remote create <name> Create a remote repository
-
-Configuration:
+// footer follows the command list in 'gitr help'
+const footer = `Configuration:
   api.url         API endpoint URL (e.g., https://api.deepseek.com/v1/chat/completions)
-  api.key         API authentication key
+  api.key         API authentication key (shown masked)
+  api.key_helper  Credential helper holding api.key: "file" for the built-in
+                  encrypted store, or <name> to run gitr-credential-<name>
   remote.url      Remote repository URL (e.g., https://your-app.vercel.app)
   remote.repo_id  Remote repository ID
+  api.model       Model name (default: deepseek-chat)
+  api.timeout     How long to wait for the LLM (default: 2m)
+  remote.timeout  How long to wait for the remote server (default: 30s)
+  remote.token    Bearer token for the remote server (set with gitr remote login)
+  remote.token_helper  Credential helper holding remote.token
+  history.per_branch  Keep a separate conversation history per branch (true/false)
+  color.ui        Color output: auto (terminal only), always or never
+  profiles.<name>.url|model|key|key_helper|temperature|max_tokens
+                  A named LLM provider; unset fields fall back to api.*
+  llm.profile     Profile used by default
+  llm.command_profile.<command>  Profile for one command (e.g. merge)
+  alias.<name>    Command alias, e.g. "log --oneline -n 20"; a leading "!"
+                  runs the rest as a shell command
+
+  Config is read from the system (/etc/gitr/config.json), global
+  (~/.config/gitr/config.json) and repository (.gitr/config.json) files,
+  in that order. GITR_<KEY> environment variables (e.g. GITR_API_KEY,
+  GITR_REMOTE_URL) override all of them. Values are checked against
+  their type when set; run 'gitr config describe' for the full list.
 
 Example workflow:
   gitr init
@@ -134,7 +71,34 @@ Example workflow:
 Remote operations:
   gitr config set remote.url https://your-app.vercel.app
   gitr remote create my-project
+  gitr clone https://your-app.vercel.app/<repo-id>
   gitr push
   gitr pull
-`)
-}
+  gitr push --force-with-lease                (rewrite the remote after pulling)
+  gitr pull --rebase                          (local turns after the remote's)
+  gitr remote add upstream <url>/<repo-id>    (another remote)
+  gitr push -u upstream                       (push there; the branch tracks it)
+  gitr fetch && gitr remote status            (ahead/behind, without pulling)
+  gitr serve --addr :8080 --dir ./gitr-data   (self-hosted remote)
+  gitr serve token add <name> --write '*'     (require tokens)
+
+Paging:
+  gitr log and gitr diff page through $GITR_PAGER, $PAGER or less -FRX on a
+  terminal; GITR_PAGER=cat turns paging off
+
+Plugins:
+  gitr <name> runs gitr-<name> from PATH when <name> isn't a built-in command,
+  with GITR_ROOT, GITR_BRANCH, GITR_CONFIG and GITR_EXECUTABLE set
+
+Shell completion:
+  source <(gitr completion bash)   (or zsh; fish: gitr completion fish | source)
+
+Exit codes:
+  0  success
+  1  the command failed
+  2  bad flags or arguments
+  3  not inside a gitr repository
+
+With --json every command prints one JSON object on stdout:
+  {"ok": true, "command": "gitr status", "result": {...}, "exit_code": 0}
+`
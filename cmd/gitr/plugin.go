@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// pluginPrefix is prepended to a command name to find its plugin on PATH
+const pluginPrefix = "gitr-"
+
+// findPlugin returns a command running gitr-<name> from PATH, or nil
+func findPlugin(name string) *cli.Command {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return nil
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return nil
+	}
+	return pluginCommand(name, path)
+}
+
+// pluginCommand runs a plugin with the arguments it was given, passing the
+// repository's location through the environment (see pluginEnv)
+func pluginCommand(name, path string) *cli.Command {
+	return &cli.Command{
+		Name:    name,
+		Summary: "plugin " + path,
+		NoRepo:  true,
+		RawArgs: true,
+		Run: func(ctx *cli.Context, args []string) error {
+			cmd := exec.Command(path, args...)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = ctx.Stdout
+			cmd.Stderr = ctx.Stderr
+			cmd.Env = append(os.Environ(), pluginEnv(ctx)...)
+
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return &cli.ExitError{Code: exitErr.ExitCode()}
+			}
+			if err != nil {
+				return fmt.Errorf("failed to run plugin %s: %w", path, err)
+			}
+			return nil
+		},
+	}
+}
+
+// pluginEnv describes the repository to a plugin:
+//
+//	GITR_ROOT        repository root (unset outside a repository)
+//	GITR_BRANCH      current branch
+//	GITR_CONFIG      repository config file
+//	GITR_EXECUTABLE  the gitr binary, for calling back into gitr
+//	GITR_PROFILE     set by --profile
+//	GITR_JSON        "1" with --json
+//	GITR_VERBOSE     "1" with --verbose
+func pluginEnv(ctx *cli.Context) []string {
+	var env []string
+	if root, err := repo.GetGitrRoot(); err == nil {
+		env = append(env, "GITR_ROOT="+root)
+		if branch, err := repo.GetCurrentBranch(); err == nil {
+			env = append(env, "GITR_BRANCH="+branch)
+		}
+		if path, err := config.ScopeLocal.Path(); err == nil {
+			env = append(env, "GITR_CONFIG="+path)
+		}
+	}
+	if exe, err := os.Executable(); err == nil {
+		env = append(env, "GITR_EXECUTABLE="+exe)
+	}
+	if ctx.JSON {
+		env = append(env, "GITR_JSON=1")
+	}
+	if ctx.Verbose {
+		env = append(env, "GITR_VERBOSE=1")
+	}
+	return env
+}
+
+// listPlugins returns the gitr-<name> executables on PATH, keyed by name,
+// leaving out those hidden by a built-in command. The first one on PATH
+// wins, as it does when running them.
+func listPlugins(app *cli.App) map[string]string {
+	plugins := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), pluginPrefix)
+			// Credential helpers share the prefix but aren't commands
+			if !ok || name == "" || entry.IsDir() || strings.HasPrefix(name, "credential-") {
+				continue
+			}
+			if _, seen := plugins[name]; seen || app.Lookup(name) != nil {
+				continue
+			}
+			if path, err := exec.LookPath(filepath.Join(dir, entry.Name())); err == nil {
+				plugins[name] = path
+			}
+		}
+	}
+	return plugins
+}
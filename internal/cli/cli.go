@@ -0,0 +1,440 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mysticshirou/gitroulette/internal/repo"
+	"github.com/mysticshirou/gitroulette/internal/trace"
+)
+
+// Exit codes shared by every command
+const (
+	ExitOK     = 0
+	ExitFailed = 1 // the command ran and failed
+	ExitUsage  = 2 // bad flags or arguments
+	ExitNoRepo = 3 // the command needs a gitr repository and there isn't one
+)
+
+// Command is a gitr subcommand
+type Command struct {
+	Name    string
+	Args    string // argument synopsis shown after the flags, e.g. "<branch>"
+	Summary string // one line for the command list
+	Help    string // optional longer description for --help
+
+	// NoRepo commands can run outside a gitr repository
+	NoRepo bool
+
+	// Hidden commands are left out of the command list
+	Hidden bool
+
+	// PassThrough commands only parse flags before their first argument;
+	// everything after it is passed on untouched, e.g. "prompt show commit -m x"
+	PassThrough bool
+
+	// RawArgs commands get every argument untouched, flags included
+	RawArgs bool
+
+	// Flags registers the command's flags
+	Flags func(fs *flag.FlagSet)
+
+	// Run executes the command with the arguments left after flag parsing.
+	// A command with Subcommands may leave Run nil.
+	Run func(ctx *Context, args []string) error
+
+	// Subcommands are selected by the first argument, e.g. "config set"
+	Subcommands []*Command
+
+	// Complete suggests values for the argument being typed, given the
+	// arguments before it. It must be fast and must not call the LLM.
+	Complete func(args []string, cur string) []string
+}
+
+// App is the top-level program: global flags plus a set of commands
+type App struct {
+	Name     string
+	Summary  string
+	Commands []*Command
+	Footer   string // extra text at the end of the main help
+
+	// CompleteFlag suggests values for global flags such as --profile
+	CompleteFlag func(name, cur string) []string
+
+	// Resolve is consulted for names that aren't built-in commands, after
+	// the global flags have taken effect. It returns the command to run and
+	// its arguments, or a nil command if the name is unknown.
+	Resolve func(name string, args []string) (*Command, []string, error)
+
+	// Aliases lists user-defined command names and what they expand to,
+	// for help and completion
+	Aliases func() map[string]string
+
+	// Plugins lists external commands and the executables that run them,
+	// for help and completion
+	Plugins func() map[string]string
+}
+
+// UsageError reports bad flags or arguments; it exits with ExitUsage
+type UsageError struct {
+	Msg   string
+	Usage string
+}
+
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+// Usagef returns a UsageError for the running command
+func Usagef(format string, args ...any) error {
+	return &UsageError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// ExitError lets a command choose its exit code. With a nil Err nothing is
+// printed, for commands that have already reported their own failure.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNotRepo is returned for commands run outside a gitr repository
+var ErrNotRepo = errors.New("not a gitr repository (or any parent up to mount point)\nRun 'gitr init' to create one")
+
+// StringsFlag collects every occurrence of a repeatable flag
+type StringsFlag []string
+
+func (s *StringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *StringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// globalFlags registers the flags every command accepts
+func (ctx *Context) globalFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&ctx.JSON, "json", ctx.JSON, "print machine-readable JSON")
+	fs.BoolVar(&ctx.Verbose, "verbose", ctx.Verbose, "print diagnostics to stderr")
+	fs.StringVar(&ctx.Profile, "profile", ctx.Profile, "LLM `profile` to use")
+}
+
+// Run parses global flags, dispatches to a command and returns the
+// process exit code
+func (app *App) Run(args []string) int {
+	ctx := &Context{Stdout: os.Stdout, Stderr: os.Stderr, app: app}
+
+	fs := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	ctx.globalFlags(fs)
+	fs.StringVar(&ctx.Dir, "C", "", "run as if gitr was started in `dir`")
+	help := fs.Bool("help", false, "show help")
+	fs.BoolVar(help, "h", false, "show help")
+
+	if err := fs.Parse(args); err != nil {
+		return ctx.fail(&UsageError{Msg: err.Error(), Usage: app.usage()})
+	}
+	if err := ctx.apply(); err != nil {
+		return ctx.fail(err)
+	}
+
+	args = fs.Args()
+	if *help || len(args) == 0 {
+		fmt.Fprint(ctx.Stdout, app.usage())
+		if len(args) == 0 && !*help {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+
+	if args[0] == "help" {
+		return app.help(ctx, args[1:])
+	}
+
+	cmd := app.Lookup(args[0])
+	rest := args[1:]
+	if cmd == nil && app.Resolve != nil {
+		var err error
+		if cmd, rest, err = app.Resolve(args[0], rest); err != nil {
+			return ctx.fail(err)
+		}
+	}
+	if cmd == nil {
+		return ctx.fail(&UsageError{Msg: fmt.Sprintf("unknown command: %s", args[0]), Usage: fmt.Sprintf("Run '%s help' for a list of commands.\n", app.Name)})
+	}
+
+	return ctx.finish(ctx.run(cmd, app.Name+" "+cmd.Name, rest))
+}
+
+// Lookup finds a command by name
+func (app *App) Lookup(name string) *Command {
+	for _, cmd := range app.Commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// help prints help for the app or for a command path
+func (app *App) help(ctx *Context, path []string) int {
+	if len(path) == 0 {
+		fmt.Fprint(ctx.Stdout, app.usage())
+		return ExitOK
+	}
+
+	cmd := app.Lookup(path[0])
+	if cmd == nil && app.Plugins != nil {
+		if executable, ok := app.Plugins()[path[0]]; ok {
+			fmt.Fprintf(ctx.Stdout, "'%s' is a plugin: %s\nRun '%s %s --help' for its own help.\n", path[0], executable, app.Name, path[0])
+			return ExitOK
+		}
+	}
+	if cmd == nil && app.Aliases != nil {
+		if definition, ok := app.Aliases()[path[0]]; ok {
+			fmt.Fprintf(ctx.Stdout, "'%s' is aliased to '%s'\n", path[0], definition)
+			return ExitOK
+		}
+	}
+	name := app.Name + " " + path[0]
+	for _, sub := range path[1:] {
+		if cmd == nil {
+			break
+		}
+		cmd = cmd.subcommand(sub)
+		name += " " + sub
+	}
+	if cmd == nil {
+		return ctx.fail(&UsageError{Msg: fmt.Sprintf("no help for %s", strings.Join(path, " ")), Usage: app.usage()})
+	}
+
+	fmt.Fprint(ctx.Stdout, cmd.usage(ctx, name))
+	return ExitOK
+}
+
+// run parses a command's flags and runs it or one of its subcommands
+func (ctx *Context) run(cmd *Command, name string, args []string) error {
+	fs := cmd.flagSet(ctx, name)
+
+	var err error
+	switch {
+	case cmd.RawArgs:
+		// The command sees every argument, flags included
+	case len(cmd.Subcommands) > 0 || cmd.PassThrough:
+		// Stop at the subcommand name so it gets its own flags
+		if err = fs.Parse(args); err == nil {
+			args = fs.Args()
+		}
+	default:
+		args, err = parseInterspersed(fs, args)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(ctx.Stdout, cmd.usage(ctx, name))
+		return nil
+	}
+	if err != nil {
+		return &UsageError{Msg: err.Error(), Usage: cmd.usage(ctx, name)}
+	}
+	if err := ctx.apply(); err != nil {
+		return err
+	}
+
+	if len(cmd.Subcommands) > 0 && len(args) > 0 {
+		if sub := cmd.subcommand(args[0]); sub != nil {
+			return ctx.run(sub, name+" "+sub.Name, args[1:])
+		}
+	}
+	if cmd.Run == nil {
+		if len(args) == 0 {
+			return &UsageError{Msg: fmt.Sprintf("%s needs a subcommand", name), Usage: cmd.usage(ctx, name)}
+		}
+		return &UsageError{Msg: fmt.Sprintf("unknown %s command: %s", name, args[0]), Usage: cmd.usage(ctx, name)}
+	}
+
+	if !cmd.NoRepo {
+		if _, err := repo.GetGitrRoot(); err != nil {
+			return ErrNotRepo
+		}
+	}
+
+	ctx.Command = name
+	err = cmd.Run(ctx, args)
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) && usageErr.Usage == "" {
+		usageErr.Usage = cmd.usage(ctx, name)
+	}
+	return err
+}
+
+func (cmd *Command) subcommand(name string) *Command {
+	for _, sub := range cmd.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (cmd *Command) flagSet(ctx *Context, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	ctx.globalFlags(fs)
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	return fs
+}
+
+// parseInterspersed parses flags anywhere among the arguments, so
+// "gitr branch -d name" and "gitr branch name -d" both work. Arguments
+// after "--" are never treated as flags.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// usage renders the help text of a command
+func (cmd *Command) usage(ctx *Context, name string) string {
+	var b strings.Builder
+
+	synopsis := name
+	if cmd.Flags != nil {
+		synopsis += " [flags]"
+	}
+	if len(cmd.Subcommands) > 0 {
+		synopsis += " <command>"
+	}
+	if cmd.Args != "" {
+		synopsis += " " + cmd.Args
+	}
+	fmt.Fprintf(&b, "usage: %s\n", synopsis)
+
+	if cmd.Summary != "" {
+		fmt.Fprintf(&b, "\n%s\n", cmd.Summary)
+	}
+	if cmd.Help != "" {
+		fmt.Fprintf(&b, "\n%s\n", strings.TrimRight(cmd.Help, "\n"))
+	}
+
+	if len(cmd.Subcommands) > 0 {
+		b.WriteString("\nCommands:\n")
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		for _, sub := range cmd.Subcommands {
+			if sub.Hidden {
+				continue
+			}
+			fmt.Fprintf(w, "  %s %s\t%s\n", sub.Name, sub.Args, sub.Summary)
+		}
+		w.Flush()
+	}
+
+	if cmd.Flags != nil {
+		b.WriteString("\nFlags:\n")
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		cmd.Flags(fs)
+		fs.SetOutput(&b)
+		fs.PrintDefaults()
+	}
+
+	b.WriteString("\nGlobal flags: -C <dir>, --json, --verbose, --profile <name>\n")
+	return b.String()
+}
+
+// usage renders the main help text
+func (app *App) usage() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s\n\nUsage:\n  %s [-C <dir>] [--json] [--verbose] [--profile <name>] <command> [flags] [args]\n\nCommands:\n", app.Name, app.Summary, app.Name)
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, cmd := range app.Commands {
+		if cmd.Hidden {
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	w.Flush()
+
+	if app.Aliases != nil {
+		if aliases := app.Aliases(); len(aliases) > 0 {
+			b.WriteString("\nAliases:\n")
+			names := make([]string, 0, len(aliases))
+			for name := range aliases {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+			for _, name := range names {
+				fmt.Fprintf(w, "  %s\t%s\n", name, aliases[name])
+			}
+			w.Flush()
+		}
+	}
+
+	if app.Plugins != nil {
+		if plugins := app.Plugins(); len(plugins) > 0 {
+			b.WriteString("\nPlugins:\n")
+			names := make([]string, 0, len(plugins))
+			for name := range plugins {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+			for _, name := range names {
+				fmt.Fprintf(w, "  %s\t%s\n", name, plugins[name])
+			}
+			w.Flush()
+		}
+	}
+
+	fmt.Fprintf(&b, "\nRun '%s help <command>' or '%s <command> --help' for a command's flags.\n", app.Name, app.Name)
+	if app.Footer != "" {
+		fmt.Fprintf(&b, "\n%s", app.Footer)
+	}
+	return b.String()
+}
+
+// apply makes the global flags take effect
+func (ctx *Context) apply() error {
+	if ctx.Dir != "" && !ctx.chdirDone {
+		if err := os.Chdir(ctx.Dir); err != nil {
+			return &UsageError{Msg: fmt.Sprintf("cannot change to %s: %v", ctx.Dir, err)}
+		}
+		ctx.chdirDone = true
+	}
+	if ctx.Profile != "" {
+		os.Setenv("GITR_PROFILE", ctx.Profile)
+	}
+	trace.Enabled = ctx.Verbose
+	return nil
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Complete returns suggestions for the last of words, the arguments typed
+// after the program name so far. It never fails: anything it can't
+// complete simply gets no suggestions.
+func (app *App) Complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	words = words[:len(words)-1]
+
+	global := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	(&Context{}).globalFlags(global)
+	global.String("C", "", "")
+
+	// Global flags before the command name
+	i := 0
+	for i < len(words) && strings.HasPrefix(words[i], "-") {
+		name, hasValue := splitFlag(words[i])
+		if !hasValue && takesValue(global, name) && i+1 < len(words) {
+			if name == "C" {
+				os.Chdir(words[i+1])
+			}
+			i += 2
+			continue
+		}
+		i++
+	}
+	if i == len(words) {
+		if i > 0 {
+			if name, hasValue := splitFlag(words[i-1]); !hasValue && takesValue(global, name) {
+				return app.completeFlag(name, cur)
+			}
+		}
+		if strings.HasPrefix(cur, "-") {
+			return filterPrefix(flagNames(global), cur)
+		}
+		return filterPrefix(append(app.commandNames(), "help"), cur)
+	}
+
+	if words[i] == "help" {
+		if i+1 == len(words) {
+			return filterPrefix(app.commandNames(), cur)
+		}
+		return nil
+	}
+
+	cmd := app.Lookup(words[i])
+	if cmd == nil && app.Resolve != nil {
+		cmd, _, _ = app.Resolve(words[i], nil)
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	// Walk down to the subcommand being typed, collecting its arguments
+	var args []string
+	fs := cmd.flagSet(&Context{}, cmd.Name)
+	expectValue := ""
+	for _, word := range words[i+1:] {
+		switch {
+		case expectValue != "":
+			expectValue = ""
+		case strings.HasPrefix(word, "-") && len(word) > 1 && !cmd.PassThrough:
+			if name, hasValue := splitFlag(word); !hasValue && takesValue(fs, name) {
+				expectValue = name
+			}
+		case len(cmd.Subcommands) > 0 && len(args) == 0 && cmd.subcommand(word) != nil:
+			cmd = cmd.subcommand(word)
+			fs = cmd.flagSet(&Context{}, cmd.Name)
+		default:
+			args = append(args, word)
+		}
+	}
+
+	if expectValue != "" {
+		if global.Lookup(expectValue) != nil {
+			return app.completeFlag(expectValue, cur)
+		}
+		return nil // free-form values such as commit messages
+	}
+	if strings.HasPrefix(cur, "-") && !(cmd.PassThrough && len(args) > 0) {
+		return filterPrefix(flagNames(fs), cur)
+	}
+	if len(cmd.Subcommands) > 0 && len(args) == 0 {
+		var names []string
+		for _, sub := range cmd.Subcommands {
+			if !sub.Hidden {
+				names = append(names, sub.Name)
+			}
+		}
+		return filterPrefix(names, cur)
+	}
+	if cmd.Complete != nil {
+		return filterPrefix(cmd.Complete(args, cur), cur)
+	}
+	return nil
+}
+
+// completeFlag suggests values for a global flag
+func (app *App) completeFlag(name, cur string) []string {
+	if name == "C" {
+		return CompletePaths(cur, true)
+	}
+	if app.CompleteFlag != nil {
+		return filterPrefix(app.CompleteFlag(name, cur), cur)
+	}
+	return nil
+}
+
+func (app *App) commandNames() []string {
+	var names []string
+	for _, cmd := range app.Commands {
+		if !cmd.Hidden {
+			names = append(names, cmd.Name)
+		}
+	}
+	if app.Aliases != nil {
+		for name := range app.Aliases() {
+			names = append(names, name)
+		}
+	}
+	if app.Plugins != nil {
+		for name := range app.Plugins() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CompletePaths suggests files and directories starting with cur, relative
+// to the working directory. Directories end in a slash.
+func CompletePaths(cur string, dirsOnly bool) []string {
+	dir, prefix := filepath.Split(cur)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// Hidden entries only when asked for, and never gitr's own directory
+		if (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) || name == ".gitr" {
+			continue
+		}
+
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(filepath.Join(readDir, name)); err == nil {
+				isDir = info.IsDir()
+			}
+		}
+		switch {
+		case isDir:
+			paths = append(paths, dir+name+"/")
+		case !dirsOnly:
+			paths = append(paths, dir+name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// splitFlag returns a flag's name and whether its value is attached with "="
+func splitFlag(word string) (string, bool) {
+	name := strings.TrimLeft(word, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], true
+	}
+	return name, false
+}
+
+// takesValue reports whether a flag needs a value, i.e. isn't boolean
+func takesValue(fs *flag.FlagSet, name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return false
+	}
+	return true
+}
+
+// flagNames lists a flag set's flags as typed: -x for one letter, --name otherwise
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) == 1 {
+			names = append(names, "-"+f.Name)
+		} else {
+			names = append(names, "--"+f.Name)
+		}
+	})
+	return names
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var matches []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			matches = append(matches, value)
+		}
+	}
+	return matches
+}
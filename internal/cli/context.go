@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Context carries the global flags and output streams into a command
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	JSON    bool   // --json: print a JSON document instead of text
+	Verbose bool   // --verbose: print diagnostics to stderr
+	Profile string // --profile: LLM profile for this run
+	Dir     string // -C: directory gitr runs in
+
+	// Command is the full name of the running command, e.g. "gitr config set"
+	Command string
+
+	app       *App
+	result    any
+	chdirDone bool
+	pager     *pager
+}
+
+// App returns the program the command belongs to
+func (ctx *Context) App() *App {
+	return ctx.app
+}
+
+// jsonOutput is the document printed with --json
+type jsonOutput struct {
+	OK       bool   `json:"ok"`
+	Command  string `json:"command,omitempty"`
+	Result   any    `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// Printf writes human-readable output; it is suppressed with --json
+func (ctx *Context) Printf(format string, args ...any) {
+	if !ctx.JSON {
+		fmt.Fprintf(ctx.Stdout, format, args...)
+	}
+}
+
+// Println writes a line of human-readable output; it is suppressed with --json
+func (ctx *Context) Println(args ...any) {
+	if !ctx.JSON {
+		fmt.Fprintln(ctx.Stdout, args...)
+	}
+}
+
+// SetResult records the command's machine-readable result, printed
+// with --json once the command returns
+func (ctx *Context) SetResult(v any) {
+	ctx.result = v
+}
+
+// finish reports a command's outcome and returns its exit code
+func (ctx *Context) finish(err error) int {
+	ctx.stopPager()
+	if err != nil {
+		return ctx.fail(err)
+	}
+	if ctx.JSON {
+		ctx.writeJSON(jsonOutput{OK: true, Command: ctx.Command, Result: ctx.result, ExitCode: ExitOK})
+	}
+	return ExitOK
+}
+
+// fail prints an error and returns the exit code it maps to
+func (ctx *Context) fail(err error) int {
+	ctx.stopPager()
+	code := ExitFailed
+	var usageErr *UsageError
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &usageErr):
+		code = ExitUsage
+	case errors.Is(err, ErrNotRepo):
+		code = ExitNoRepo
+	case errors.As(err, &exitErr):
+		code = exitErr.Code
+	}
+
+	if exitErr != nil && exitErr.Err == nil && !ctx.JSON {
+		return code
+	}
+	if ctx.JSON {
+		ctx.writeJSON(jsonOutput{OK: false, Command: ctx.Command, Result: ctx.result, Error: err.Error(), ExitCode: code})
+		return code
+	}
+
+	fmt.Fprintf(ctx.Stderr, "Error: %v\n", err)
+	if usageErr != nil && usageErr.Usage != "" {
+		fmt.Fprintf(ctx.Stderr, "\n%s", usageErr.Usage)
+	}
+	return code
+}
+
+func (ctx *Context) writeJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "Error: failed to serialize output: %v\n", err)
+		return
+	}
+	fmt.Fprintln(ctx.Stdout, string(data))
+}
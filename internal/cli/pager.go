@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultPager is used when neither $GITR_PAGER nor $PAGER is set
+const DefaultPager = "less -FRX"
+
+// pager is a running pager process and the pipe feeding it
+type pager struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// IsTerminal reports whether w is a terminal rather than a file or pipe
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// IsTerminal reports whether the command's output ends up on a terminal,
+// directly or through the pager
+func (ctx *Context) IsTerminal() bool {
+	return ctx.pager != nil || IsTerminal(ctx.Stdout)
+}
+
+// PagerCommand returns the pager to use: $GITR_PAGER, then $PAGER, then
+// less. An empty value or "cat" turns paging off.
+func PagerCommand() string {
+	if command, ok := os.LookupEnv("GITR_PAGER"); ok {
+		return command
+	}
+	if command, ok := os.LookupEnv("PAGER"); ok {
+		return command
+	}
+	return DefaultPager
+}
+
+// StartPager sends the rest of the command's output through a pager when
+// stdout is a terminal. It does nothing with --json, when the pager is
+// turned off, or if the pager can't be started.
+func (ctx *Context) StartPager() {
+	if ctx.JSON || ctx.pager != nil || !IsTerminal(ctx.Stdout) {
+		return
+	}
+	command := strings.TrimSpace(PagerCommand())
+	if command == "" || command == "cat" {
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = ctx.Stdout
+	cmd.Stderr = ctx.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		return
+	}
+	ctx.pager = &pager{cmd: cmd, stdin: stdin}
+	ctx.Stdout = stdin
+}
+
+// stopPager waits for the user to quit the pager
+func (ctx *Context) stopPager() {
+	if ctx.pager == nil {
+		return
+	}
+	ctx.pager.stdin.Close()
+	ctx.pager.cmd.Wait()
+	ctx.Stdout = ctx.pager.cmd.Stdout
+	ctx.pager = nil
+}
@@ -0,0 +1,141 @@
+// Package color adds ANSI colors to git-style output, whether it came from
+// gitr itself or from the LLM
+package color
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	reset      = "\x1b[m"
+	bold       = "\x1b[1m"
+	red        = "\x1b[31m"
+	green      = "\x1b[32m"
+	yellow     = "\x1b[33m"
+	cyan       = "\x1b[36m"
+	boldRed    = "\x1b[1;31m"
+	boldGreen  = "\x1b[1;32m"
+	boldYellow = "\x1b[1;33m"
+	boldCyan   = "\x1b[1;36m"
+)
+
+var (
+	// commitLine matches "commit <hash>" with optional decorations
+	commitLine = regexp.MustCompile(`^(commit [0-9a-f]{7,64})( \(.*\))?$`)
+	// onelineLine matches "<hash> (decorations) subject" from log --oneline
+	onelineLine = regexp.MustCompile(`^([0-9a-f]{7,64})( \([^)]*\))?( .*)?$`)
+	hunkHeader  = regexp.MustCompile(`^(@@+ [^@]* @@+)(.*)$`)
+)
+
+// paint wraps s in a color, leaving empty strings alone
+func paint(code, s string) string {
+	if s == "" {
+		return s
+	}
+	return code + s + reset
+}
+
+// Diff colors a unified diff: headers bold, hunk headers cyan, added lines
+// green and removed lines red
+func Diff(text string) string {
+	lines := strings.Split(text, "\n")
+	inHunk := false
+	for i, line := range lines {
+		lines[i], inHunk = diffLine(line, inHunk)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diffLine colors one line of a diff. inHunk tracks whether the line is
+// inside a hunk, where "---" and "+++" are content rather than file names.
+func diffLine(line string, inHunk bool) (string, bool) {
+	if m := hunkHeader.FindStringSubmatch(line); m != nil {
+		return paint(cyan, m[1]) + m[2], true
+	}
+	if strings.HasPrefix(line, "diff ") {
+		return paint(bold, line), false
+	}
+	if !inHunk {
+		for _, prefix := range []string{"--- ", "+++ ", "index ", "new file mode", "deleted file mode", "old mode", "new mode", "similarity index", "rename from", "rename to", "Binary files"} {
+			if strings.HasPrefix(line, prefix) {
+				return paint(bold, line), false
+			}
+		}
+		return line, false
+	}
+	switch {
+	case strings.HasPrefix(line, "+"):
+		return paint(green, line), true
+	case strings.HasPrefix(line, "-"):
+		return paint(red, line), true
+	case line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, `\`):
+		return line, true
+	}
+	// Anything else ends the hunk, e.g. commentary after the diff
+	return line, false
+}
+
+// Log colors commit hashes yellow, decorates branch names like git does,
+// and colors any patches shown with the commits
+func Log(text string) string {
+	lines := strings.Split(text, "\n")
+	inDiff, inHunk := false, false
+	for i, line := range lines {
+		switch m := commitLine.FindStringSubmatch(line); {
+		case m != nil:
+			lines[i] = paint(yellow, m[1]) + decorations(m[2])
+			inDiff = false
+		case strings.HasPrefix(line, "diff "):
+			inDiff = true
+			lines[i], inHunk = diffLine(line, false)
+		case inDiff:
+			lines[i], inHunk = diffLine(line, inHunk)
+		default:
+			if m := onelineLine.FindStringSubmatch(line); m != nil {
+				lines[i] = paint(yellow, m[1]) + decorations(m[2]) + m[3]
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decorations colors " (HEAD -> main, tag: v1, origin/main, feature)"
+func decorations(s string) string {
+	inner, ok := strings.CutPrefix(s, " (")
+	if !ok {
+		return s
+	}
+	inner, ok = strings.CutSuffix(inner, ")")
+	if !ok {
+		return s
+	}
+
+	refs := strings.Split(inner, ", ")
+	for i, ref := range refs {
+		switch {
+		case strings.HasPrefix(ref, "HEAD -> "):
+			refs[i] = paint(boldCyan, "HEAD ->") + " " + paint(boldGreen, strings.TrimPrefix(ref, "HEAD -> "))
+		case ref == "HEAD":
+			refs[i] = paint(boldCyan, ref)
+		case strings.HasPrefix(ref, "tag: "):
+			refs[i] = paint(boldYellow, ref)
+		case strings.Contains(ref, "/"):
+			refs[i] = paint(boldRed, ref)
+		default:
+			refs[i] = paint(boldGreen, ref)
+		}
+	}
+	return paint(yellow, " (") + strings.Join(refs, paint(yellow, ", ")) + paint(yellow, ")")
+}
+
+// Branches colors the current branch, marked with "*", green
+func Branches(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if name, ok := strings.CutPrefix(line, "* "); ok {
+			lines[i] = "* " + paint(green, name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
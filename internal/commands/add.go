@@ -3,12 +3,24 @@ package commands
 import (
 	"fmt"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/llm"
+	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Add(args []string) error {
+func addCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "add",
+		Args:     ".",
+		Summary:  "Stage all files (only '.' is supported)",
+		Run:      Add,
+		Complete: completeAddArgs,
+	}
+}
+
+func Add(ctx *cli.Context, args []string) error {
 	if len(args) != 1 || args[0] != "." {
-		return fmt.Errorf("only 'gitr add .' is supported (adds all files)")
+		return cli.Usagef("only 'gitr add .' is supported (adds all files)")
 	}
 
 	response, err := llm.SendCommand("git add", args)
@@ -16,6 +28,14 @@ func Add(args []string) error {
 		return err
 	}
 
-	fmt.Println(response)
+	files, err := repo.GetAllFiles()
+	if err != nil {
+		return fmt.Errorf("failed to read repository files: %w", err)
+	}
+	if err := repo.StageFiles(files); err != nil {
+		return err
+	}
+
+	respond(ctx, "git add", args, response)
 	return nil
 }
@@ -1,42 +1,120 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/color"
 	"github.com/mysticshirou/gitroulette/internal/llm"
+	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Branch(args []string) error {
-	// No args - list branches
-	if len(args) == 0 {
-		response, err := llm.SendCommand("git branch", args)
-		if err != nil {
-			return err
-		}
-		fmt.Println(response)
-		return nil
-	}
-
-	// -d flag - delete branch
-	if args[0] == "-d" {
-		if len(args) != 2 {
-			return fmt.Errorf("usage: gitr branch -d <branch-name>")
-		}
-		response, err := llm.SendCommand("git branch", args)
-		if err != nil {
-			return err
-		}
-		fmt.Println(response)
-		return nil
-	}
-
-	// Create new branch
+func branchCommand() *cli.Command {
+	var remove bool
+	return &cli.Command{
+		Name:     "branch",
+		Args:     "[<name>]",
+		Summary:  "List, create or delete branches",
+		Complete: completeBranches,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&remove, "d", false, "delete the named branch")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			switch {
+			case len(args) > 1:
+				return cli.Usagef("too many arguments")
+			case remove && len(args) == 0:
+				return cli.Usagef("usage: gitr branch -d <branch-name>")
+			case remove:
+				return BranchDelete(ctx, args[0])
+			case len(args) == 1:
+				return BranchCreate(ctx, args[0])
+			default:
+				return BranchList(ctx)
+			}
+		},
+	}
+}
+
+// BranchList lists branches
+func BranchList(ctx *cli.Context) error {
+	response, err := llm.SendCommand("git branch", []string{})
+	if err != nil {
+		return err
+	}
+
+	respondColored(ctx, "git branch", nil, response, color.Branches)
+	return nil
+}
+
+// BranchDelete deletes a branch
+func BranchDelete(ctx *cli.Context, name string) error {
+	args := []string{"-d", name}
+	response, err := llm.SendCommand("git branch", args)
+	if err != nil {
+		return err
+	}
+
+	if err := removeBranchHistory(name); err != nil {
+		return err
+	}
+	if err := repo.DeleteRef(name); err != nil {
+		return err
+	}
+
+	respond(ctx, "git branch", args, response)
+	return nil
+}
+
+// BranchCreate creates a branch from the current one
+func BranchCreate(ctx *cli.Context, name string) error {
+	if err := checkNewBranch(name); err != nil {
+		return err
+	}
+
+	parent, err := repo.GetCurrentBranch()
+	if err != nil {
+		return err
+	}
+
 	// Just ask the LLM to create the branch
+	args := []string{name}
 	response, err := llm.SendCommand("git branch", args)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(response)
+	if err := forkBranch(parent, name); err != nil {
+		return err
+	}
+
+	respond(ctx, "git branch", args, response)
 	return nil
 }
+
+// checkNewBranch rejects invalid names and branches that already exist
+func checkNewBranch(name string) error {
+	if err := repo.ValidateBranchName(name); err != nil {
+		return err
+	}
+	if _, exists, err := repo.ReadRef(name); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	return nil
+}
+
+// forkBranch starts a new branch at its parent's commit, with a copy of
+// the parent's history when histories are kept per branch
+func forkBranch(parent, name string) error {
+	commit, _, err := repo.ReadRef(parent)
+	if err != nil {
+		return err
+	}
+	if err := repo.WriteRef(name, commit); err != nil {
+		return err
+	}
+	return forkBranchHistory(parent, name)
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// mergedThroughPrefix marks how far another branch's log has been joined,
+// so merging the same branch twice doesn't repeat its turns
+const mergedThroughPrefix = "Merged-Through: "
+
+// maxSummaryResponse limits how much of each response is copied into a
+// history merge summary
+const maxSummaryResponse = 2000
+
+// forkBranchHistory starts a new branch's log as a copy of its parent's
+// when history.per_branch is enabled
+func forkBranchHistory(parent, branch string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if !cfg.History.PerBranch {
+		return nil
+	}
+
+	if err := repo.BranchHistory(branch).Fork(repo.BranchHistory(parent)); err != nil {
+		return fmt.Errorf("failed to fork history for branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// removeBranchHistory deletes a branch's log when history.per_branch is enabled
+func removeBranchHistory(branch string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if !cfg.History.PerBranch {
+		return nil
+	}
+
+	if err := repo.BranchHistory(branch).Remove(); err != nil {
+		return fmt.Errorf("failed to remove history for branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// joinBranchHistory appends a generated summary of the other branch's
+// conversation since it diverged to the current branch's log, so the LLM
+// knows what it is merging. It returns the number of turns summarized and,
+// when it appended anything, a function that takes the summary back out
+// (e.g. because the merge itself failed).
+func joinBranchHistory(other string) (int, func() error, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !cfg.History.PerBranch {
+		return 0, nil, nil
+	}
+
+	current, err := repo.GetCurrentBranch()
+	if err != nil {
+		return 0, nil, err
+	}
+	if current == other {
+		return 0, nil, nil
+	}
+
+	otherLog := repo.BranchHistory(other)
+	if !otherLog.Exists() {
+		return 0, nil, nil
+	}
+
+	currentLog := repo.BranchHistory(current)
+	joined := 0
+	var appended []repo.Message
+	err = repo.WithLock(func() error {
+		ours, err := currentLog.Load()
+		if err != nil {
+			return err
+		}
+		theirs, err := otherLog.Load()
+		if err != nil {
+			return err
+		}
+
+		// Skip what the two logs share and whatever an earlier merge of the
+		// same branch already summarized
+		start := ours.CommonPrefix(theirs)
+		mergedThrough := lastMergedThrough(ours, other)
+		for start < len(theirs.Messages) && !theirs.Messages[start].Timestamp.After(mergedThrough) {
+			start++
+		}
+
+		tail := &repo.History{Messages: theirs.Messages[start:]}
+		turns := tail.Turns()
+		if len(turns) == 0 {
+			return nil
+		}
+
+		var summary strings.Builder
+		fmt.Fprintf(&summary, "Current branch: %s\nCommand: gitr merge-history %s\n", current, other)
+		fmt.Fprintf(&summary, "%s%s\n\n", mergedThroughPrefix, tail.Messages[len(tail.Messages)-1].Timestamp.Format(time.RFC3339Nano))
+		fmt.Fprintf(&summary, "The following commands ran on branch '%s' after it diverged from '%s':\n", other, current)
+		for _, turn := range turns {
+			fmt.Fprintf(&summary, "\n$ %s\n", turn.Command())
+			if turn.Assistant != nil {
+				response := turn.Assistant.Content
+				if len(response) > maxSummaryResponse {
+					response = response[:maxSummaryResponse] + "\n[...]"
+				}
+				summary.WriteString(response + "\n")
+			}
+		}
+
+		now := time.Now()
+		messages := []repo.Message{
+			{Role: "user", Content: summary.String(), Timestamp: now},
+			{Role: "assistant", Content: fmt.Sprintf("Joined %d turn(s) of history from branch '%s'.", len(turns), other), Timestamp: now},
+		}
+		if err := currentLog.Append(messages...); err != nil {
+			return err
+		}
+		joined = len(turns)
+		appended = messages
+		return nil
+	})
+	if err != nil || appended == nil {
+		return 0, nil, err
+	}
+	return joined, func() error { return unjoinBranchHistory(currentLog, appended) }, nil
+}
+
+// unjoinBranchHistory removes a summary appended by joinBranchHistory from
+// the log, leaving any turns added around it untouched
+func unjoinBranchHistory(log repo.HistoryLog, summary []repo.Message) error {
+	return repo.WithLock(func() error {
+		history, err := log.Load()
+		if err != nil {
+			return err
+		}
+		for i := len(history.Messages) - len(summary); i >= 0; i-- {
+			tail := &repo.History{Messages: history.Messages[i:]}
+			if tail.CommonPrefix(&repo.History{Messages: summary}) == len(summary) {
+				history.Messages = append(history.Messages[:i], history.Messages[i+len(summary):]...)
+				return log.Save(history)
+			}
+		}
+		return nil
+	})
+}
+
+// lastMergedThrough finds the point up to which a branch's log was already
+// joined into this one
+func lastMergedThrough(history *repo.History, branch string) time.Time {
+	var through time.Time
+	marker := "Command: gitr merge-history " + branch + "\n"
+	for _, msg := range history.Messages {
+		if msg.Role != "user" || !strings.Contains(msg.Content, marker) {
+			continue
+		}
+		for _, line := range strings.Split(msg.Content, "\n") {
+			if !strings.HasPrefix(line, mergedThroughPrefix) {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, mergedThroughPrefix)); err == nil && t.After(through) {
+				through = t
+			}
+		}
+	}
+	return through
+}
@@ -1,42 +1,51 @@
 package commands
 
 import (
-	"fmt"
+	"flag"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/llm"
 	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Checkout(args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: gitr checkout <branch> or gitr checkout -b <branch>")
+func checkoutCommand() *cli.Command {
+	var create bool
+	return &cli.Command{
+		Name:     "checkout",
+		Args:     "<branch>",
+		Summary:  "Switch branches (-b creates the branch first)",
+		Complete: completeBranches,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&create, "b", false, "create the branch and switch to it")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) != 1 {
+				return cli.Usagef("usage: gitr checkout <branch> or gitr checkout -b <branch>")
+			}
+			return Checkout(ctx, args[0], create)
+		},
 	}
+}
 
-	// -b flag - create and switch to new branch
-	if args[0] == "-b" {
-		if len(args) != 2 {
-			return fmt.Errorf("usage: gitr checkout -b <branch-name>")
-		}
-		branchName := args[1]
-
-		// Send to LLM to create the branch
-		response, err := llm.SendCommand("git checkout", args)
-		if err != nil {
+// Checkout switches to a branch, creating it first if create is set
+func Checkout(ctx *cli.Context, branchName string, create bool) error {
+	args := []string{branchName}
+	var parent string
+	if create {
+		if err := checkNewBranch(branchName); err != nil {
 			return err
 		}
 
-		// Update HEAD to new branch
-		if err := repo.SetCurrentBranch(branchName); err != nil {
+		var err error
+		parent, err = repo.GetCurrentBranch()
+		if err != nil {
 			return err
 		}
-
-		fmt.Println(response)
-		return nil
+		args = []string{"-b", branchName}
+	} else if err := repo.ValidateBranchName(branchName); err != nil {
+		return err
 	}
 
-	// Switch to existing branch
-	branchName := args[0]
-
 	// Send to LLM
 	response, err := llm.SendCommand("git checkout", args)
 	if err != nil {
@@ -48,6 +57,12 @@ func Checkout(args []string) error {
 		return err
 	}
 
-	fmt.Println(response)
+	if create {
+		if err := forkBranch(parent, branchName); err != nil {
+			return err
+		}
+	}
+
+	respond(ctx, "git checkout", args, response)
 	return nil
 }
@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func cloneCommand() *cli.Command {
+	var branch string
+	var depth int
+	return &cli.Command{
+		Name:    "clone",
+		Args:    "<remote-url>/<repo-id> [dir]",
+		Summary: "Copy a remote repository into a new directory",
+		Help: `Creates the directory (named after the repository id unless given),
+initializes it, configures remote.url and remote.repo_id (the origin
+remote), and pulls the remote's default branch, or the one named with
+--branch, which then tracks origin.
+
+--depth fetches only the last turns of the conversation history. Pushing
+still adds to the remote's full history, and 'gitr pull' fetches the rest.`,
+		NoRepo: true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&branch, "branch", "", "check out `branch` instead of the remote's default branch")
+			fs.IntVar(&depth, "depth", 0, "fetch only the last `turns` of history")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return cli.Usagef("usage: gitr clone [--branch <branch>] [--depth <turns>] <remote-url>/<repo-id> [dir]")
+			}
+			if depth < 0 {
+				return cli.Usagef("--depth must be a positive number of turns")
+			}
+			dir := ""
+			if len(args) == 2 {
+				dir = args[1]
+			}
+			return Clone(ctx, args[0], dir, branch, depth)
+		},
+	}
+}
+
+// CloneResult is the JSON result of clone
+type CloneResult struct {
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	RepoID   string `json:"repo_id"`
+	Branch   string `json:"branch"`
+	Files    int    `json:"files"`
+	Messages int    `json:"messages"`
+	Shallow  bool   `json:"shallow"`
+}
+
+// splitRemoteURL splits "<remote-url>/<repo-id>" into its two parts
+func splitRemoteURL(source string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSuffix(source, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", cli.Usagef("not a remote URL: %s (expected http(s)://host/<repo-id>)", source)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", "", cli.Usagef("unexpected query in remote URL: %s", source)
+	}
+
+	repoID := path.Base(u.Path)
+	if repoID == "/" || repoID == "." || repoID == ".." || repoID == "" {
+		return "", "", cli.Usagef("missing repository id in %s (expected <remote-url>/<repo-id>)", source)
+	}
+	u.Path = strings.TrimSuffix(path.Dir(u.Path), "/")
+	u.RawPath = ""
+	return u.String(), repoID, nil
+}
+
+// Clone creates dir, initializes a repository in it and pulls a remote
+// repository into it. If anything fails, a directory it created is removed.
+func Clone(ctx *cli.Context, source, dir, branch string, depth int) (err error) {
+	baseURL, repoID, err := splitRemoteURL(source)
+	if err != nil {
+		return err
+	}
+	if branch != "" {
+		if err := repo.ValidateBranchName(branch); err != nil {
+			return cli.Usagef("%v", err)
+		}
+	}
+	if dir == "" {
+		dir = repoID
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	created, err := cloneDestination(dir)
+	if err != nil {
+		return err
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		os.Chdir(previous)
+		if err != nil {
+			if created {
+				os.RemoveAll(dir)
+			} else {
+				os.RemoveAll(filepath.Join(dir, repo.GitrDir))
+			}
+		}
+	}()
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+
+	ctx.Printf("Cloning into '%s'...\n", filepath.Base(dir))
+	if err := repo.Init(); err != nil {
+		return err
+	}
+	if err := config.SetIn(config.ScopeLocal, "remote.url", baseURL); err != nil {
+		return err
+	}
+	if err := config.SetIn(config.ScopeLocal, "remote.repo_id", repoID); err != nil {
+		return err
+	}
+
+	client, err := remote.NewClient(config.DefaultRemote)
+	if err != nil {
+		return fmt.Errorf("failed to create remote client: %w", err)
+	}
+	pullData, err := client.PullBranch(branch, depth)
+	if err != nil {
+		return fmt.Errorf("clone failed: %w", err)
+	}
+	if branch != "" && pullData.Branch != branch {
+		return fmt.Errorf("clone failed: the remote sent branch %s instead of %s", pullData.Branch, branch)
+	}
+
+	err = repo.WithLock(func() error {
+		if err := repo.SetCurrentBranch(pullData.Branch); err != nil {
+			return fmt.Errorf("failed to update branch: %w", err)
+		}
+		if err := applyPull(config.DefaultRemote, pullData, &PullResult{}, false, false); err != nil {
+			return err
+		}
+		if pullData.Shallow != nil {
+			shallow := &repo.Shallow{Length: pullData.Shallow.Length, Hash: pullData.Shallow.Hash}
+			if err := repo.WriteShallow(shallow); err != nil {
+				return err
+			}
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		return recordPull(cfg, config.DefaultRemote, pullData)
+	})
+	if err != nil {
+		return err
+	}
+	err = config.SetIn(config.ScopeLocal, config.TrackingKey(pullData.Branch), config.DefaultRemote)
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("✓ Cloned %s (branch: %s)\n", repoID, pullData.Branch)
+	ctx.Printf("  Files: %d\n", len(pullData.Files))
+	if pullData.Shallow != nil {
+		ctx.Printf("  History: last %d messages (%d older ones left on the remote)\n", len(pullData.History), pullData.Shallow.Length)
+	} else {
+		ctx.Printf("  History: %d messages\n", len(pullData.History))
+	}
+	ctx.SetResult(&CloneResult{
+		Path:     dir,
+		URL:      baseURL,
+		RepoID:   repoID,
+		Branch:   pullData.Branch,
+		Files:    len(pullData.Files),
+		Messages: len(pullData.History),
+		Shallow:  pullData.Shallow != nil,
+	})
+	return nil
+}
+
+// cloneDestination makes sure dir is missing or empty, creating it if
+// needed, and reports whether it did
+func cloneDestination(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, fmt.Errorf("destination path '%s' already exists and is not an empty directory", dir)
+	}
+	return false, nil
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"os"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+)
+
+// Commands returns every gitr command in the order help lists them
+func Commands() []*cli.Command {
+	return []*cli.Command{
+		initCommand(),
+		cloneCommand(),
+		configCommand(),
+		addCommand(),
+		commitCommand(),
+		statusCommand(),
+		logCommand(),
+		diffCommand(),
+		branchCommand(),
+		checkoutCommand(),
+		mergeCommand(),
+		pushCommand(),
+		pullCommand(),
+		fetchCommand(),
+		remoteCommand(),
+		serveCommand(),
+		undoCommand(),
+		historyCommand(),
+		pinCommand(),
+		unpinCommand(),
+		promptCommand(),
+		doctorCommand(),
+		completionCommand(),
+		completeCommand(),
+	}
+}
+
+// Response is the JSON result of a command answered by the LLM
+type Response struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args"`
+	Response string   `json:"response"`
+}
+
+// respond prints the LLM's answer to a command
+func respond(ctx *cli.Context, command string, args []string, response string) {
+	if args == nil {
+		args = []string{}
+	}
+	ctx.Println(response)
+	ctx.SetResult(&Response{Command: command, Args: args, Response: response})
+}
+
+// respondColored is respond for output that git would color; style adds
+// the colors when useColor allows them. The JSON result stays plain.
+func respondColored(ctx *cli.Context, command string, args []string, response string, style func(string) string) {
+	if args == nil {
+		args = []string{}
+	}
+	text := response
+	if useColor(ctx) {
+		text = style(response)
+	}
+	ctx.Println(text)
+	ctx.SetResult(&Response{Command: command, Args: args, Response: response})
+}
+
+// useColor follows color.ui: "always", "never", or "auto" to color only
+// on a terminal, honouring NO_COLOR and TERM=dumb
+func useColor(ctx *cli.Context) bool {
+	cfg, err := config.Load()
+	if err != nil {
+		return false
+	}
+	switch cfg.Color.UI {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return ctx.IsTerminal() && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
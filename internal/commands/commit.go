@@ -1,14 +1,40 @@
 package commands
 
 import (
-	"fmt"
+	"flag"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/llm"
+	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Commit(args []string) error {
-	if len(args) < 2 || args[0] != "-m" {
-		return fmt.Errorf("usage: gitr commit -m \"message\"")
+func commitCommand() *cli.Command {
+	var messages cli.StringsFlag
+	return &cli.Command{
+		Name:    "commit",
+		Summary: "Create a commit with a message",
+		Help:    "Each -m adds a paragraph to the message, as in git.",
+		Flags: func(fs *flag.FlagSet) {
+			fs.Var(&messages, "m", "commit `message` (repeatable)")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("unexpected argument: %s", args[0])
+			}
+			return Commit(ctx, messages)
+		},
+	}
+}
+
+// Commit records the staged files with the given message paragraphs
+func Commit(ctx *cli.Context, messages []string) error {
+	if len(messages) == 0 {
+		return cli.Usagef("a commit message is required: gitr commit -m \"message\"")
+	}
+
+	var args []string
+	for _, message := range messages {
+		args = append(args, "-m", message)
 	}
 
 	response, err := llm.SendCommand("git commit", args)
@@ -16,6 +42,18 @@ func Commit(args []string) error {
 		return err
 	}
 
-	fmt.Println(response)
+	if err := repo.CommitIndex(); err != nil {
+		return err
+	}
+
+	branch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return err
+	}
+	if err := repo.WriteRef(branch, repo.GenerateCommitHash()); err != nil {
+		return err
+	}
+
+	respond(ctx, "git commit", args, response)
 	return nil
 }
@@ -0,0 +1,224 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// llmCommands are the git commands sent to the LLM, and so the names that
+// llm.command_profile.<command> and prompt templates can refer to
+var llmCommands = []string{"add", "branch", "checkout", "commit", "diff", "log", "merge", "status"}
+
+var completionScripts = map[string]string{
+	"bash": `# bash completion for gitr
+# Load with: source <(gitr completion bash)
+_gitr() {
+    local IFS=$'\n'
+    COMPREPLY=($(gitr __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    # Keep the cursor after a directory's slash
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _gitr gitr
+`,
+	"zsh": `#compdef gitr
+# zsh completion for gitr
+# Load with: source <(gitr completion zsh)
+_gitr() {
+    local -a completions
+    local c
+    completions=("${(@f)$(gitr __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    for c in $completions; do
+        [[ -z $c ]] && continue
+        if [[ $c == */ ]]; then
+            compadd -Q -S '' -- "$c"
+        else
+            compadd -Q -- "$c"
+        fi
+    done
+}
+compdef _gitr gitr
+`,
+	"fish": `# fish completion for gitr
+# Load with: gitr completion fish | source
+function __gitr_complete
+    set -l words (commandline -opc) (commandline -ct)
+    gitr __complete -- $words[2..-1] 2>/dev/null
+end
+complete -c gitr -f -a '(__gitr_complete)'
+`,
+}
+
+func completionCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "completion",
+		Args:    "bash|zsh|fish",
+		Summary: "Print a shell completion script",
+		Help: `Add one of these to your shell's startup file:
+  source <(gitr completion bash)     # ~/.bashrc
+  source <(gitr completion zsh)      # ~/.zshrc
+  gitr completion fish | source      # ~/.config/fish/config.fish`,
+		NoRepo: true,
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) != 1 {
+				return cli.Usagef("usage: gitr completion bash|zsh|fish")
+			}
+			script, ok := completionScripts[args[0]]
+			if !ok {
+				return cli.Usagef("unsupported shell: %s (expected bash, zsh or fish)", args[0])
+			}
+			ctx.Printf("%s", script)
+			ctx.SetResult(map[string]string{"shell": args[0], "script": script})
+			return nil
+		},
+		Complete: func(args []string, cur string) []string {
+			if len(args) > 0 {
+				return nil
+			}
+			return []string{"bash", "fish", "zsh"}
+		},
+	}
+}
+
+// completeCommand is the hidden entry point completion scripts call with
+// the words typed so far. It only reads local state and never calls the LLM.
+func completeCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "__complete",
+		Summary:     "Print completions for the words typed so far",
+		Hidden:      true,
+		NoRepo:      true,
+		PassThrough: true,
+		Run: func(ctx *cli.Context, args []string) error {
+			for _, suggestion := range ctx.App().Complete(args) {
+				fmt.Fprintln(ctx.Stdout, suggestion)
+			}
+			return nil
+		},
+	}
+}
+
+// CompleteFlag suggests values for global flags
+func CompleteFlag(name, cur string) []string {
+	if name == "profile" {
+		return completeProfiles()
+	}
+	return nil
+}
+
+// completeBranches suggests branch names for the first argument
+func completeBranches(args []string, cur string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	branches, err := repo.ListBranches()
+	if err != nil {
+		return nil
+	}
+	return branches
+}
+
+// completePaths suggests files and directories for every argument
+func completePaths(args []string, cur string) []string {
+	return cli.CompletePaths(cur, false)
+}
+
+// completeAddArgs suggests ".", the only argument gitr add accepts
+func completeAddArgs(args []string, cur string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	return []string{"."}
+}
+
+// completeRemotes suggests the configured remote names for the first
+// argument
+func completeRemotes(args []string, cur string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	return config.RemoteNames(cfg)
+}
+
+// completeProfiles suggests the configured profile names
+func completeProfiles() []string {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil
+	}
+	names := []string{config.DefaultProfile}
+	for name := range cfg.Profiles {
+		if name != config.DefaultProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// completeConfigKeys suggests config keys, filling "*" segments with the
+// names already in use
+func completeConfigKeys(args []string, cur string) []string {
+	if len(args) > 0 {
+		return nil
+	}
+
+	var keys []string
+	for _, option := range config.Schema {
+		switch {
+		case !strings.Contains(option.Key, "*"):
+			keys = append(keys, option.Key)
+		case strings.HasPrefix(option.Key, "profiles.*."):
+			for _, name := range completeProfiles() {
+				keys = append(keys, strings.Replace(option.Key, "*", name, 1))
+			}
+		case strings.HasPrefix(option.Key, "remote.*."):
+			for _, name := range completeRemotes(nil, cur) {
+				keys = append(keys, strings.Replace(option.Key, "*", name, 1))
+			}
+		case option.Key == "branch.**.remote":
+			for _, branch := range completeBranches(nil, cur) {
+				keys = append(keys, config.TrackingKey(branch))
+			}
+		case option.Key == "llm.command_profile.*":
+			for _, name := range llmCommands {
+				keys = append(keys, "llm.command_profile."+name)
+			}
+		}
+	}
+	return keys
+}
+
+// completeConfigSet suggests a key, then the values its type allows
+func completeConfigSet(args []string, cur string) []string {
+	switch len(args) {
+	case 0:
+		return completeConfigKeys(args, cur)
+	case 1:
+		option, err := config.LookupOption(args[0])
+		if err != nil {
+			return nil
+		}
+		switch {
+		case option.Type == config.TypeBool:
+			return []string{"false", "true"}
+		case option.Type == config.TypeEnum:
+			return option.Values
+		case strings.HasSuffix(option.Key, ".profile") || strings.HasPrefix(option.Key, "llm.command_profile."):
+			return completeProfiles()
+		case option.Key == "branch.**.remote":
+			return completeRemotes(nil, cur)
+		}
+	}
+	return nil
+}
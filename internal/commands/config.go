@@ -1,48 +1,332 @@
 package commands
 
 import (
+	"flag"
 	"fmt"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/config"
 )
 
-func Config(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: gitr config <command> [args]\n  gitr config set <key> <value>\n  gitr config get <key>")
+// configFlags holds the scope flags shared by every config subcommand
+type configFlags struct {
+	global, local, system bool
+	showOrigin            bool
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.global, "global", false, "use the user's global config file")
+	fs.BoolVar(&f.local, "local", false, "use the repository config file")
+	fs.BoolVar(&f.system, "system", false, "use the system config file")
+	fs.BoolVar(&f.showOrigin, "show-origin", false, "show where each value comes from")
+}
+
+// merge combines flags given before and after the subcommand name
+func (f *configFlags) merge(other *configFlags) *configFlags {
+	return &configFlags{
+		global:     f.global || other.global,
+		local:      f.local || other.local,
+		system:     f.system || other.system,
+		showOrigin: f.showOrigin || other.showOrigin,
+	}
+}
+
+// scope returns the single config file selected, or "" for the merged view
+func (f *configFlags) scope() (config.Scope, error) {
+	var scope config.Scope
+	selected := 0
+	for _, choice := range []struct {
+		set   bool
+		scope config.Scope
+	}{{f.global, config.ScopeGlobal}, {f.local, config.ScopeLocal}, {f.system, config.ScopeSystem}} {
+		if choice.set {
+			scope = choice.scope
+			selected++
+		}
 	}
+	if selected > 1 {
+		return "", cli.Usagef("only one of --global, --local and --system may be given")
+	}
+	return scope, nil
+}
 
-	subcommand := args[0]
+// ConfigValue is the JSON form of a config value
+type ConfigValue struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Origin string `json:"origin,omitempty"`
+}
 
-	switch subcommand {
-	case "set":
-		if len(args) != 3 {
-			return fmt.Errorf("usage: gitr config set <key> <value>")
+func configCommand() *cli.Command {
+	// Scope flags may come before or after the subcommand name
+	parent, child := &configFlags{}, &configFlags{}
+	var flags *configFlags
+	scoped := func(fn func(ctx *cli.Context, scope config.Scope, args []string) error) func(*cli.Context, []string) error {
+		return func(ctx *cli.Context, args []string) error {
+			flags = parent.merge(child)
+			scope, err := flags.scope()
+			if err != nil {
+				return err
+			}
+			return fn(ctx, scope, args)
 		}
-		key := args[1]
-		value := args[2]
+	}
 
-		if err := config.Set(key, value); err != nil {
+	return &cli.Command{
+		Name:    "config",
+		Summary: "Get and set configuration values",
+		NoRepo:  true,
+		Flags:   parent.register,
+		Subcommands: []*cli.Command{
+			{
+				Name:     "set",
+				Complete: completeConfigSet,
+				Args:     "<key> <value>",
+				Summary:  "Set a configuration value",
+				NoRepo:   true,
+				Flags:    child.register,
+				Run: scoped(func(ctx *cli.Context, scope config.Scope, args []string) error {
+					if len(args) != 2 {
+						return cli.Usagef("usage: gitr config set <key> <value>")
+					}
+					return ConfigSet(ctx, scope, args[0], args[1])
+				}),
+			},
+			{
+				Name:     "get",
+				Complete: completeConfigKeys,
+				Args:     "<key>",
+				Summary:  "Get a configuration value",
+				NoRepo:   true,
+				Flags:    child.register,
+				Run: scoped(func(ctx *cli.Context, scope config.Scope, args []string) error {
+					if len(args) != 1 {
+						return cli.Usagef("usage: gitr config get <key>")
+					}
+					return ConfigGet(ctx, scope, args[0], flags.showOrigin)
+				}),
+			},
+			{
+				Name:     "unset",
+				Complete: completeConfigKeys,
+				Args:     "<key>",
+				Summary:  "Remove a configuration value",
+				NoRepo:   true,
+				Flags:    child.register,
+				Run: scoped(func(ctx *cli.Context, scope config.Scope, args []string) error {
+					if len(args) != 1 {
+						return cli.Usagef("usage: gitr config unset <key>")
+					}
+					return ConfigUnset(ctx, scope, args[0])
+				}),
+			},
+			{
+				Name:    "list",
+				Summary: "List configuration values",
+				NoRepo:  true,
+				Flags:   child.register,
+				Run: scoped(func(ctx *cli.Context, scope config.Scope, args []string) error {
+					if len(args) != 0 {
+						return cli.Usagef("usage: gitr config list")
+					}
+					return ConfigList(ctx, scope, flags.showOrigin)
+				}),
+			},
+			{
+				Name:     "describe",
+				Complete: completeConfigKeys,
+				Args:     "[key]",
+				Summary:  "List every option with its type and default",
+				NoRepo:   true,
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 1 {
+						return cli.Usagef("usage: gitr config describe [key]")
+					}
+					return ConfigDescribe(ctx, args)
+				},
+			},
+		},
+	}
+}
+
+// ConfigSet sets a value in one config file, or the default one if scope is ""
+func ConfigSet(ctx *cli.Context, scope config.Scope, key, value string) error {
+	if scope == "" {
+		scope = config.DefaultScope()
+	}
+	result := map[string]any{"key": key, "scope": scope, "helper": false}
+	ctx.SetResult(result)
+
+	if config.IsSecret(key) {
+		usedHelper, err := config.SetSecret(scope, key, value)
+		if err != nil {
 			return err
 		}
+		if usedHelper {
+			result["helper"] = true
+			ctx.Printf("Stored %s in credential helper\n", key)
+			return nil
+		}
+	} else if err := config.SetIn(scope, key, value); err != nil {
+		return err
+	}
+
+	ctx.Printf("Set %s (%s)\n", key, scope)
+
+	// Configuring a helper moves an existing plaintext secret into it
+	if secret := strings.TrimSuffix(key, "_helper"); secret != key && config.IsSecret(secret) {
+		moved, err := config.MoveSecretToHelper(scope, secret)
+		if err != nil {
+			return fmt.Errorf("failed to move %s into credential helper: %w", secret, err)
+		}
+		if moved {
+			result["moved"] = secret
+			ctx.Printf("Moved %s from %s config into credential helper\n", secret, scope)
+		}
+	}
+	return nil
+}
+
+// ConfigGet prints a merged value, or the value from one config file
+func ConfigGet(ctx *cli.Context, scope config.Scope, key string, showOrigin bool) error {
+	if scope != "" {
+		return configGetIn(ctx, scope, key, showOrigin)
+	}
+
+	value, err := config.Get(key)
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf("Set %s\n", key)
+	entry, ok, err := config.Lookup(key)
+	if err != nil {
+		return err
+	}
+	origin := "default"
+	if ok {
+		origin = entry.Origin.String()
+	}
+
+	display := config.DisplayValue(key, value)
+	ctx.SetResult(&ConfigValue{Key: key, Value: display, Origin: origin})
+	if showOrigin {
+		ctx.Printf("%s\t%s\n", origin, display)
 		return nil
+	}
+	ctx.Println(display)
+	return nil
+}
+
+// configGetIn prints a value from a single config file
+func configGetIn(ctx *cli.Context, scope config.Scope, key string, showOrigin bool) error {
+	entries, err := config.ListIn(scope)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Key != key {
+			continue
+		}
+		display := config.DisplayValue(key, entry.Value)
+		ctx.SetResult(&ConfigValue{Key: key, Value: display, Origin: entry.Origin.String()})
+		if showOrigin {
+			ctx.Printf("%s\t", entry.Origin)
+		}
+		ctx.Println(display)
+		return nil
+	}
+
+	return fmt.Errorf("%s is not set in %s config", key, scope)
+}
+
+// ConfigUnset removes a value from one config file, or the default one if scope is ""
+func ConfigUnset(ctx *cli.Context, scope config.Scope, key string) error {
+	if scope == "" {
+		scope = config.DefaultScope()
+	}
+	if err := config.Unset(scope, key); err != nil {
+		return err
+	}
+
+	ctx.Printf("Unset %s (%s)\n", key, scope)
+	ctx.SetResult(map[string]any{"key": key, "scope": scope})
+	return nil
+}
 
-	case "get":
-		if len(args) != 2 {
-			return fmt.Errorf("usage: gitr config get <key>")
+// ConfigList prints every merged value, or those of one config file
+func ConfigList(ctx *cli.Context, scope config.Scope, showOrigin bool) error {
+	var entries []config.Entry
+	var err error
+	if scope != "" {
+		entries, err = config.ListIn(scope)
+	} else {
+		entries, err = config.List()
+	}
+	if err != nil {
+		return err
+	}
+
+	values := []ConfigValue{}
+	for _, entry := range entries {
+		display := config.DisplayValue(entry.Key, entry.Value)
+		values = append(values, ConfigValue{Key: entry.Key, Value: display, Origin: entry.Origin.String()})
+		if showOrigin {
+			ctx.Printf("%s\t", entry.Origin)
 		}
-		key := args[1]
+		ctx.Printf("%s=%s\n", entry.Key, display)
+	}
+	ctx.SetResult(map[string]any{"values": values})
+	return nil
+}
+
+// OptionInfo is the JSON form of a schema option
+type OptionInfo struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	Default     string   `json:"default,omitempty"`
+	Description string   `json:"description"`
+	Values      []string `json:"values,omitempty"`
+	Secret      bool     `json:"secret,omitempty"`
+}
 
-		value, err := config.Get(key)
+// ConfigDescribe lists the options in the config schema
+func ConfigDescribe(ctx *cli.Context, keys []string) error {
+	options := config.Schema
+	if len(keys) == 1 {
+		option, err := config.LookupOption(keys[0])
 		if err != nil {
 			return err
 		}
+		options = []*config.Option{option}
+	}
 
-		fmt.Println(value)
+	infos := []OptionInfo{}
+	for _, option := range options {
+		infos = append(infos, OptionInfo{
+			Key:         option.Key,
+			Type:        option.Type.String(),
+			Default:     option.Default,
+			Description: option.Description,
+			Values:      option.Values,
+			Secret:      option.Secret,
+		})
+	}
+	ctx.SetResult(map[string]any{"options": infos})
+	if ctx.JSON {
 		return nil
+	}
 
-	default:
-		return fmt.Errorf("unknown config command: %s", subcommand)
+	w := tabwriter.NewWriter(ctx.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, option := range options {
+		def := option.Default
+		if def == "" {
+			def = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", option.Key, option.Describe(), def, option.Description)
 	}
+	return w.Flush()
 }
@@ -1,17 +1,29 @@
 package commands
 
 import (
-	"fmt"
-
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/color"
 	"github.com/mysticshirou/gitroulette/internal/llm"
 )
 
-func Diff() error {
-	response, err := llm.SendCommand("git diff", []string{})
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "diff",
+		Args:     "[path...]",
+		Summary:  "Show changes",
+		Run:      Diff,
+		Complete: completePaths,
+	}
+}
+
+// Diff shows changes, limited to the given paths if any
+func Diff(ctx *cli.Context, paths []string) error {
+	response, err := llm.SendCommand("git diff", paths)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(response)
+	ctx.StartPager()
+	respondColored(ctx, "git diff", paths, response, color.Diff)
 	return nil
 }
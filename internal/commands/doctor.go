@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func doctorCommand() *cli.Command {
+	var fix bool
+	return &cli.Command{
+		Name:    "doctor",
+		Summary: "Check the repository for stale locks and corrupt state",
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&fix, "fix", false, "remove stale locks")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("usage: gitr doctor [--fix]")
+			}
+			return Doctor(ctx, fix)
+		},
+	}
+}
+
+// Check is one line of 'gitr doctor' output
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // "ok", "warning" or "problem"
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// Doctor checks the repository for problems left behind by crashed or
+// interrupted gitr processes
+func Doctor(ctx *cli.Context, fix bool) error {
+	var checks []Check
+	report := func(name, status, format string, args ...any) *Check {
+		checks = append(checks, Check{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+		return &checks[len(checks)-1]
+	}
+
+	// Lock
+	info, err := repo.ReadLock()
+	switch {
+	case err != nil:
+		report("lock", "problem", "%v", err)
+	case info == nil:
+		report("lock", "ok", "repository is not locked")
+	case info.Stale():
+		if fix {
+			if _, err := repo.RemoveStaleLock(); err != nil {
+				report("lock", "problem", "stale lock could not be removed: %v", err)
+			} else {
+				report("lock", "ok", "removed stale lock")
+			}
+		} else {
+			check := report("lock", "problem", "stale lock from pid %d on %s (%s old)", info.PID, info.Hostname, info.Age().Round(time.Second))
+			check.Hint = "Run 'gitr doctor --fix' to remove it"
+		}
+	default:
+		report("lock", "warning", "held by running process %d on %s for %s", info.PID, info.Hostname, info.Age().Round(time.Second))
+	}
+
+	// HEAD
+	if branch, err := repo.GetCurrentBranch(); err != nil {
+		report("HEAD", "problem", "%v", err)
+	} else if branch == "" {
+		report("HEAD", "problem", "empty branch name")
+	} else {
+		report("HEAD", "ok", "%s", branch)
+	}
+
+	// History
+	if history, err := repo.LoadHistory(); err != nil {
+		report("history", "problem", "%v", err)
+	} else {
+		report("history", "ok", "%d messages", len(history.Messages))
+	}
+
+	// Config, and whether the default LLM profile has what it needs
+	if err := config.Validate(); err != nil {
+		report("config", "problem", "%v", err)
+	} else if cfg, err := config.Load(); err != nil {
+		report("config", "problem", "%v", err)
+	} else if err := config.CheckProfile(cfg, ""); err != nil {
+		report("config", "problem", "%v", err)
+	} else {
+		report("config", "ok", "ok")
+	}
+
+	problems := 0
+	for _, check := range checks {
+		mark := "✓"
+		switch check.Status {
+		case "problem":
+			mark = "✗"
+			problems++
+		case "warning":
+			mark = "!"
+		}
+		ctx.Printf("%s %s: %s\n", mark, check.Name, check.Message)
+		if check.Hint != "" {
+			ctx.Printf("  %s\n", check.Hint)
+		}
+	}
+	ctx.SetResult(map[string]any{"checks": checks, "problems": problems})
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
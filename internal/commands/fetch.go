@@ -0,0 +1,373 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+	"github.com/mysticshirou/gitroulette/internal/trace"
+)
+
+func fetchCommand() *cli.Command {
+	var all bool
+	return &cli.Command{
+		Name:     "fetch",
+		Args:     "[<remote>]",
+		Summary:  "Download a remote's branches and history without changing yours",
+		Complete: completeRemotes,
+		Help: `Records every branch of the remote in .gitr/refs/remotes/<remote>/<branch>
+without touching the working tree or the local history; branches that
+haven't moved since they were recorded aren't downloaded again.
+'gitr status' and 'gitr remote status' then compare with what was
+fetched, and the next pull still merges from the state of the last push
+or pull.
+
+Fetches from the named remote, or the one the current branch tracks, or
+origin; --all fetches from every configured remote.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&all, "all", false, "fetch from every configured remote")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			switch {
+			case len(args) > 1:
+				return cli.Usagef("unexpected argument: %s", args[1])
+			case all && len(args) == 1:
+				return cli.Usagef("--all doesn't take a remote name")
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return Fetch(ctx, name, all)
+		},
+	}
+}
+
+// FetchedBranch is the JSON result for one fetched remote branch
+type FetchedBranch struct {
+	Remote   string `json:"remote"`
+	Branch   string `json:"branch"`
+	Head     string `json:"head"`
+	Messages int    `json:"messages"`
+
+	// Status is "new", "updated" or "up to date"
+	Status string `json:"status"`
+}
+
+// FetchResult is the JSON result of fetch
+type FetchResult struct {
+	Branches []FetchedBranch `json:"branches"`
+	Tracking *TrackingStatus `json:"tracking,omitempty"`
+}
+
+// Fetch records the branches of a remote, named or found by remoteFor, or
+// of every remote, in the remote-tracking refs
+func Fetch(ctx *cli.Context, name string, all bool) error {
+	currentBranch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	names := []string{}
+	if all {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		names = config.RemoteNames(cfg)
+		if len(names) == 0 {
+			return fmt.Errorf("no remotes configured. Run: gitr remote add <name> <remote-url>/<repo-id>")
+		}
+	} else {
+		if name, err = remoteFor(currentBranch, name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+
+	result := &FetchResult{Branches: []FetchedBranch{}}
+	for _, name := range names {
+		fetched, err := fetchRemote(ctx, name)
+		if err != nil {
+			return err
+		}
+		result.Branches = append(result.Branches, fetched...)
+	}
+
+	if result.Tracking, err = trackingStatus(currentBranch); err != nil {
+		return err
+	}
+	if result.Tracking != nil {
+		ctx.Println(result.Tracking.Describe())
+	}
+	ctx.SetResult(result)
+	return nil
+}
+
+// fetchRemote downloads every branch of one remote into its refs
+func fetchRemote(ctx *cli.Context, name string) ([]FetchedBranch, error) {
+	client, err := newRemoteClient(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Printf("Fetching %s...\n", name)
+	branches, err := client.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+
+	fetched := []FetchedBranch{}
+	negotiate := true
+	for _, branch := range branches {
+		if err := repo.ValidateBranchName(branch); err != nil {
+			return nil, fmt.Errorf("remote sent %w", err)
+		}
+
+		// Ask first whether the branch moved, and download it only if so
+		if negotiate {
+			old, err := repo.ReadRemoteRef(name, branch)
+			if err != nil {
+				return nil, err
+			}
+			current, err := client.Negotiate(&remote.NegotiateRequest{Branch: branch})
+			if errors.Is(err, remote.ErrNotSupported) {
+				trace.Printf("downloading every branch: %v", err)
+				negotiate = false
+			} else if err != nil {
+				return nil, fmt.Errorf("fetch failed: %w", err)
+			} else if old != nil && unchanged(old, current) {
+				if err := touchFetchedRef(name, branch, current); err != nil {
+					return nil, err
+				}
+				ctx.Printf("  %s/%s: up to date\n", name, branch)
+				fetched = append(fetched, FetchedBranch{
+					Remote:   name,
+					Branch:   branch,
+					Head:     old.Head,
+					Messages: logLength(cfg, old, branch),
+					Status:   "up to date",
+				})
+				continue
+			}
+		}
+
+		pullData, err := client.PullBranch(branch, 0)
+		if err != nil {
+			return nil, fmt.Errorf("fetch failed: %w", err)
+		}
+		if pullData.Branch != branch {
+			return nil, fmt.Errorf("fetch failed: the remote sent branch %s instead of %s", pullData.Branch, branch)
+		}
+		history, err := fromRemoteMessages(branchLog(cfg, pullData))
+		if err != nil {
+			return nil, err
+		}
+
+		state := remoteState(pullData.Head, pullData.HistoryPosition(), remote.PositionsOf(pullData.Histories), pullData.Files)
+		status, err := writeFetchedRef(name, branch, state, history.Messages)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Printf("  %s/%s: %s (%d messages)\n", name, branch, status, len(history.Messages))
+		fetched = append(fetched, FetchedBranch{
+			Remote:   name,
+			Branch:   branch,
+			Head:     state.Head,
+			Messages: len(history.Messages),
+			Status:   status,
+		})
+	}
+	return fetched, nil
+}
+
+// touchFetchedRef records that a remote branch was found unchanged, unless
+// its ref changed meanwhile
+func touchFetchedRef(name, branch string, current *remote.NegotiateResponse) error {
+	return repo.WithLock(func() error {
+		ref, err := repo.ReadRemoteRef(name, branch)
+		if err != nil || ref == nil || !unchanged(ref, current) {
+			return err
+		}
+		ref.Updated = time.Now()
+		return repo.WriteRemoteRef(name, branch, ref)
+	})
+}
+
+// unchanged reports whether a remote branch is still as its ref records:
+// same newest commit, history and per-branch logs. Servers that don't say
+// what the head is count as changed.
+func unchanged(ref *repo.RemoteRef, current *remote.NegotiateResponse) bool {
+	if current.Head == "" || current.Head != ref.Head {
+		return false
+	}
+	if current.History != (remote.Position{Length: ref.HistoryLength, Hash: ref.HistoryHash}) || len(current.Histories) != len(ref.Histories) {
+		return false
+	}
+	for branch, position := range current.Histories {
+		if recorded, ok := ref.Histories[branch]; !ok || recorded != (repo.LogPosition{Length: position.Length, Hash: position.Hash}) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFetchedRef records a fetched remote branch, keeping the state of
+// the last push or pull as the ref's base, and says whether the branch is
+// new, updated or up to date
+func writeFetchedRef(name, branch string, state repo.RemoteState, history []repo.Message) (string, error) {
+	status := "new"
+	err := repo.WithLock(func() error {
+		old, err := repo.ReadRemoteRef(name, branch)
+		if err != nil {
+			return err
+		}
+
+		// Never pushed or pulled: the next pull starts from an empty remote
+		base := repo.RemoteState{HistoryHash: remote.PositionOf(nil).Hash}
+		if old != nil {
+			base = old.Synced()
+			status = "updated"
+			if sameState(old.RemoteState, state) {
+				status = "up to date"
+			}
+		}
+
+		ref := &repo.RemoteRef{RemoteState: state, History: history, Updated: time.Now()}
+		if !sameState(base, state) {
+			ref.Base = &base
+		}
+		return repo.WriteRemoteRef(name, branch, ref)
+	})
+	return status, err
+}
+
+// sameState reports whether two states of a remote branch are the same
+func sameState(a, b repo.RemoteState) bool {
+	return a.Head == b.Head && a.HistoryLength == b.HistoryLength && a.HistoryHash == b.HistoryHash &&
+		maps.Equal(a.Histories, b.Histories) && maps.Equal(a.Tree, b.Tree)
+}
+
+// TrackingStatus compares a local branch's history with its remote-tracking
+// ref, counting the turns only one side has
+type TrackingStatus struct {
+	Branch string `json:"branch"`
+	Remote string `json:"remote"`
+
+	// Known is false if nothing has been fetched, pushed or pulled that
+	// says what the remote's history holds
+	Known   bool      `json:"known"`
+	Ahead   int       `json:"ahead"`
+	Behind  int       `json:"behind"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Describe says how the branch compares, like git status does
+func (s *TrackingStatus) Describe() string {
+	upstream := s.Remote + "/" + s.Branch
+	switch {
+	case !s.Known:
+		return fmt.Sprintf("Your branch tracks '%s', which hasn't been fetched yet (run 'gitr fetch').", upstream)
+	case s.Ahead > 0 && s.Behind > 0:
+		return fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different turn(s) each, respectively.\n  (use \"gitr pull\" to merge the remote history into yours)", upstream, s.Ahead, s.Behind)
+	case s.Ahead > 0:
+		return fmt.Sprintf("Your branch is ahead of '%s' by %d turn(s).\n  (use \"gitr push\" to publish your turns)", upstream, s.Ahead)
+	case s.Behind > 0:
+		return fmt.Sprintf("Your branch is behind '%s' by %d turn(s).\n  (use \"gitr pull\" to update your history)", upstream, s.Behind)
+	default:
+		return fmt.Sprintf("Your branch is up to date with '%s'.", upstream)
+	}
+}
+
+// trackingStatus compares a local branch with the remote it tracks, or
+// with origin if it tracks none. It returns nil if the branch tracks
+// nothing and nothing was recorded of its origin counterpart.
+func trackingStatus(branch string) (*TrackingStatus, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.TrackedRemote(branch)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = config.DefaultRemote
+		if ref, err := repo.ReadRemoteRef(name, branch); err != nil || ref == nil {
+			return nil, err
+		}
+	}
+	return compareBranch(cfg, name, branch)
+}
+
+// compareBranch compares a local branch's history with what was recorded
+// of the same branch of a remote
+func compareBranch(cfg *config.Config, name, branch string) (*TrackingStatus, error) {
+	status := &TrackingStatus{Branch: branch, Remote: name}
+	ref, err := repo.ReadRemoteRef(name, branch)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil || (ref.History == nil && logLength(cfg, ref, branch) > 0) {
+		return status, nil
+	}
+
+	log := repo.SharedHistory()
+	if cfg.History.PerBranch {
+		log = repo.BranchHistory(branch)
+	}
+	local, err := log.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", log.Name(), err)
+	}
+	shallow, err := repo.ReadShallow()
+	if err != nil {
+		return nil, err
+	}
+
+	status.Known, status.Updated = true, ref.Updated
+	status.Ahead, status.Behind = compareHistories(local.Messages, ref.History, shallow)
+	return status, nil
+}
+
+// logLength returns the length of the remote log a ref records for a
+// branch: the branch's own log with histories kept per branch, otherwise
+// the shared history
+func logLength(cfg *config.Config, ref *repo.RemoteRef, branch string) int {
+	if cfg.History.PerBranch {
+		return ref.Histories[branch].Length
+	}
+	return ref.HistoryLength
+}
+
+// compareHistories counts the turns only ours and only theirs have. A
+// shallow clone's history is compared with the part of theirs it holds.
+func compareHistories(ours, theirs []repo.Message, shallow *repo.Shallow) (int, int) {
+	if shallow != nil && len(theirs) >= shallow.Length {
+		omitted := toRemoteMessages(&repo.History{Messages: theirs[:shallow.Length]})
+		if remote.PositionOf(omitted).Hash == shallow.Hash {
+			theirs = theirs[shallow.Length:]
+		}
+	}
+
+	n := 0
+	for n < len(ours) && n < len(theirs) && sameMessage(ours[n], theirs[n]) {
+		n++
+	}
+	countTurns := func(messages []repo.Message) int {
+		return len(turnMessages(&repo.History{Messages: messages}))
+	}
+	return countTurns(unknownTurns(ours[n:], theirs[n:])), countTurns(unknownTurns(theirs[n:], ours[n:]))
+}
@@ -0,0 +1,332 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func historyCommand() *cli.Command {
+	turnArg := func(args []string, usage string) (int, error) {
+		if len(args) != 1 {
+			return 0, cli.Usagef("usage: %s", usage)
+		}
+		turn, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, cli.Usagef("invalid turn number: %s", args[0])
+		}
+		return turn, nil
+	}
+
+	return &cli.Command{
+		Name:    "history",
+		Summary: "List, rewind, edit or restore the conversation history",
+		Subcommands: []*cli.Command{
+			{
+				Name:    "list",
+				Summary: "Show numbered conversation turns",
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 0 {
+						return cli.Usagef("usage: gitr history list")
+					}
+					return withActiveHistory(ctx, historyList)
+				},
+			},
+			{
+				Name:    "rewind",
+				Args:    "<turn>",
+				Summary: "Truncate history after the given turn",
+				Run: func(ctx *cli.Context, args []string) error {
+					turn, err := turnArg(args, "gitr history rewind <turn>")
+					if err != nil {
+						return err
+					}
+					return withActiveHistory(ctx, func(ctx *cli.Context, historyLog repo.HistoryLog) error {
+						return historyRewind(ctx, historyLog, turn)
+					})
+				},
+			},
+			{
+				Name:    "edit",
+				Args:    "<turn>",
+				Summary: "Edit a turn's response in $EDITOR",
+				Run: func(ctx *cli.Context, args []string) error {
+					turn, err := turnArg(args, "gitr history edit <turn>")
+					if err != nil {
+						return err
+					}
+					return withActiveHistory(ctx, func(ctx *cli.Context, historyLog repo.HistoryLog) error {
+						return historyEdit(ctx, historyLog, turn)
+					})
+				},
+			},
+			{
+				Name:    "drop",
+				Args:    "<turn>",
+				Summary: "Remove a single turn from history",
+				Run: func(ctx *cli.Context, args []string) error {
+					turn, err := turnArg(args, "gitr history drop <turn>")
+					if err != nil {
+						return err
+					}
+					return withActiveHistory(ctx, func(ctx *cli.Context, historyLog repo.HistoryLog) error {
+						return historyDrop(ctx, historyLog, turn)
+					})
+				},
+			},
+			{
+				Name:    "restore",
+				Args:    "[backup]",
+				Summary: "Restore history from a backup (undoes undo)",
+				Complete: func(args []string, cur string) []string {
+					historyLog, err := config.ActiveHistory()
+					if err != nil || len(args) > 0 {
+						return nil
+					}
+					backups, err := historyLog.Backups()
+					if err != nil {
+						return nil
+					}
+					for i, name := range backups {
+						backups[i] = strings.TrimSuffix(name, ".json")
+					}
+					return backups
+				},
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 1 {
+						return cli.Usagef("usage: gitr history restore [backup]")
+					}
+					name := ""
+					if len(args) == 1 {
+						name = args[0]
+					}
+					return withActiveHistory(ctx, func(ctx *cli.Context, historyLog repo.HistoryLog) error {
+						return historyRestore(ctx, historyLog, name)
+					})
+				},
+			},
+			{
+				Name:    "backups",
+				Summary: "List history backups",
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 0 {
+						return cli.Usagef("usage: gitr history backups")
+					}
+					return withActiveHistory(ctx, historyBackups)
+				},
+			},
+		},
+	}
+}
+
+// withActiveHistory runs fn on the current branch's conversation log
+func withActiveHistory(ctx *cli.Context, fn func(*cli.Context, repo.HistoryLog) error) error {
+	historyLog, err := config.ActiveHistory()
+	if err != nil {
+		return err
+	}
+	return fn(ctx, historyLog)
+}
+
+// TurnInfo is the JSON form of a turn in 'gitr history list'
+type TurnInfo struct {
+	Number    int       `json:"number"`
+	Timestamp time.Time `json:"timestamp"`
+	Command   string    `json:"command"`
+	Profile   string    `json:"profile,omitempty"`
+	Model     string    `json:"model,omitempty"`
+}
+
+func historyList(ctx *cli.Context, historyLog repo.HistoryLog) error {
+	history, err := historyLog.Load()
+	if err != nil {
+		return err
+	}
+
+	turns := history.Turns()
+	infos := []TurnInfo{}
+	if len(turns) == 0 {
+		ctx.Println("History is empty")
+	}
+
+	for _, turn := range turns {
+		info := TurnInfo{Number: turn.Number, Command: turn.Command()}
+		if turn.User != nil {
+			info.Timestamp = turn.User.Timestamp
+		} else if turn.Assistant != nil {
+			info.Timestamp = turn.Assistant.Timestamp
+		}
+		if turn.Assistant != nil {
+			info.Profile = turn.Assistant.Profile
+			info.Model = turn.Assistant.Model
+		}
+		infos = append(infos, info)
+
+		command := info.Command
+		if command == "" {
+			command = "(no command)"
+		}
+		if info.Profile != "" {
+			command += fmt.Sprintf("  [%s: %s]", info.Profile, info.Model)
+		}
+		ctx.Printf("%4d  %s  %s\n", turn.Number, info.Timestamp.Local().Format("2006-01-02 15:04:05"), command)
+	}
+
+	ctx.SetResult(map[string]any{"history": historyLog.Name(), "turns": infos})
+	return nil
+}
+
+func historyRewind(ctx *cli.Context, historyLog repo.HistoryLog, turn int) error {
+	removed, err := historyLog.Rewind(turn)
+	if err != nil {
+		return err
+	}
+	ctx.SetResult(map[string]int{"turn": turn, "removed": removed})
+	if removed == 0 {
+		ctx.Printf("History already ends at turn %d\n", turn)
+		return nil
+	}
+
+	ctx.Printf("Rewound to turn %d (removed %d turn(s))\n", turn, removed)
+	ctx.Println("Run 'gitr history restore' to undo the rewind")
+	return nil
+}
+
+func historyDrop(ctx *cli.Context, historyLog repo.HistoryLog, turn int) error {
+	if err := historyLog.DropTurn(turn); err != nil {
+		return err
+	}
+
+	ctx.Printf("Dropped turn %d\n", turn)
+	ctx.Println("Run 'gitr history restore' to undo the drop")
+	ctx.SetResult(map[string]int{"dropped": turn})
+	return nil
+}
+
+func historyRestore(ctx *cli.Context, historyLog repo.HistoryLog, name string) error {
+	restored, err := historyLog.Restore(name)
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Restored history from %s\n", restored)
+	ctx.SetResult(map[string]string{"restored": restored})
+	return nil
+}
+
+func historyBackups(ctx *cli.Context, historyLog repo.HistoryLog) error {
+	backups, err := historyLog.Backups()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, name := range backups {
+		names = append(names, strings.TrimSuffix(name, ".json"))
+	}
+	ctx.SetResult(map[string][]string{"backups": names})
+
+	if len(names) == 0 {
+		ctx.Println("No history backups")
+		return nil
+	}
+	for _, name := range names {
+		ctx.Println(name)
+	}
+	return nil
+}
+
+func historyEdit(ctx *cli.Context, historyLog repo.HistoryLog, n int) error {
+	// The lock is held while the editor is open, like git holds index.lock
+	// during a commit message, so turn n can't shift under the edit
+	var turn repo.Turn
+	changed := false
+	err := repo.WithLock(func() error {
+		history, err := historyLog.Load()
+		if err != nil {
+			return err
+		}
+
+		turns := history.Turns()
+		if n < 1 || n > len(turns) {
+			return fmt.Errorf("turn %d out of range (history has %d turns)", n, len(turns))
+		}
+		turn = turns[n-1]
+		if turn.Assistant == nil {
+			return fmt.Errorf("turn %d has no response to edit", n)
+		}
+
+		edited, err := editText(turn.Assistant.Content)
+		if err != nil {
+			return err
+		}
+		if edited == turn.Assistant.Content {
+			return nil
+		}
+		if strings.TrimSpace(edited) == "" {
+			return fmt.Errorf("aborting edit due to empty response; use 'gitr history drop %d' to remove the turn", n)
+		}
+
+		changed = true
+		return historyLog.EditTurn(n, edited)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.SetResult(map[string]any{"turn": n, "changed": changed})
+	if !changed {
+		ctx.Println("No changes made")
+		return nil
+	}
+	ctx.Printf("Updated response for turn %d (%s)\n", n, turn.Command())
+	return nil
+}
+
+// editText opens content in $VISUAL/$EDITOR and returns the saved result
+func editText(content string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := os.CreateTemp("", "gitr-edit-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := f.Name()
+	defer os.Remove(path)
+
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read edited file: %w", err)
+	}
+	return string(data), nil
+}
@@ -1,16 +1,37 @@
 package commands
 
 import (
-	"fmt"
+	"path/filepath"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Init() error {
+func initCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "init",
+		Summary: "Initialize a new repository",
+		NoRepo:  true,
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("unexpected argument: %s", args[0])
+			}
+			return Init(ctx)
+		},
+	}
+}
+
+func Init(ctx *cli.Context) error {
 	if err := repo.Init(); err != nil {
 		return err
 	}
 
-	fmt.Println("Initialized empty gitr repository in .gitr/")
+	path, err := filepath.Abs(repo.GitrDir)
+	if err != nil {
+		return err
+	}
+
+	ctx.Println("Initialized empty gitr repository in .gitr/")
+	ctx.SetResult(map[string]string{"path": path})
 	return nil
 }
@@ -1,17 +1,52 @@
 package commands
 
 import (
-	"fmt"
+	"flag"
+	"strconv"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/color"
 	"github.com/mysticshirou/gitroulette/internal/llm"
 )
 
-func Log() error {
-	response, err := llm.SendCommand("git log", []string{})
+func logCommand() *cli.Command {
+	var count int
+	var oneline bool
+	return &cli.Command{
+		Name:    "log",
+		Summary: "Show commit history",
+		Flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&count, "n", 0, "show at most `count` commits")
+			fs.BoolVar(&oneline, "oneline", false, "show each commit on one line")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("unexpected argument: %s", args[0])
+			}
+			if count < 0 {
+				return cli.Usagef("-n must not be negative")
+			}
+			return Log(ctx, count, oneline)
+		},
+	}
+}
+
+// Log shows the last count commits, or all of them if count is 0
+func Log(ctx *cli.Context, count int, oneline bool) error {
+	var args []string
+	if oneline {
+		args = append(args, "--oneline")
+	}
+	if count > 0 {
+		args = append(args, "-n", strconv.Itoa(count))
+	}
+
+	response, err := llm.SendCommand("git log", args)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(response)
+	ctx.StartPager()
+	respondColored(ctx, "git log", args, response, color.Log)
 	return nil
 }
@@ -3,19 +3,47 @@ package commands
 import (
 	"fmt"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/llm"
 )
 
-func Merge(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("usage: gitr merge <branch>")
+func mergeCommand() *cli.Command {
+	return &cli.Command{
+		Name:     "merge",
+		Args:     "<branch>",
+		Summary:  "Merge a branch into the current branch",
+		Complete: completeBranches,
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) != 1 {
+				return cli.Usagef("usage: gitr merge <branch>")
+			}
+			return Merge(ctx, args[0])
+		},
 	}
+}
 
+func Merge(ctx *cli.Context, branch string) error {
+	// The summary has to be in the log for the LLM to see it, so it is
+	// appended first and taken back out if the merge fails
+	joined, unjoin, err := joinBranchHistory(branch)
+	if err != nil {
+		return fmt.Errorf("failed to join history of %s: %w", branch, err)
+	}
+
+	args := []string{branch}
 	response, err := llm.SendCommand("git merge", args)
 	if err != nil {
+		if unjoin != nil {
+			if uerr := unjoin(); uerr != nil {
+				fmt.Fprintf(ctx.Stderr, "Warning: failed to remove the history summary of %s: %v\n", branch, uerr)
+			}
+		}
 		return err
 	}
+	if joined > 0 {
+		ctx.Printf("Joined %d turn(s) of history from %s\n", joined, branch)
+	}
 
-	fmt.Println(response)
+	respond(ctx, "git merge", args, response)
 	return nil
 }
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func TestMergeKeepsNoSummaryWhenTheLLMFails(t *testing.T) {
+	inNewRepo(t)
+
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer llm.Close()
+
+	for key, value := range map[string]string{
+		"history.per_branch": "true",
+		"api.url":            llm.URL,
+		"api.key":            "test",
+	} {
+		if err := config.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	base := []repo.Message{
+		{Role: "user", Content: "git status", Timestamp: now},
+		{Role: "assistant", Content: "clean", Timestamp: now},
+	}
+	if err := repo.BranchHistory("main").Append(base...); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(time.Second)
+	feature := append(base,
+		repo.Message{Role: "user", Content: "git commit -m feature", Timestamp: later},
+		repo.Message{Role: "assistant", Content: "committed", Timestamp: later},
+	)
+	if err := repo.BranchHistory("feature").Append(feature...); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &cli.Context{Stdout: io.Discard, Stderr: io.Discard}
+	if err := Merge(ctx, "feature"); err == nil {
+		t.Fatal("Merge() succeeded with a failing LLM")
+	}
+
+	history, err := repo.BranchHistory("main").Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(history.Messages); got != len(base) {
+		t.Errorf("main has %d messages after the failed merge, want %d", got, len(base))
+	}
+}
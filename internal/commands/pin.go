@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func pinCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "pin",
+		Args:    "[<fact>...]",
+		Summary: "Pin a fact the LLM must always respect (no args: list)",
+		Run:     Pin,
+	}
+}
+
+func unpinCommand() *cli.Command {
+	var all bool
+	return &cli.Command{
+		Name:    "unpin",
+		Args:    "<n>",
+		Summary: "Remove a pinned fact (--all removes every one)",
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&all, "all", false, "remove every pinned fact")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			switch {
+			case all && len(args) == 0:
+				return UnpinAll(ctx)
+			case !all && len(args) == 1:
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return cli.Usagef("invalid pin number: %s", args[0])
+				}
+				return Unpin(ctx, n)
+			default:
+				return cli.Usagef("usage: gitr unpin <n> or gitr unpin --all")
+			}
+		},
+	}
+}
+
+// Pin records a fact that is sent to the LLM with every command. With no
+// arguments it lists the pinned facts.
+func Pin(ctx *cli.Context, args []string) error {
+	if len(args) == 0 {
+		pins, err := repo.LoadPins()
+		if err != nil {
+			return err
+		}
+		facts := pins.Facts
+		if facts == nil {
+			facts = []string{}
+		}
+		ctx.SetResult(map[string][]string{"facts": facts})
+
+		if len(facts) == 0 {
+			ctx.Println("No pinned facts")
+			return nil
+		}
+		for i, fact := range facts {
+			ctx.Printf("%3d  %s\n", i+1, fact)
+		}
+		return nil
+	}
+
+	fact := strings.Join(args, " ")
+	n, err := repo.AddPin(fact)
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Pinned fact %d\n", n)
+	ctx.SetResult(map[string]any{"number": n, "fact": fact})
+	return nil
+}
+
+// Unpin removes a pinned fact by number
+func Unpin(ctx *cli.Context, n int) error {
+	fact, err := repo.RemovePin(n)
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Unpinned: %s\n", fact)
+	ctx.SetResult(map[string]any{"number": n, "fact": fact})
+	return nil
+}
+
+// UnpinAll removes every pinned fact
+func UnpinAll(ctx *cli.Context) error {
+	n, err := repo.ClearPins()
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Removed %d pinned fact(s)\n", n)
+	ctx.SetResult(map[string]int{"removed": n})
+	return nil
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/llm"
+)
+
+func promptCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "prompt",
+		Summary: "Inspect the prompts gitr sends, without calling the LLM",
+		Subcommands: []*cli.Command{
+			{
+				Name:        "show",
+				Args:        "<command> [args]",
+				Summary:     "Render the prompt for a command without sending it",
+				PassThrough: true,
+				Complete: func(args []string, cur string) []string {
+					if len(args) > 0 {
+						return nil
+					}
+					return llmCommands
+				},
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) < 1 {
+						return cli.Usagef("usage: gitr prompt show <command> [args]")
+					}
+					return PromptShow(ctx, args[0], args[1:])
+				},
+			},
+		},
+	}
+}
+
+// PromptShow prints the prompt gitr would send for a command
+func PromptShow(ctx *cli.Context, name string, args []string) error {
+	command := "git " + strings.TrimPrefix(name, "git ")
+	historyLog, err := config.ActiveHistory()
+	if err != nil {
+		return err
+	}
+
+	prompt, messages, err := llm.BuildMessages(historyLog, command, args)
+	if err != nil {
+		return err
+	}
+
+	history := len(messages) - 2
+	if prompt.Pins != "" {
+		history--
+	}
+	ctx.SetResult(map[string]any{
+		"system":   prompt.System,
+		"pins":     prompt.Pins,
+		"history":  history,
+		"log":      historyLog.Name(),
+		"user":     prompt.User,
+		"messages": messages,
+	})
+
+	ctx.Println("=== system ===")
+	ctx.Println(prompt.System)
+
+	if prompt.Pins != "" {
+		ctx.Println("\n=== system (pinned facts) ===")
+		ctx.Println(prompt.Pins)
+	}
+
+	ctx.Printf("\n=== history: %d message(s) from the %s ===\n", history, historyLog.Name())
+
+	ctx.Println("\n=== user ===")
+	ctx.Println(prompt.User)
+	return nil
+}
@@ -1,65 +1,513 @@
 package commands
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
 	"github.com/mysticshirou/gitroulette/internal/remote"
 	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Pull(args []string) error {
+func pullCommand() *cli.Command {
+	var stats, rebase, stash bool
+	return &cli.Command{
+		Name:     "pull",
+		Args:     "[<remote>]",
+		Summary:  "Pull from the remote repository",
+		Complete: completeRemotes,
+		Help: `Pulls from the named remote, or the one the current branch tracks
+(branch.<name>.remote), or origin. The remote branch with the current
+branch's name is merged into it; if the remote has none, a branch that
+tracks the remote fails and any other gets the remote's default branch.
+Pull never switches branches.
+
+Files changed only on the remote are updated (or deleted), and files changed
+only locally are kept. If a file changed on both sides the pull stops
+before writing anything; --stash moves your versions into .gitr/stash/ and
+takes the remote's.
+
+Turns of history only one side has are kept: interleaved by time and
+followed by a merge turn, or with --rebase, the local turns go after the
+remote's.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&stats, "stats", false, "report bytes sent and received, before and after compression")
+			fs.BoolVar(&rebase, "rebase", false, "put local turns after the remote's instead of merging them by time")
+			fs.BoolVar(&stash, "stash", false, "stash local changes that conflict with the remote instead of stopping")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 1 {
+				return cli.Usagef("unexpected argument: %s", args[1])
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			return Pull(ctx, name, stats, rebase, stash)
+		},
+	}
+}
+
+// PullResult is the JSON result of pull
+type PullResult struct {
+	TransferResult
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+	Stash   string   `json:"stash,omitempty"`
+
+	// History is how the histories were combined: "up to date",
+	// "fast-forward", "local", "merged" or "rebased"
+	History string `json:"history"`
+}
+
+// Pull pulls from a remote, named or found by remoteFor, into the working
+// tree and history
+func Pull(ctx *cli.Context, name string, stats, rebase, stash bool) error {
+	currentBranch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+	if name, err = remoteFor(currentBranch, name); err != nil {
+		return err
+	}
+
 	// Create remote client
-	client, err := remote.NewClient()
+	client, err := newRemoteClient(name)
 	if err != nil {
-		return fmt.Errorf("failed to create remote client: %w\nHint: Configure remote with 'gitr config set remote.url <url>' and 'gitr config set remote.repo_id <id>'", err)
+		return err
 	}
 
-	fmt.Println("Pulling from remote...")
-	pullData, err := client.Pull()
+	// A branch tracking this remote follows its branch of the same name,
+	// as fetch and remote status assume
+	tracked, err := config.TrackedRemote(currentBranch)
+	if err != nil {
+		return err
+	}
+	follows := tracked == name
+
+	// Pull the branch of the same name, or the default branch if the
+	// remote doesn't have it yet; either way HEAD stays where it is
+	ctx.Printf("Pulling from %s...\n", name)
+	pullData, err := client.PullBranch(currentBranch, 0)
+	if errors.Is(err, remote.ErrBranchNotFound) && follows {
+		return fmt.Errorf("pull failed: branch '%s' tracks %s, which has no branch %s\nHint: publish it with 'gitr push', or stop tracking with 'gitr config unset %s'", currentBranch, name, currentBranch, config.TrackingKey(currentBranch))
+	}
+	if errors.Is(err, remote.ErrBranchNotFound) {
+		ctx.Printf("%s has no branch %s; pulling its default branch\n", name, currentBranch)
+		pullData, err = client.Pull()
+	}
 	if err != nil {
 		return fmt.Errorf("pull failed: %w", err)
 	}
+	if follows && pullData.Branch != currentBranch {
+		return fmt.Errorf("pull failed: the remote sent branch %s instead of %s", pullData.Branch, currentBranch)
+	}
 
-	// Update current branch
-	if err := repo.SetCurrentBranch(pullData.Branch); err != nil {
-		return fmt.Errorf("failed to update branch: %w", err)
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	result := &PullResult{
+		TransferResult: TransferResult{Remote: name, Branch: pullData.Branch, Files: len(pullData.Files), Messages: len(branchLog(cfg, pullData))},
+	}
+	err = repo.WithLock(func() error {
+		if err := applyPull(name, pullData, result, rebase, stash); err != nil {
+			return err
+		}
+		// A shallow clone has the whole history now
+		if err := repo.RemoveShallow(); err != nil {
+			return err
+		}
+		return recordPull(cfg, name, pullData)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("✓ Successfully pulled from %s (branch: %s)\n", name, pullData.Branch)
+	ctx.Printf("  Files: %d updated, %d deleted\n", len(result.Updated), len(result.Deleted))
+	ctx.Printf("  History: %s\n", result.History)
+	if result.Stash != "" {
+		ctx.Printf("  Stashed your conflicting changes in %s\n", result.Stash)
+	}
+	if stats {
+		result.Stats = printStats(ctx, client)
 	}
+	ctx.SetResult(result)
 
-	// Write files to disk
+	return nil
+}
+
+// recordPull remembers the state of a pulled remote branch
+func recordPull(cfg *config.Config, name string, pullData *remote.PullData) error {
+	history, err := fromRemoteMessages(branchLog(cfg, pullData))
+	if err != nil {
+		return err
+	}
+	state := remoteState(pullData.Head, pullData.HistoryPosition(), remote.PositionsOf(pullData.Histories), pullData.Files)
+	return recordRemoteRef(name, pullData.Branch, state, history.Messages)
+}
+
+// applyPull writes state pulled from the named remote into the current
+// branch, without switching branches; the caller holds the lock. Conflicts
+// are found before anything is written.
+func applyPull(name string, pullData *remote.PullData, result *PullResult, rebase, stash bool) error {
 	root, err := repo.GetGitrRoot()
 	if err != nil {
 		return fmt.Errorf("failed to get repository root: %w", err)
 	}
+	currentBranch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
 
-	for path, content := range pullData.Files {
-		filePath := fmt.Sprintf("%s/%s", root, path)
-		if err := repo.WriteFile(filePath, content); err != nil {
+	if err := repo.ValidateBranchName(pullData.Branch); err != nil {
+		return fmt.Errorf("remote sent %w", err)
+	}
+	for branch := range pullData.Histories {
+		if err := repo.ValidateBranchName(branch); err != nil {
+			return fmt.Errorf("remote sent history for %w", err)
+		}
+	}
+	for path := range pullData.Files {
+		if err := repo.ValidatePath(path); err != nil {
+			return fmt.Errorf("remote sent %w", err)
+		}
+	}
+
+	plan, err := planPull(root, name, pullData)
+	if err != nil {
+		return err
+	}
+	if len(plan.conflicts) > 0 {
+		if !stash {
+			return conflictError(plan.conflicts)
+		}
+		if result.Stash, err = stashFiles(root, plan.conflicts, plan.local); err != nil {
+			return err
+		}
+	}
+
+	// Write files changed upstream and delete those removed upstream
+	for _, path := range plan.update {
+		if err := repo.WriteFile(plan.targets[path], pullData.Files[path]); err != nil {
 			return fmt.Errorf("failed to write file %s: %w", path, err)
 		}
 	}
+	for _, path := range plan.delete {
+		if err := os.Remove(plan.targets[path]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete file %s: %w", path, err)
+		}
+	}
+	result.Updated, result.Deleted = plan.update, plan.delete
+
+	// Combine histories
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	historyLog, err := config.HistoryFor(cfg)
+	if err != nil {
+		return err
+	}
+	if result.History, err = pullHistory(historyLog, pullData.Branch, branchLog(cfg, pullData), rebase); err != nil {
+		return err
+	}
+
+	if cfg.History.PerBranch {
+		for branch, messages := range pullData.Histories {
+			if branch == currentBranch {
+				continue
+			}
+			if _, err := pullHistory(repo.BranchHistory(branch), branch, messages, rebase); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// branchLog returns the remote's version of the log the pulled branch
+// keeps its history in: its own log with histories kept per branch,
+// otherwise the shared history
+func branchLog(cfg *config.Config, pullData *remote.PullData) []remote.Message {
+	if cfg.History.PerBranch {
+		return pullData.Histories[pullData.Branch]
+	}
+	return pullData.History
+}
+
+// pullPlan is what a pull will do to the working tree
+type pullPlan struct {
+	local     map[string]string // local file contents
+	update    []string          // files to write with the remote's content
+	delete    []string          // files removed upstream
+	conflicts []fileConflict
+
+	// targets maps each path to update or delete to where it is on disk
+	targets map[string]string
+}
+
+// fileConflict is a file changed both locally and on the remote
+type fileConflict struct {
+	path string
+
+	// uncommitted is set if the local change hasn't been committed
+	uncommitted bool
+}
+
+// planPull compares each file's local and remote content with what the
+// remote had at the last push or pull: a side that hasn't changed since
+// gives way to the one that has
+func planPull(root, name string, pullData *remote.PullData) (*pullPlan, error) {
+	local, err := repo.GetAllFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository files: %w", err)
+	}
+	ref, err := repo.ReadRemoteRef(name, pullData.Branch)
+	if err != nil {
+		return nil, err
+	}
+	base := map[string]string{}
+	if ref != nil && ref.Synced().Tree != nil {
+		base = ref.Synced().Tree
+	}
+	index, err := repo.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := func(files map[string]string) map[string]string {
+		hashed := map[string]string{}
+		for path, content := range files {
+			hashed[filepath.ToSlash(path)] = repo.HashContent(content)
+		}
+		return hashed
+	}
+	ours, theirs := hashes(local), hashes(pullData.Files)
+
+	paths := map[string]bool{}
+	for _, tree := range []map[string]string{ours, theirs, base} {
+		for path := range tree {
+			paths[path] = true
+		}
+	}
+
+	plan := &pullPlan{local: local}
+	for path := range paths {
+		// An empty hash means the file doesn't exist on that side
+		l, r, b := ours[path], theirs[path], base[path]
+		switch {
+		case l == r, r == b:
+			// Nothing to do, or only changed locally
+		case l == b && r == "":
+			plan.delete = append(plan.delete, path)
+		case l == b:
+			plan.update = append(plan.update, path)
+		default:
+			plan.conflicts = append(plan.conflicts, fileConflict{path: path, uncommitted: l != index.Committed[path]})
+			if r == "" {
+				plan.delete = append(plan.delete, path)
+			} else {
+				plan.update = append(plan.update, path)
+			}
+		}
+	}
+	sort.Strings(plan.update)
+	sort.Strings(plan.delete)
+
+	// Check every path before anything is written
+	plan.targets = map[string]string{}
+	for _, path := range append(append([]string{}, plan.update...), plan.delete...) {
+		target, err := repo.ResolvePath(root, path)
+		if err != nil {
+			return nil, err
+		}
+		plan.targets[path] = target
+	}
+	sort.Slice(plan.conflicts, func(i, j int) bool { return plan.conflicts[i].path < plan.conflicts[j].path })
+	return plan, nil
+}
+
+// conflictError lists the files a pull would overwrite
+func conflictError(conflicts []fileConflict) error {
+	var b strings.Builder
+	b.WriteString("pull would overwrite local changes to:\n")
+	for _, conflict := range conflicts {
+		state := "unpushed"
+		if conflict.uncommitted {
+			state = "uncommitted"
+		}
+		fmt.Fprintf(&b, "  %s (%s)\n", conflict.path, state)
+	}
+	b.WriteString("Hint: run 'gitr pull --stash' to move your versions into .gitr/stash/ and take the remote's,\nor 'gitr push --force' to replace the remote's with yours")
+	return fmt.Errorf("%s", b.String())
+}
+
+// stashFiles copies the local versions of conflicting files into a new
+// directory under .gitr/stash/ and returns its path relative to the root
+func stashFiles(root string, conflicts []fileConflict, local map[string]string) (string, error) {
+	dir := filepath.Join(repo.GitrDir, "stash", time.Now().UTC().Format("20060102T150405Z"))
+	for _, conflict := range conflicts {
+		content, ok := local[filepath.FromSlash(conflict.path)]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(root, dir, filepath.FromSlash(conflict.path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", fmt.Errorf("failed to stash %s: %w", conflict.path, err)
+		}
+		if err := repo.WriteFileAtomic(path, []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("failed to stash %s: %w", conflict.path, err)
+		}
+	}
+	return dir, nil
+}
+
+// pullHistory combines a local log with the remote's version of it and
+// returns how (see PullResult.History)
+func pullHistory(log repo.HistoryLog, branch string, remoteMessages []remote.Message, rebase bool) (string, error) {
+	theirs, err := fromRemoteMessages(remoteMessages)
+	if err != nil {
+		return "", err
+	}
+	ours, err := log.Load()
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s: %w", log.Name(), err)
+	}
+
+	merged, how := mergeHistories(ours, theirs, branch, rebase)
+	switch how {
+	case "up to date", "local":
+		return how, nil
+	case "merged", "rebased":
+		// Both sides have turns the other lacks; keep a copy of ours
+		if _, err := log.Backup(); err != nil {
+			return "", err
+		}
+	}
+	if err := log.Save(merged); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", log.Name(), err)
+	}
+	return how, nil
+}
+
+// mergeHistories combines two logs that share a prefix. Turns only one
+// side has are kept in order; the result interleaves them by time and
+// ends with a merge turn, or with rebase puts ours after theirs.
+func mergeHistories(ours, theirs *repo.History, branch string, rebase bool) (*repo.History, string) {
+	n := 0
+	for n < len(ours.Messages) && n < len(theirs.Messages) && sameMessage(ours.Messages[n], theirs.Messages[n]) {
+		n++
+	}
+	oursTail, theirsTail := ours.Messages[n:], theirs.Messages[n:]
+
+	// Turns an earlier merge or rebase already copied across aren't new
+	oursNew := unknownTurns(oursTail, theirsTail)
+	theirsNew := unknownTurns(theirsTail, oursTail)
+
+	switch {
+	case len(oursNew) == 0 && len(theirsNew) == 0:
+		return ours, "up to date"
+	case len(theirsNew) == 0:
+		return ours, "local"
+	case len(oursNew) == 0:
+		return theirs, "fast-forward"
+	}
+
+	messages := append([]repo.Message{}, ours.Messages[:n]...)
+	if rebase {
+		messages = append(messages, theirsTail...)
+		messages = append(messages, oursNew...)
+		return &repo.History{Messages: messages}, "rebased"
+	}
+
+	oursTurns := turnMessages(&repo.History{Messages: oursTail})
+	theirsTurns := turnMessages(&repo.History{Messages: theirsNew})
+	merged := len(theirsTurns)
+	for len(oursTurns) > 0 || len(theirsTurns) > 0 {
+		// Take the earlier turn; on a tie the remote's went first
+		if len(theirsTurns) > 0 && (len(oursTurns) == 0 || !oursTurns[0][0].Timestamp.Before(theirsTurns[0][0].Timestamp)) {
+			messages, theirsTurns = append(messages, theirsTurns[0]...), theirsTurns[1:]
+		} else {
+			messages, oursTurns = append(messages, oursTurns[0]...), oursTurns[1:]
+		}
+	}
+
+	now := time.Now()
+	summary := fmt.Sprintf("Current branch: %s\nCommand: gitr pull\n\nMerged %d turn(s) from the remote with %d local turn(s), ordered by time.", branch, merged, len(turnMessages(&repo.History{Messages: oursNew})))
+	messages = append(messages,
+		repo.Message{Role: "user", Content: summary, Timestamp: now},
+		repo.Message{Role: "assistant", Content: "Merged the remote history into the local history.", Timestamp: now},
+	)
+	return &repo.History{Messages: messages}, "merged"
+}
+
+// turnMessages splits a history into the messages of each turn
+func turnMessages(history *repo.History) [][]repo.Message {
+	turns := history.Turns()
+	split := make([][]repo.Message, len(turns))
+	for i, turn := range turns {
+		end := len(history.Messages)
+		if i+1 < len(turns) {
+			end = turns[i+1].Index
+		}
+		split[i] = history.Messages[turn.Index:end]
+	}
+	return split
+}
+
+// unknownTurns returns the turns of messages whose first message isn't
+// among others
+func unknownTurns(messages, others []repo.Message) []repo.Message {
+	known := func(msg repo.Message) bool {
+		for _, other := range others {
+			if sameMessage(msg, other) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var unknown []repo.Message
+	for _, turn := range turnMessages(&repo.History{Messages: messages}) {
+		if !known(turn[0]) {
+			unknown = append(unknown, turn...)
+		}
+	}
+	return unknown
+}
 
-	// Update history
-	var messages []repo.Message
-	for _, msg := range pullData.History {
+// sameMessage is Message.Equal to the second, since that's all of a
+// timestamp the remote keeps
+func sameMessage(a, b repo.Message) bool {
+	return a.Role == b.Role && a.Content == b.Content && a.Timestamp.Truncate(time.Second).Equal(b.Timestamp.Truncate(time.Second))
+}
+
+// fromRemoteMessages converts messages in the remote wire format into a history
+func fromRemoteMessages(remoteMessages []remote.Message) (*repo.History, error) {
+	messages := []repo.Message{}
+	for _, msg := range remoteMessages {
 		timestamp, err := repo.ParseTimestamp(msg.Timestamp)
 		if err != nil {
-			return fmt.Errorf("failed to parse timestamp: %w", err)
+			return nil, fmt.Errorf("failed to parse timestamp: %w", err)
 		}
 		messages = append(messages, repo.Message{
 			Role:      msg.Role,
 			Content:   msg.Content,
 			Timestamp: timestamp,
+			Profile:   msg.Profile,
+			Model:     msg.Model,
 		})
 	}
-
-	history := &repo.History{Messages: messages}
-	if err := repo.SaveHistory(history); err != nil {
-		return fmt.Errorf("failed to save history: %w", err)
-	}
-
-	fmt.Printf("✓ Successfully pulled from remote (branch: %s)\n", pullData.Branch)
-	fmt.Printf("  Files: %d\n", len(pullData.Files))
-	fmt.Printf("  History: %d messages\n", len(pullData.History))
-
-	return nil
+	return &repo.History{Messages: messages}, nil
 }
@@ -0,0 +1,345 @@
+package commands
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// inNewRepo runs the test inside a freshly initialized repository with no
+// system or global config
+func inNewRepo(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv("GITR_CONFIG_SYSTEM", filepath.Join(root, "system.json"))
+	t.Setenv("GITR_CONFIG_GLOBAL", filepath.Join(root, "global.json"))
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	if err := repo.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestApplyPullWritesNothingForOneBadPath(t *testing.T) {
+	outside := t.TempDir()
+
+	tests := []struct {
+		name string
+		bad  string
+	}{
+		{"parent directory", "../escaped.txt"},
+		{"absolute path", "/tmp/escaped.txt"},
+		{"inside .gitr", ".gitr/HEAD"},
+		{"inside .GITR", ".GITR/HEAD"},
+		{"backslash", `sub\..\..\escaped.txt`},
+		{"symlinked parent", "link/escaped.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := inNewRepo(t)
+			if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+				if runtime.GOOS == "windows" {
+					t.Skipf("symlinks unavailable: %v", err)
+				}
+				t.Fatal(err)
+			}
+
+			pullData := &remote.PullData{
+				Branch: "main",
+				Files: map[string]string{
+					"a.txt":     "a",
+					"dir/b.txt": "b",
+					tt.bad:      "escaped",
+				},
+			}
+			err := applyPull("origin", pullData, &PullResult{}, false, false)
+			if !errors.Is(err, repo.ErrUnsafePath) {
+				t.Fatalf("applyPull() = %v, want ErrUnsafePath", err)
+			}
+
+			for _, path := range []string{"a.txt", "dir", filepath.Join(outside, "escaped.txt")} {
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(root, path)
+				}
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("%s was written", path)
+				}
+			}
+			if head, err := repo.GetCurrentBranch(); err != nil || head != "main" {
+				t.Errorf("HEAD = %q, %v; want main", head, err)
+			}
+		})
+	}
+}
+
+func TestPullPerBranchMergesTheBranchLog(t *testing.T) {
+	inNewRepo(t)
+	if err := config.Set("history.per_branch", "true"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	base := []repo.Message{
+		{Role: "user", Content: "git status", Timestamp: now},
+		{Role: "assistant", Content: "clean", Timestamp: now},
+	}
+	if err := repo.BranchHistory("main").Append(base...); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(time.Second)
+	upstream := append(append([]repo.Message{}, base...),
+		repo.Message{Role: "user", Content: "git commit -m upstream", Timestamp: later},
+		repo.Message{Role: "assistant", Content: "committed", Timestamp: later},
+	)
+	shared := []repo.Message{
+		{Role: "user", Content: "git log", Timestamp: later},
+		{Role: "assistant", Content: "no commits", Timestamp: later},
+	}
+	pullData := &remote.PullData{
+		Branch:    "main",
+		Files:     map[string]string{},
+		History:   toRemoteMessages(&repo.History{Messages: shared}),
+		Histories: map[string][]remote.Message{"main": toRemoteMessages(&repo.History{Messages: upstream})},
+	}
+
+	result := &PullResult{}
+	if err := applyPull("origin", pullData, result, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if result.History != "fast-forward" {
+		t.Errorf("history = %q, want fast-forward", result.History)
+	}
+	history, err := repo.BranchHistory("main").Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Messages) != len(upstream) || history.Messages[2].Content != "git commit -m upstream" {
+		t.Errorf("main's log = %v, want the remote's", history.Messages)
+	}
+	if history, err := repo.SharedHistory().Load(); err != nil || len(history.Messages) != 0 {
+		t.Errorf("shared history = %v, %v; want it left alone", history, err)
+	}
+
+	// The recorded ref is what the branch's log is compared with
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordPull(cfg, "origin", pullData); err != nil {
+		t.Fatal(err)
+	}
+	status, err := compareBranch(cfg, "origin", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Known || status.Ahead != 0 || status.Behind != 0 {
+		t.Errorf("status = %+v, want up to date", status)
+	}
+}
+
+// writeTree writes files into the working tree and records base as the
+// tree of origin/main at the last push or pull
+func writeTree(t *testing.T, root string, local, base map[string]string) {
+	t.Helper()
+	for path, content := range local {
+		if err := os.WriteFile(filepath.Join(root, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree := map[string]string{}
+	for path, content := range base {
+		tree[path] = repo.HashContent(content)
+	}
+	if err := repo.WriteRemoteRef("origin", "main", &repo.RemoteRef{RemoteState: repo.RemoteState{Tree: tree}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanPull(t *testing.T) {
+	tests := []struct {
+		name                string
+		local, remote, base map[string]string
+		update, delete      []string
+		conflicts           []string
+	}{
+		{
+			name:   "changed only locally",
+			local:  map[string]string{"a.txt": "ours"},
+			remote: map[string]string{"a.txt": "base"},
+			base:   map[string]string{"a.txt": "base"},
+		},
+		{
+			name:   "changed only remotely",
+			local:  map[string]string{"a.txt": "base"},
+			remote: map[string]string{"a.txt": "theirs"},
+			base:   map[string]string{"a.txt": "base"},
+			update: []string{"a.txt"},
+		},
+		{
+			name:   "added upstream",
+			remote: map[string]string{"b.txt": "theirs"},
+			update: []string{"b.txt"},
+		},
+		{
+			name:   "deleted upstream",
+			local:  map[string]string{"a.txt": "base"},
+			base:   map[string]string{"a.txt": "base"},
+			delete: []string{"a.txt"},
+		},
+		{
+			name:   "deleted locally",
+			remote: map[string]string{"a.txt": "base"},
+			base:   map[string]string{"a.txt": "base"},
+		},
+		{
+			name:   "same change on both sides",
+			local:  map[string]string{"a.txt": "same"},
+			remote: map[string]string{"a.txt": "same"},
+			base:   map[string]string{"a.txt": "base"},
+		},
+		{
+			name:      "changed on both sides",
+			local:     map[string]string{"a.txt": "ours"},
+			remote:    map[string]string{"a.txt": "theirs"},
+			base:      map[string]string{"a.txt": "base"},
+			update:    []string{"a.txt"},
+			conflicts: []string{"a.txt"},
+		},
+		{
+			name:      "changed locally, deleted upstream",
+			local:     map[string]string{"a.txt": "ours"},
+			base:      map[string]string{"a.txt": "base"},
+			delete:    []string{"a.txt"},
+			conflicts: []string{"a.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := inNewRepo(t)
+			writeTree(t, root, tt.local, tt.base)
+
+			plan, err := planPull(root, "origin", &remote.PullData{Branch: "main", Files: tt.remote})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var conflicts []string
+			for _, conflict := range plan.conflicts {
+				conflicts = append(conflicts, conflict.path)
+			}
+			if !slices.Equal(plan.update, tt.update) || !slices.Equal(plan.delete, tt.delete) || !slices.Equal(conflicts, tt.conflicts) {
+				t.Errorf("plan = update %v, delete %v, conflicts %v; want %v, %v, %v",
+					plan.update, plan.delete, conflicts, tt.update, tt.delete, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestApplyPullConflicts(t *testing.T) {
+	pullData := &remote.PullData{Branch: "main", Files: map[string]string{"a.txt": "theirs"}}
+
+	t.Run("without --stash", func(t *testing.T) {
+		root := inNewRepo(t)
+		writeTree(t, root, map[string]string{"a.txt": "ours"}, map[string]string{"a.txt": "base"})
+
+		err := applyPull("origin", pullData, &PullResult{}, false, false)
+		if err == nil || !strings.Contains(err.Error(), "a.txt (uncommitted)") {
+			t.Fatalf("applyPull() = %v, want a conflict on a.txt", err)
+		}
+		if content, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(content) != "ours" {
+			t.Errorf("a.txt = %q, want it left alone", content)
+		}
+	})
+
+	t.Run("with --stash", func(t *testing.T) {
+		root := inNewRepo(t)
+		writeTree(t, root, map[string]string{"a.txt": "ours"}, map[string]string{"a.txt": "base"})
+
+		result := &PullResult{}
+		if err := applyPull("origin", pullData, result, false, true); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(content) != "theirs" {
+			t.Errorf("a.txt = %q, want the remote's", content)
+		}
+		if content, _ := os.ReadFile(filepath.Join(root, result.Stash, "a.txt")); string(content) != "ours" {
+			t.Errorf("stashed a.txt = %q, want ours", content)
+		}
+	})
+}
+
+func TestMergeHistories(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	turn := func(command string, at int) []repo.Message {
+		timestamp := now.Add(time.Duration(at) * time.Second)
+		return []repo.Message{
+			{Role: "user", Content: command, Timestamp: timestamp},
+			{Role: "assistant", Content: "ok", Timestamp: timestamp},
+		}
+	}
+	log := func(turns ...[]repo.Message) *repo.History {
+		history := &repo.History{Messages: []repo.Message{}}
+		for _, turn := range turns {
+			history.Messages = append(history.Messages, turn...)
+		}
+		return history
+	}
+	base, mine, theirs := turn("git init", 0), turn("git add mine", 1), turn("git add theirs", 2)
+	older := turn("git status", -1)
+
+	tests := []struct {
+		name         string
+		ours, remote *repo.History
+		rebase       bool
+		how          string
+		want         []string // the commands of the result, without a merge turn
+	}{
+		{"up to date", log(base), log(base), false, "up to date", []string{"git init"}},
+		{"local turns only", log(base, mine), log(base), false, "local", []string{"git init", "git add mine"}},
+		{"remote turns only", log(base), log(base, theirs), false, "fast-forward", []string{"git init", "git add theirs"}},
+		{"both sides, merge", log(base, mine), log(base, theirs), false, "merged", []string{"git init", "git add mine", "git add theirs"}},
+		{"both sides, rebase", log(base, mine), log(base, theirs), true, "rebased", []string{"git init", "git add theirs", "git add mine"}},
+		{"already merged", log(base, mine, theirs), log(base, theirs), false, "local", []string{"git init", "git add mine", "git add theirs"}},
+		{"after a shallow clone", log(base, theirs), log(older, base, theirs), false, "fast-forward", []string{"git status", "git init", "git add theirs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, how := mergeHistories(tt.ours, tt.remote, "main", tt.rebase)
+			if how != tt.how {
+				t.Errorf("how = %q, want %q", how, tt.how)
+			}
+			turns := merged.Turns()
+			if how == "merged" {
+				last := turns[len(turns)-1]
+				if !strings.Contains(merged.Messages[last.Index].Content, "Command: gitr pull") {
+					t.Errorf("merge doesn't end with a merge turn: %v", merged.Messages)
+				}
+				turns = turns[:len(turns)-1]
+			}
+			var commands []string
+			for _, turn := range turns {
+				commands = append(commands, merged.Messages[turn.Index].Content)
+			}
+			if !slices.Equal(commands, tt.want) {
+				t.Errorf("commands = %v, want %v", commands, tt.want)
+			}
+		})
+	}
+}
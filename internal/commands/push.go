@@ -1,18 +1,88 @@
 package commands
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"time"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
 	"github.com/mysticshirou/gitroulette/internal/remote"
 	"github.com/mysticshirou/gitroulette/internal/repo"
+	"github.com/mysticshirou/gitroulette/internal/trace"
 )
 
-func Push(args []string) error {
+func pushCommand() *cli.Command {
+	var stats, force, forceWithLease, setUpstream bool
+	return &cli.Command{
+		Name:     "push",
+		Args:     "[<remote>]",
+		Summary:  "Push to the remote repository",
+		Complete: completeRemotes,
+		Help: `Pushes to the named remote, or the one the current branch tracks
+(branch.<name>.remote), or origin. -u makes the branch track the remote.
+
+The remote rejects a push if someone else pushed since you last pushed
+or pulled, or if the push would rewrite the remote history (e.g. after
+undo). Pull first, or overwrite the remote with --force-with-lease, which
+still refuses if someone else pushed in the meantime, or --force.`,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&stats, "stats", false, "report bytes sent and received, before and after compression")
+			fs.BoolVar(&forceWithLease, "force-with-lease", false, "rewrite the remote history unless someone else pushed since you last pushed or pulled")
+			fs.BoolVar(&force, "force", false, "overwrite the remote whatever it has")
+			fs.BoolVar(&setUpstream, "u", false, "make the current branch track the remote")
+			fs.BoolVar(&setUpstream, "set-upstream", false, "same as -u")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 1 {
+				return cli.Usagef("unexpected argument: %s", args[1])
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			mode := PushFastForward
+			switch {
+			case force:
+				mode = PushForce
+			case forceWithLease:
+				mode = PushForceWithLease
+			}
+			return Push(ctx, name, mode, stats, setUpstream)
+		},
+	}
+}
+
+// PushMode says what a push may overwrite on the remote
+type PushMode int
+
+const (
+	PushFastForward    PushMode = iota // only add to what the remote has
+	PushForceWithLease                 // rewrite it, if nobody else pushed since
+	PushForce                          // overwrite whatever it has
+)
+
+// TransferResult is the JSON result of push and pull
+type TransferResult struct {
+	Remote   string        `json:"remote"`
+	Branch   string        `json:"branch"`
+	Files    int           `json:"files"`
+	Messages int           `json:"messages"`
+	Stats    *remote.Stats `json:"stats,omitempty"`
+}
+
+// Push pushes the current branch to a remote, named or found by remoteFor,
+// and makes the branch track it if setUpstream is set
+func Push(ctx *cli.Context, name string, mode PushMode, stats, setUpstream bool) error {
 	// Get current branch
 	currentBranch, err := repo.GetCurrentBranch()
 	if err != nil {
 		return fmt.Errorf("failed to get current branch: %w", err)
 	}
+	if name, err = remoteFor(currentBranch, name); err != nil {
+		return err
+	}
 
 	// Get all files
 	files, err := repo.GetAllFiles()
@@ -20,20 +90,32 @@ func Push(args []string) error {
 		return fmt.Errorf("failed to read repository files: %w", err)
 	}
 
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
 	// Load history
-	history, err := repo.LoadHistory()
+	historyLog, err := config.HistoryFor(cfg)
+	if err != nil {
+		return err
+	}
+
+	history, err := historyLog.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load history: %w", err)
 	}
 
 	// Convert history to remote format
-	var messages []remote.Message
-	for _, msg := range history.Messages {
-		messages = append(messages, remote.Message{
-			Role:      msg.Role,
-			Content:   msg.Content,
-			Timestamp: msg.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
-		})
+	messages := toRemoteMessages(history)
+
+	// With histories kept per branch, the branch's log goes up in place of
+	// the shared history, which the push leaves as it is on the remote
+	var histories map[string][]remote.Message
+	shared := messages
+	if cfg.History.PerBranch {
+		histories = map[string][]remote.Message{currentBranch: messages}
+		shared = nil
 	}
 
 	// Extract commits from history (look for commit messages in history)
@@ -53,33 +135,302 @@ func Push(args []string) error {
 
 	// If no commits extracted, create one for the current state
 	if len(commits) == 0 {
+		timestamp := time.Now().Format("2006-01-02T15:04:05Z07:00")
+		if len(messages) > 0 {
+			timestamp = messages[len(messages)-1].Timestamp
+		}
 		commits = append(commits, remote.Commit{
 			Hash:      repo.GenerateCommitHash(),
 			Message:   "Initial push",
 			Branch:    currentBranch,
-			Timestamp: messages[len(messages)-1].Timestamp,
+			Timestamp: timestamp,
 		})
 	}
 
 	// Create remote client
-	client, err := remote.NewClient()
+	client, err := newRemoteClient(name)
 	if err != nil {
-		return fmt.Errorf("failed to create remote client: %w\nHint: Configure remote with 'gitr config set remote.url <url>' and 'gitr config set remote.repo_id <id>'", err)
+		return err
 	}
 
 	// Push data
 	pushData := &remote.PushData{
-		Branch:  currentBranch,
-		Files:   files,
-		History: messages,
-		Commits: commits,
+		Branch:       currentBranch,
+		Files:        files,
+		History:      shared,
+		Commits:      commits,
+		Histories:    histories,
+		PerBranch:    cfg.History.PerBranch,
+		AllowRewrite: mode != PushFastForward,
+	}
+	if mode != PushForce {
+		if pushData.Lease, err = remoteLease(name, currentBranch); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Pushing to remote (branch: %s)...\n", currentBranch)
-	if err := client.Push(pushData); err != nil {
+	// A shallow clone's history continues the turns it left out
+	shallow, err := repo.ReadShallow()
+	if err != nil {
+		return err
+	}
+	if shallow != nil {
+		base := remote.Position{Length: shallow.Length, Hash: shallow.Hash}
+		if histories != nil {
+			pushData.HistoriesBase = map[string]remote.Position{currentBranch: base}
+		} else {
+			pushData.HistoryBase = &base
+		}
+	}
+
+	ctx.Printf("Pushing to %s (branch: %s)...\n", name, currentBranch)
+	pushed, err := pushIncremental(client, pushData)
+	if errors.Is(err, remote.ErrNotSupported) && shallow != nil {
+		return fmt.Errorf("the remote can't extend a shallow clone's history; run 'gitr pull' to fetch all of it first")
+	}
+	if errors.Is(err, remote.ErrNotSupported) || errors.Is(err, remote.ErrHistoryMoved) {
+		trace.Printf("sending everything: %v", err)
+		pushed, err = client.Push(pushData)
+	}
+	if errors.Is(err, remote.ErrNonFastForward) {
+		return fmt.Errorf("push rejected: %w\nHint: the remote has changes you don't have; run 'gitr pull %s' first.\nTo overwrite the remote anyway, use 'gitr push --force-with-lease', which refuses if\nsomeone pushed since your last push or pull, or 'gitr push --force'", err, name)
+	}
+	if err != nil {
 		return fmt.Errorf("push failed: %w", err)
 	}
+	if pushData.Lease != nil && !pushed.LeaseChecked {
+		fmt.Fprintf(ctx.Stderr, "Warning: %s didn't confirm it checked the push against its branch, so it may have overwritten changes someone else pushed\n", name)
+	}
+
+	// The server says where its history now ends; older ones leave it at ours
+	position, positions := remote.PositionOf(shared), remote.PositionsOf(histories)
+	if pushed.History != nil {
+		position = *pushed.History
+	}
+	if pushed.Histories != nil {
+		positions = pushed.Histories
+	}
+	state := remoteState(commits[len(commits)-1].Hash, position, positions, files)
+	err = recordRemoteRef(name, currentBranch, state, history.Messages)
+	if err != nil {
+		return err
+	}
 
-	fmt.Println("✓ Successfully pushed to remote")
+	ctx.Printf("✓ Successfully pushed to %s\n", name)
+	if setUpstream {
+		if err := config.SetIn(config.ScopeLocal, config.TrackingKey(currentBranch), name); err != nil {
+			return err
+		}
+		ctx.Printf("  Branch '%s' now tracks remote '%s'\n", currentBranch, name)
+	}
+	result := &TransferResult{Remote: name, Branch: currentBranch, Files: len(files), Messages: len(messages)}
+	if stats {
+		result.Stats = printStats(ctx, client)
+	}
+	ctx.SetResult(result)
 	return nil
 }
+
+// printStats reports how many bytes a client transferred
+func printStats(ctx *cli.Context, client *remote.Client) *remote.Stats {
+	stats := client.Stats()
+	ctx.Printf("  Sent: %s (%s uncompressed)\n", formatBytes(stats.Sent), formatBytes(stats.SentRaw))
+	ctx.Printf("  Received: %s (%s uncompressed)\n", formatBytes(stats.Received), formatBytes(stats.ReceivedRaw))
+	return &stats
+}
+
+// formatBytes formats a byte count for people, e.g. "12.3 KiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[prefix])
+}
+
+// pushIncremental asks the server what it already has and sends only the
+// rest: missing blobs, and the messages after the server's history
+func pushIncremental(client *remote.Client, full *remote.PushData) (*remote.PushResult, error) {
+	tree := map[string]string{}
+	contents := map[string]string{}
+	for path, content := range full.Files {
+		hash := remote.HashContent(content)
+		tree[path] = hash
+		contents[hash] = content
+	}
+
+	negotiated, err := client.Negotiate(&remote.NegotiateRequest{Branch: full.Branch, Files: tree})
+	if err != nil {
+		return nil, err
+	}
+
+	data := &remote.PushData{
+		Branch:       full.Branch,
+		Commits:      full.Commits,
+		Tree:         tree,
+		Blobs:        map[string]string{},
+		PerBranch:    full.PerBranch,
+		Lease:        full.Lease,
+		AllowRewrite: full.AllowRewrite,
+	}
+	for _, hash := range negotiated.Missing {
+		content, ok := contents[hash]
+		if !ok {
+			return nil, fmt.Errorf("server asked for unknown blob %s", hash)
+		}
+		data.Blobs[hash] = content
+	}
+
+	if !full.PerBranch {
+		data.History, data.HistoryBase = messagesAfter(full.History, negotiated.History)
+		if full.HistoryBase != nil {
+			data.History, data.HistoryBase = full.History, full.HistoryBase
+		}
+	}
+	if full.Histories != nil {
+		data.Histories = map[string][]remote.Message{}
+		data.HistoriesBase = map[string]remote.Position{}
+		for branch, messages := range full.Histories {
+			if base, ok := full.HistoriesBase[branch]; ok {
+				data.Histories[branch], data.HistoriesBase[branch] = messages, base
+				continue
+			}
+			position, ok := negotiated.Histories[branch]
+			if !ok {
+				data.Histories[branch] = messages
+				continue
+			}
+			var base *remote.Position
+			data.Histories[branch], base = messagesAfter(messages, position)
+			if base != nil {
+				data.HistoriesBase[branch] = *base
+			}
+		}
+	}
+
+	trace.Printf("incremental push: %d of %d blob(s), %d of %d message(s)", len(data.Blobs), len(contents), len(data.History), len(full.History))
+	return client.Push(data)
+}
+
+// messagesAfter returns the messages the server doesn't have yet and the
+// position they continue from, or every message and no position if the
+// local log doesn't continue the server's
+func messagesAfter(messages []remote.Message, server remote.Position) ([]remote.Message, *remote.Position) {
+	if !server.Continues(messages) {
+		return messages, nil
+	}
+	return messages[server.Length:], &server
+}
+
+// remoteLease returns the state of the remote branch as of the last push
+// or pull. A branch the remote doesn't have yet has no head, but shares
+// the history last seen on any other branch; with no refs at all the
+// remote is taken to be empty.
+func remoteLease(name, branch string) (*remote.Lease, error) {
+	ref, err := repo.ReadRemoteRef(name, branch)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return newBranchLease(name)
+	}
+	return leaseOf(ref.Synced()), nil
+}
+
+// newBranchLease leases a new branch on the shared history of the remote's
+// other branches. That history only grows unless a push is forced, so the
+// longest one recorded is the latest.
+func newBranchLease(name string) (*remote.Lease, error) {
+	branches, err := repo.ListRemoteRefs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *repo.RemoteState
+	for _, branch := range branches {
+		ref, err := repo.ReadRemoteRef(name, branch)
+		if err != nil {
+			return nil, err
+		}
+		if ref == nil {
+			continue
+		}
+		if synced := ref.Synced(); latest == nil || synced.HistoryLength > latest.HistoryLength {
+			latest = &synced
+		}
+	}
+	if latest == nil {
+		return &remote.Lease{History: remote.PositionOf(nil)}, nil
+	}
+
+	lease := leaseOf(*latest)
+	lease.Head = ""
+	return lease, nil
+}
+
+// leaseOf returns the lease for a recorded state of a remote branch
+func leaseOf(synced repo.RemoteState) *remote.Lease {
+	lease := &remote.Lease{
+		Head:    synced.Head,
+		History: remote.Position{Length: synced.HistoryLength, Hash: synced.HistoryHash},
+	}
+	if synced.Histories != nil {
+		lease.Histories = map[string]remote.Position{}
+		for branch, position := range synced.Histories {
+			lease.Histories[branch] = remote.Position{Length: position.Length, Hash: position.Hash}
+		}
+	}
+	return lease
+}
+
+// remoteState describes a remote branch from its newest commit, where its
+// history and per-branch logs end, and its files
+func remoteState(head string, position remote.Position, positions map[string]remote.Position, files map[string]string) repo.RemoteState {
+	tree := map[string]string{}
+	for path, content := range files {
+		tree[path] = repo.HashContent(content)
+	}
+	state := repo.RemoteState{
+		Head:          head,
+		HistoryLength: position.Length,
+		HistoryHash:   position.Hash,
+		Tree:          tree,
+	}
+	if positions != nil {
+		state.Histories = map[string]repo.LogPosition{}
+		for branch, p := range positions {
+			state.Histories[branch] = repo.LogPosition{Length: p.Length, Hash: p.Hash}
+		}
+	}
+	return state
+}
+
+// recordRemoteRef remembers the state a push or pull left the remote
+// branch in, for the next push's lease and the next pull's merge
+func recordRemoteRef(name, branch string, state repo.RemoteState, history []repo.Message) error {
+	return repo.WriteRemoteRef(name, branch, &repo.RemoteRef{
+		RemoteState: state,
+		History:     history,
+		Updated:     time.Now(),
+	})
+}
+
+// toRemoteMessages converts a local history into the remote wire format
+func toRemoteMessages(history *repo.History) []remote.Message {
+	var messages []remote.Message
+	for _, msg := range history.Messages {
+		messages = append(messages, remote.Message{
+			Role:      msg.Role,
+			Content:   msg.Content,
+			Timestamp: msg.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+			Profile:   msg.Profile,
+			Model:     msg.Model,
+		})
+	}
+	return messages
+}
@@ -1,20 +1,184 @@
 package commands
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/credential"
 	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-// RemoteCreate creates a new repository on the remote server
-func RemoteCreate(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: gitr remote create <name>")
+func remoteCommand() *cli.Command {
+	var global bool
+	return &cli.Command{
+		Name:    "remote",
+		Summary: "Manage remote repositories",
+		Help: `origin is the remote described by remote.url and remote.repo_id; others
+are remote.<name>.url and remote.<name>.repo_id. Push and pull use the
+remote the current branch tracks (branch.<name>.remote), or origin.`,
+		Subcommands: []*cli.Command{
+			{
+				Name:    "add",
+				Args:    "<name> <remote-url>/<repo-id>",
+				Summary: "Add a named remote",
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 2 {
+						return cli.Usagef("usage: gitr remote add <name> <remote-url>/<repo-id>")
+					}
+					return RemoteAdd(ctx, args[0], args[1])
+				},
+			},
+			{
+				Name:     "remove",
+				Args:     "<name>",
+				Summary:  "Remove a remote, its remote-tracking refs and the branches' tracking of it",
+				Complete: completeRemotes,
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 1 {
+						return cli.Usagef("usage: gitr remote remove <name>")
+					}
+					return RemoteRemove(ctx, args[0])
+				},
+			},
+			{
+				Name:     "rename",
+				Args:     "<old> <new>",
+				Summary:  "Rename a remote",
+				Complete: completeRemotes,
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 2 {
+						return cli.Usagef("usage: gitr remote rename <old> <new>")
+					}
+					return RemoteRename(ctx, args[0], args[1])
+				},
+			},
+			{
+				Name:    "list",
+				Summary: "List the configured remotes",
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 0 {
+						return cli.Usagef("unexpected argument: %s", args[0])
+					}
+					return RemoteList(ctx)
+				},
+			},
+			{
+				Name:     "show",
+				Args:     "<name>",
+				Summary:  "Show a remote, the branches tracking it and what was last seen of it",
+				Complete: completeRemotes,
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 1 {
+						return cli.Usagef("usage: gitr remote show <name>")
+					}
+					return RemoteShow(ctx, args[0])
+				},
+			},
+			{
+				Name:     "status",
+				Args:     "[<name>]",
+				Summary:  "Compare local branches with a remote's, as of the last fetch",
+				Complete: completeRemotes,
+				Help: `Shows how far each local branch is ahead of and behind the remote
+branch of the same name, counted in turns of history. It only reads what
+was recorded by the last fetch, push or pull; run 'gitr fetch' first for
+an up-to-date answer. Without a name it covers every configured remote.`,
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 1 {
+						return cli.Usagef("unexpected argument: %s", args[1])
+					}
+					name := ""
+					if len(args) == 1 {
+						name = args[0]
+					}
+					return RemoteStatus(ctx, name)
+				},
+			},
+			{
+				Name:    "create",
+				Args:    "<name>",
+				Summary: "Create a repository on the remote server",
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) != 1 {
+						return cli.Usagef("usage: gitr remote create <name>")
+					}
+					return RemoteCreate(ctx, args[0])
+				},
+			},
+			{
+				Name:     "login",
+				Args:     "[<name>]",
+				Summary:  "Store a token for the remote server",
+				NoRepo:   true,
+				Complete: completeRemotes,
+				Help: `Reads the token from the terminal, or from stdin when it isn't one:
+  echo "$GITR_TOKEN" | gitr remote login
+The token is kept like api.key: in remote.token_helper if one is set.
+
+Without a name the token goes in remote.token, which is sent to origin and
+to other remotes on the same server. With one it goes in
+remote.<name>.token and is sent only to that remote. Either way the
+server's URL is recorded next to it (remote.token_url or
+remote.<name>.token_url), and the token is never sent anywhere else, even
+if a repository's remote later points at another server.`,
+				Flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&global, "global", false, "store the token in the global config, for every repository")
+				},
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 1 {
+						return cli.Usagef("unexpected argument: %s", args[1])
+					}
+					name := ""
+					if len(args) == 1 {
+						name = args[0]
+					}
+					return RemoteLogin(ctx, name, tokenScope(global))
+				},
+			},
+			{
+				Name:     "logout",
+				Args:     "[<name>]",
+				Summary:  "Forget the token for the remote server",
+				NoRepo:   true,
+				Complete: completeRemotes,
+				Flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&global, "global", false, "remove the token from the global config")
+				},
+				Run: func(ctx *cli.Context, args []string) error {
+					if len(args) > 1 {
+						return cli.Usagef("unexpected argument: %s", args[1])
+					}
+					name := ""
+					if len(args) == 1 {
+						name = args[0]
+					}
+					return RemoteLogout(ctx, name, tokenScope(global))
+				},
+			},
+		},
 	}
+}
 
-	repoName := strings.TrimSpace(args[0])
+// tokenScope is where login and logout keep the token
+func tokenScope(global bool) config.Scope {
+	if global {
+		return config.ScopeGlobal
+	}
+	return config.DefaultScope()
+}
+
+// RemoteCreate creates a new repository on the remote server
+func RemoteCreate(ctx *cli.Context, name string) error {
+	repoName := strings.TrimSpace(name)
 	if repoName == "" {
 		return fmt.Errorf("repository name cannot be empty")
 	}
@@ -25,14 +189,19 @@ func RemoteCreate(args []string) error {
 		return err
 	}
 
-	if cfg.Remote.URL == "" {
+	origin, ok := config.LookupRemote(cfg, config.DefaultRemote)
+	if !ok || origin.URL == "" {
 		return fmt.Errorf("remote.url is not configured. Run: gitr config set remote.url <url>")
 	}
 
-	fmt.Printf("Creating repository '%s' on remote...\n", repoName)
+	ctx.Printf("Creating repository '%s' on remote...\n", repoName)
 
 	// Create a client with just the base URL (no repo_id needed yet)
-	client := remote.NewClientWithURL(cfg.Remote.URL)
+	token, err := config.RemoteToken(cfg, origin)
+	if err != nil && !errors.Is(err, credential.ErrNotFound) {
+		return err
+	}
+	client := remote.NewClientWithURL(origin.URL, token)
 
 	// Create the repository
 	repoID, err := client.CreateRepo(repoName)
@@ -40,18 +209,529 @@ func RemoteCreate(args []string) error {
 		return fmt.Errorf("failed to create repository: %w", err)
 	}
 
-	fmt.Printf("✓ Repository created successfully!\n")
-	fmt.Printf("  Repository ID: %s\n", repoID)
+	ctx.Printf("✓ Repository created successfully!\n")
+	ctx.Printf("  Repository ID: %s\n", repoID)
 
 	// Save the repo ID to config
-	cfg.Remote.RepoID = repoID
-	if err := config.Save(cfg); err != nil {
+	key := config.RemoteKey(cfg, config.DefaultRemote, "repo_id")
+	if err := config.SetIn(config.ScopeLocal, key, repoID); err != nil {
 		return fmt.Errorf("failed to save repository ID to config: %w", err)
 	}
 
-	fmt.Printf("✓ Configured remote.repo_id: %s\n", repoID)
-	fmt.Println("\nYou can now push to this repository:")
-	fmt.Println("  gitr push")
+	ctx.Printf("✓ Configured %s: %s\n", key, repoID)
+	ctx.Println("\nYou can now push to this repository:")
+	ctx.Println("  gitr push")
+	ctx.SetResult(map[string]string{"name": repoName, "repo_id": repoID})
+
+	return nil
+}
+
+// RemoteLogin checks a token against a remote server and stores it: in
+// remote.token without a remote name, otherwise in remote.<name>.token
+func RemoteLogin(ctx *cli.Context, name string, scope config.Scope) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	r, key, err := tokenRemote(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	token, err := readToken(r.URL)
+	if err != nil {
+		return err
+	}
+
+	client := remote.NewClientWithURL(r.URL, token)
+	if err := client.CheckAccess(r.RepoID); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	usedHelper, err := config.SetSecret(scope, key, token)
+	if err != nil {
+		return err
+	}
+	// A token in a config file is only sent to the server it was issued for
+	if err := config.SetIn(scope, config.TokenURLKey(key), r.URL); err != nil {
+		return err
+	}
+
+	where := fmt.Sprintf("%s config", scope)
+	if usedHelper {
+		where = "credential helper"
+	}
+	ctx.Printf("✓ Logged in to %s (token stored in %s)\n", r.URL, where)
+	ctx.SetResult(map[string]any{"remote": r.Name, "url": r.URL, "key": key, "scope": scope, "helper": usedHelper})
+	return nil
+}
+
+// tokenRemote returns the remote a login is for and the key its token
+// goes in
+func tokenRemote(cfg *config.Config, name string) (*config.Remote, string, error) {
+	key := "remote.token"
+	if name == "" {
+		name = config.DefaultRemote
+	} else {
+		key = "remote." + name + ".token"
+	}
+
+	r, err := lookupRemote(cfg, name)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.URL == "" {
+		key := config.RemoteKey(cfg, name, "url")
+		return nil, "", fmt.Errorf("%s is not configured. Run: gitr config set %s <url>", key, key)
+	}
+	return r, key, nil
+}
+
+// readToken asks for a token on the terminal without echoing it, or reads
+// a line from stdin
+func readToken(url string) (string, error) {
+	var line string
+	var err error
+	if cli.IsTerminal(os.Stdin) {
+		line, err = credential.Prompt(fmt.Sprintf("Token for %s: ", url))
+	} else {
+		line, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if line != "" {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("token cannot be empty")
+	}
+	return token, nil
+}
+
+// RemoteLogout removes the stored token: remote.token, or with a remote
+// name, remote.<name>.token
+func RemoteLogout(ctx *cli.Context, name string, scope config.Scope) error {
+	key := "remote.token"
+	if name != "" {
+		if err := config.ValidateRemoteName(name); err != nil {
+			return err
+		}
+		key = "remote." + name + ".token"
+	}
+
+	removed, err := config.UnsetSecret(scope, key)
+	if err != nil {
+		return err
+	}
+	var notSet *config.NotSetError
+	if err := config.Unset(scope, config.TokenURLKey(key)); err != nil && !errors.As(err, &notSet) {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("no %s stored in %s config", key, scope)
+	}
+	ctx.Println("✓ Logged out")
+	ctx.SetResult(map[string]any{"key": key, "scope": scope})
+	return nil
+}
+
+// lookupRemote returns a configured remote, or an error saying how to
+// configure it
+func lookupRemote(cfg *config.Config, name string) (*config.Remote, error) {
+	if err := config.ValidateRemoteName(name); err != nil {
+		return nil, err
+	}
+	r, ok := config.LookupRemote(cfg, name)
+	if !ok && name == config.DefaultRemote {
+		return nil, fmt.Errorf("remote.url is not configured. Run: gitr config set remote.url <url>")
+	}
+	if !ok {
+		return nil, fmt.Errorf("remote '%s' is not configured. Run: gitr remote add %s <url>/<repo-id>", name, name)
+	}
+	return r, nil
+}
+
+// remoteFor picks the remote for a branch: the one named on the command
+// line, then the one the branch tracks, then origin
+func remoteFor(branch, name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	tracked, err := config.TrackedRemote(branch)
+	if err != nil {
+		return "", err
+	}
+	if tracked != "" {
+		return tracked, nil
+	}
+	return config.DefaultRemote, nil
+}
+
+// newRemoteClient creates a client for a remote used by push or pull
+func newRemoteClient(name string) (*remote.Client, error) {
+	client, err := remote.NewClient(name)
+	if err != nil && name == config.DefaultRemote {
+		return nil, fmt.Errorf("failed to create remote client: %w\nHint: Configure remote with 'gitr config set remote.url <url>' and 'gitr config set remote.repo_id <id>'", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote client: %w", err)
+	}
+	return client, nil
+}
+
+// RemoteInfo is the JSON description of a remote
+type RemoteInfo struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	RepoID   string   `json:"repo_id"`
+	Tracking []string `json:"tracking,omitempty"`
+}
+
+// RemoteAdd configures a named remote in the repository
+func RemoteAdd(ctx *cli.Context, name, source string) error {
+	if err := config.ValidateRemoteName(name); err != nil {
+		return cli.Usagef("%v", err)
+	}
+	baseURL, repoID, err := splitRemoteURL(source)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.LookupRemote(cfg, name); ok {
+		return fmt.Errorf("remote %s already exists", name)
+	}
+
+	if err := config.SetIn(config.ScopeLocal, "remote."+name+".url", baseURL); err != nil {
+		return err
+	}
+	if err := config.SetIn(config.ScopeLocal, "remote."+name+".repo_id", repoID); err != nil {
+		return err
+	}
+
+	ctx.Printf("✓ Added remote '%s' (%s, repository %s)\n", name, baseURL, repoID)
+	ctx.SetResult(&RemoteInfo{Name: name, URL: baseURL, RepoID: repoID})
+	return nil
+}
+
+// remoteLocalKeys returns the keys defining a remote in the repository's
+// config file: its remote.<name> object, or for origin remote.url and
+// remote.repo_id as well
+func remoteLocalKeys(name string) ([]config.Entry, error) {
+	entries, err := config.ListIn(config.ScopeLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []config.Entry
+	for _, entry := range entries {
+		_, named := strings.CutPrefix(entry.Key, "remote."+name+".")
+		legacy := name == config.DefaultRemote && (entry.Key == "remote.url" || entry.Key == "remote.repo_id")
+		if named || legacy {
+			keys = append(keys, entry)
+		}
+	}
+	return keys, nil
+}
+
+// RemoteRemove removes a remote from the repository's config, along with
+// its remote-tracking refs and the branches' tracking of it
+func RemoteRemove(ctx *cli.Context, name string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if _, err := lookupRemote(cfg, name); err != nil {
+		return err
+	}
+
+	keys, err := remoteLocalKeys(name)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("remote %s is not configured in this repository's config; remove it where it is set ('gitr config list --show-origin' shows where)", name)
+	}
 
+	var untracked []string
+	err = repo.WithLock(func() error {
+		for _, entry := range keys {
+			if err := config.Unset(config.ScopeLocal, entry.Key); err != nil {
+				return err
+			}
+		}
+		if untracked, err = retrack(name, ""); err != nil {
+			return err
+		}
+		return repo.DeleteRemoteRefs(name)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("✓ Removed remote '%s'\n", name)
+	for _, branch := range untracked {
+		ctx.Printf("  Branch '%s' no longer tracks it\n", branch)
+	}
+	ctx.SetResult(map[string]any{"name": name, "untracked": untracked})
 	return nil
 }
+
+// RemoteRename renames a remote in the repository's config, moving its
+// remote-tracking refs and the branches tracking it along
+func RemoteRename(ctx *cli.Context, oldName, newName string) error {
+	if err := config.ValidateRemoteName(newName); err != nil {
+		return cli.Usagef("%v", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if _, err := lookupRemote(cfg, oldName); err != nil {
+		return err
+	}
+	if _, ok := config.LookupRemote(cfg, newName); ok {
+		return fmt.Errorf("remote %s already exists", newName)
+	}
+
+	keys, err := remoteLocalKeys(oldName)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("remote %s is not configured in this repository's config, so it can't be renamed here", oldName)
+	}
+
+	var moved []string
+	err = repo.WithLock(func() error {
+		for _, entry := range keys {
+			field := entry.Key[strings.LastIndex(entry.Key, ".")+1:]
+			if err := config.SetIn(config.ScopeLocal, "remote."+newName+"."+field, fmt.Sprint(entry.Value)); err != nil {
+				return err
+			}
+			if err := config.Unset(config.ScopeLocal, entry.Key); err != nil {
+				return err
+			}
+		}
+		if moved, err = retrack(oldName, newName); err != nil {
+			return err
+		}
+		return repo.RenameRemoteRefs(oldName, newName)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("✓ Renamed remote '%s' to '%s'\n", oldName, newName)
+	for _, branch := range moved {
+		ctx.Printf("  Branch '%s' now tracks '%s'\n", branch, newName)
+	}
+	ctx.SetResult(map[string]any{"old": oldName, "new": newName, "tracking": moved})
+	return nil
+}
+
+// retrack points the repository's branches tracking one remote at another,
+// or makes them track none if newName is empty. It returns the branches.
+func retrack(oldName, newName string) ([]string, error) {
+	entries, err := config.ListIn(config.ScopeLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	for _, entry := range entries {
+		branch, ok := strings.CutPrefix(entry.Key, "branch.")
+		if branch, ok = strings.CutSuffix(branch, ".remote"); !ok || entry.Value != oldName {
+			continue
+		}
+		if newName == "" {
+			err = config.Unset(config.ScopeLocal, entry.Key)
+		} else {
+			err = config.SetIn(config.ScopeLocal, entry.Key, newName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch)
+	}
+	return branches, nil
+}
+
+// RemoteList lists the configured remotes
+func RemoteList(ctx *cli.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	remotes := []*RemoteInfo{}
+	for _, name := range config.RemoteNames(cfg) {
+		r, _ := config.LookupRemote(cfg, name)
+		remotes = append(remotes, &RemoteInfo{Name: r.Name, URL: r.URL, RepoID: r.RepoID})
+		ctx.Printf("%s\t%s/%s\n", r.Name, r.URL, r.RepoID)
+	}
+	if len(remotes) == 0 {
+		ctx.Println("No remotes configured. Run: gitr remote add <name> <remote-url>/<repo-id>")
+	}
+	ctx.SetResult(remotes)
+	return nil
+}
+
+// RemoteBranch is what was last seen of a remote branch
+type RemoteBranch struct {
+	Branch   string `json:"branch"`
+	Head     string `json:"head"`
+	Messages int    `json:"messages"`
+}
+
+// RemoteShow describes a remote from local state only: its settings, the
+// branches tracking it and its branches as of the last push or pull
+func RemoteShow(ctx *cli.Context, name string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	r, err := lookupRemote(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	tracking, err := config.Tracking()
+	if err != nil {
+		return err
+	}
+	info := &RemoteInfo{Name: r.Name, URL: r.URL, RepoID: r.RepoID}
+	for branch, tracked := range tracking {
+		if tracked == name {
+			info.Tracking = append(info.Tracking, branch)
+		}
+	}
+	sort.Strings(info.Tracking)
+
+	branches, err := repo.ListRemoteRefs(name)
+	if err != nil {
+		return err
+	}
+	seen := []RemoteBranch{}
+	for _, branch := range branches {
+		ref, err := repo.ReadRemoteRef(name, branch)
+		if err != nil {
+			return err
+		}
+		if ref != nil {
+			seen = append(seen, RemoteBranch{Branch: branch, Head: ref.Head, Messages: ref.HistoryLength})
+		}
+	}
+
+	ctx.Printf("* remote %s\n", r.Name)
+	ctx.Printf("  URL: %s\n", r.URL)
+	ctx.Printf("  Repository ID: %s\n", r.RepoID)
+	if len(info.Tracking) > 0 {
+		ctx.Printf("  Tracked by: %s\n", strings.Join(info.Tracking, ", "))
+	}
+	if len(seen) == 0 {
+		ctx.Println("  Remote branches: none seen yet (push or pull first)")
+	} else {
+		ctx.Println("  Remote branches (as of the last push or pull):")
+		for _, branch := range seen {
+			ctx.Printf("    %s/%s\t%s, %d messages\n", r.Name, branch.Branch, branch.Head, branch.Messages)
+		}
+	}
+	ctx.SetResult(map[string]any{"remote": info, "branches": seen})
+	return nil
+}
+
+// RemoteBranchStatus is the JSON result of remote status for one remote
+// branch; Local is false if there is no local branch of the same name
+type RemoteBranchStatus struct {
+	TrackingStatus
+	Local    bool `json:"local"`
+	Tracking bool `json:"tracking"`
+}
+
+// RemoteStatus compares each local branch with the same branch of a remote,
+// or of every remote, from the remote-tracking refs alone
+func RemoteStatus(ctx *cli.Context, name string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	names := config.RemoteNames(cfg)
+	if name != "" {
+		if _, err := lookupRemote(cfg, name); err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no remotes configured. Run: gitr remote add <name> <remote-url>/<repo-id>")
+	}
+
+	locals, err := repo.ListBranches()
+	if err != nil {
+		return err
+	}
+	tracking, err := config.Tracking()
+	if err != nil {
+		return err
+	}
+
+	statuses := []RemoteBranchStatus{}
+	for _, name := range names {
+		branches, err := repo.ListRemoteRefs(name)
+		if err != nil {
+			return err
+		}
+		ctx.Printf("%s\n", name)
+		if len(branches) == 0 {
+			ctx.Printf("  nothing fetched yet (run 'gitr fetch %s')\n", name)
+		}
+		for _, branch := range branches {
+			status := RemoteBranchStatus{
+				TrackingStatus: TrackingStatus{Branch: branch, Remote: name},
+				Local:          slices.Contains(locals, branch),
+				Tracking:       tracking[branch] == name,
+			}
+			if status.Local {
+				compared, err := compareBranch(cfg, name, branch)
+				if err != nil {
+					return err
+				}
+				status.TrackingStatus = *compared
+			}
+			statuses = append(statuses, status)
+			ctx.Printf("  %s\n", status.describe())
+		}
+	}
+	ctx.SetResult(statuses)
+	return nil
+}
+
+// describe summarizes a remote branch's status on one line
+func (s *RemoteBranchStatus) describe() string {
+	line := fmt.Sprintf("%s/%s", s.Remote, s.Branch)
+	switch {
+	case !s.Local:
+		return line + ": no local branch"
+	case !s.Known:
+		return line + ": not fetched yet"
+	case s.Ahead == 0 && s.Behind == 0:
+		line += ": up to date"
+	default:
+		line += fmt.Sprintf(": local branch ahead %d, behind %d", s.Ahead, s.Behind)
+	}
+	if s.Tracking {
+		line += " (tracked)"
+	}
+	if !s.Updated.IsZero() {
+		line += ", as of " + s.Updated.Local().Format("2006-01-02 15:04")
+	}
+	return line
+}
@@ -0,0 +1,311 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/server"
+)
+
+// defaultServeDir is where gitr serve keeps repositories without --dir
+const defaultServeDir = "gitr-data"
+
+func serveCommand() *cli.Command {
+	var addr, dir, tokenDir string
+	var read, write cli.StringsFlag
+	dirFlag := func(fs *flag.FlagSet) {
+		fs.StringVar(&tokenDir, "dir", defaultServeDir, "`directory` the server stores repositories in")
+	}
+	return &cli.Command{
+		Name:    "serve",
+		Summary: "Run a remote server that stores repositories on disk",
+		Help: `Serves the same HTTP API as the hosted remote, so push, pull and
+remote create work against it:
+  gitr serve --addr :8080 --dir /srv/gitr
+  gitr config set remote.url http://localhost:8080
+
+The server is open to everyone until a token is added:
+  gitr serve token add ci --write '*'
+  gitr serve token add alice --read <repo-id> --write <other-repo-id>
+From then on every request needs a token, even after the last one is
+revoked, until 'gitr serve auth disable'.`,
+		NoRepo: true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&addr, "addr", "localhost:8080", "`address` to listen on")
+			fs.StringVar(&dir, "dir", defaultServeDir, "`directory` to store repositories in")
+		},
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("unexpected argument: %s", args[0])
+			}
+			return Serve(ctx, addr, dir)
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:    "token",
+				Summary: "Manage access tokens",
+				NoRepo:  true,
+				Subcommands: []*cli.Command{
+					{
+						Name:    "add",
+						Args:    "<name>",
+						Summary: "Create a token and print it",
+						Help:    "Repeat --read and --write for each repository; '*' means every repository,\nand is needed to create repositories. Write access includes read access.",
+						NoRepo:  true,
+						Flags: func(fs *flag.FlagSet) {
+							dirFlag(fs)
+							fs.Var(&read, "read", "grant read access to `repo-id` (repeatable)")
+							fs.Var(&write, "write", "grant write access to `repo-id` (repeatable)")
+						},
+						Run: func(ctx *cli.Context, args []string) error {
+							if len(args) != 1 {
+								return cli.Usagef("usage: gitr serve token add <name> [--read <repo-id>] [--write <repo-id>]")
+							}
+							return ServeTokenAdd(ctx, tokenDir, args[0], read, write)
+						},
+					},
+					{
+						Name:    "list",
+						Summary: "List tokens and their scopes",
+						NoRepo:  true,
+						Flags:   dirFlag,
+						Run: func(ctx *cli.Context, args []string) error {
+							if len(args) > 0 {
+								return cli.Usagef("unexpected argument: %s", args[0])
+							}
+							return ServeTokenList(ctx, tokenDir)
+						},
+					},
+					{
+						Name:    "revoke",
+						Args:    "<name>",
+						Summary: "Delete a token",
+						NoRepo:  true,
+						Flags:   dirFlag,
+						Run: func(ctx *cli.Context, args []string) error {
+							if len(args) != 1 {
+								return cli.Usagef("usage: gitr serve token revoke <name>")
+							}
+							return ServeTokenRevoke(ctx, tokenDir, args[0])
+						},
+					},
+				},
+			},
+			{
+				Name:    "auth",
+				Summary: "Turn token authentication on or off",
+				NoRepo:  true,
+				Subcommands: []*cli.Command{
+					{
+						Name:    "enable",
+						Summary: "Require a token for every request",
+						NoRepo:  true,
+						Flags:   dirFlag,
+						Run: func(ctx *cli.Context, args []string) error {
+							if len(args) > 0 {
+								return cli.Usagef("unexpected argument: %s", args[0])
+							}
+							return ServeAuth(ctx, tokenDir, true)
+						},
+					},
+					{
+						Name:    "disable",
+						Summary: "Let anyone read and write without a token",
+						NoRepo:  true,
+						Flags:   dirFlag,
+						Run: func(ctx *cli.Context, args []string) error {
+							if len(args) > 0 {
+								return cli.Usagef("unexpected argument: %s", args[0])
+							}
+							return ServeAuth(ctx, tokenDir, false)
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Serve runs the remote server until interrupted
+func Serve(ctx *cli.Context, addr, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	store, err := server.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	auth, err := store.AuthEnabled()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{
+		Handler:           server.New(store, ctx.Stderr),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx.Printf("Serving repositories in %s on http://%s\n", dir, listener.Addr())
+	tokens, err := store.Tokens()
+	if err != nil {
+		return err
+	}
+	switch {
+	case !auth:
+		fmt.Fprintln(ctx.Stderr, "Warning: authentication is off, so anyone can read and write. Add a token with 'gitr serve token add'.")
+	case len(tokens) == 0:
+		fmt.Fprintln(ctx.Stderr, "Warning: authentication is on but there are no tokens, so every request is refused. Add one with 'gitr serve token add'.")
+	}
+	ctx.Println("Press Ctrl-C to stop")
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(listener) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server failed: %w", err)
+		}
+	case <-stop.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdown); err != nil {
+			return fmt.Errorf("failed to stop server: %w", err)
+		}
+	}
+
+	ctx.SetResult(map[string]any{"addr": listener.Addr().String(), "dir": dir, "auth": auth})
+	return nil
+}
+
+// ServeTokenAdd creates a token scoped to the given repositories
+func ServeTokenAdd(ctx *cli.Context, dir, name string, read, write []string) error {
+	store, err := server.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+
+	scopes := map[string]server.Access{}
+	for _, repoID := range read {
+		if err := server.CheckScope(repoID); err != nil {
+			return cli.Usagef("%v", err)
+		}
+		scopes[repoID] = server.AccessRead
+	}
+	for _, repoID := range write {
+		if err := server.CheckScope(repoID); err != nil {
+			return cli.Usagef("%v", err)
+		}
+		scopes[repoID] = server.AccessWrite
+	}
+
+	secret, err := store.AddToken(name, scopes)
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Created token '%s'. It won't be shown again:\n\n  %s\n\n", name, secret)
+	ctx.Println("Use it with: gitr remote login")
+	ctx.SetResult(map[string]any{"name": name, "token": secret, "scopes": scopes})
+	return nil
+}
+
+// ServeTokenList prints the tokens and what they may access
+func ServeTokenList(ctx *cli.Context, dir string) error {
+	store, err := server.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	tokens, err := store.Tokens()
+	if err != nil {
+		return err
+	}
+	auth, err := store.AuthEnabled()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !auth:
+		ctx.Println("Authentication is off; the server accepts every request")
+	case len(tokens) == 0:
+		ctx.Println("No tokens; the server refuses every request")
+	}
+	type tokenInfo struct {
+		Name      string                   `json:"name"`
+		Scopes    map[string]server.Access `json:"scopes"`
+		CreatedAt string                   `json:"created_at"`
+	}
+	infos := []tokenInfo{}
+	for _, token := range tokens {
+		var scopes []string
+		for repoID, access := range token.Scopes {
+			scopes = append(scopes, fmt.Sprintf("%s:%s", repoID, access))
+		}
+		sort.Strings(scopes)
+		ctx.Printf("%-16s %s  %s\n", token.Name, token.CreatedAt, strings.Join(scopes, " "))
+		infos = append(infos, tokenInfo{Name: token.Name, Scopes: token.Scopes, CreatedAt: token.CreatedAt})
+	}
+	ctx.SetResult(infos)
+	return nil
+}
+
+// ServeTokenRevoke deletes a token; requests using it fail from then on
+func ServeTokenRevoke(ctx *cli.Context, dir, name string) error {
+	store, err := server.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	if err := store.RevokeToken(name); err != nil {
+		return err
+	}
+	ctx.Printf("Revoked token '%s'\n", name)
+	ctx.SetResult(map[string]string{"name": name})
+	return nil
+}
+
+// ServeAuth turns token authentication on or off
+func ServeAuth(ctx *cli.Context, dir string, enabled bool) error {
+	store, err := server.OpenStore(dir)
+	if err != nil {
+		return err
+	}
+	if err := store.SetAuth(enabled); err != nil {
+		return err
+	}
+
+	tokens, err := store.Tokens()
+	if err != nil {
+		return err
+	}
+	switch {
+	case !enabled:
+		ctx.Println("Authentication is off; anyone can read and write")
+	case len(tokens) == 0:
+		ctx.Println("Authentication is on; add a token with 'gitr serve token add', as every request is refused until then")
+	default:
+		ctx.Printf("Authentication is on; %d token(s) can be used\n", len(tokens))
+	}
+	ctx.SetResult(map[string]any{"auth": enabled, "tokens": len(tokens)})
+	return nil
+}
@@ -1,17 +1,55 @@
 package commands
 
 import (
-	"fmt"
-
+	"github.com/mysticshirou/gitroulette/internal/cli"
 	"github.com/mysticshirou/gitroulette/internal/llm"
+	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
-func Status() error {
+func statusCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "status",
+		Summary: "Show working tree status",
+		Help: `Starts with how the branch compares with the remote branch it tracks, as
+of the last fetch, push or pull; that part is worked out locally.`,
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 0 {
+				return cli.Usagef("unexpected argument: %s", args[0])
+			}
+			return Status(ctx)
+		},
+	}
+}
+
+// StatusResult is the JSON result of status
+type StatusResult struct {
+	Response
+	Tracking *TrackingStatus `json:"tracking,omitempty"`
+}
+
+func Status(ctx *cli.Context) error {
+	branch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return err
+	}
+	tracking, err := trackingStatus(branch)
+	if err != nil {
+		return err
+	}
+	if tracking != nil {
+		ctx.Println(tracking.Describe())
+		ctx.Println()
+	}
+
 	response, err := llm.SendCommand("git status", []string{})
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(response)
+	ctx.Println(response)
+	ctx.SetResult(&StatusResult{
+		Response: Response{Command: "git status", Args: []string{}, Response: response},
+		Tracking: tracking,
+	})
 	return nil
 }
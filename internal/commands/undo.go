@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mysticshirou/gitroulette/internal/cli"
+	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func undoCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "undo",
+		Args:    "[n]",
+		Summary: "Remove the last n command/response pairs from history",
+		Run: func(ctx *cli.Context, args []string) error {
+			if len(args) > 1 {
+				return cli.Usagef("usage: gitr undo [n]")
+			}
+
+			n := 1
+			if len(args) == 1 {
+				var err error
+				n, err = strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					return cli.Usagef("usage: gitr undo [n] (n must be a positive number)")
+				}
+			}
+			return Undo(ctx, n)
+		},
+	}
+}
+
+// Undo removes the last n command/response pairs from the conversation
+func Undo(ctx *cli.Context, n int) error {
+	historyLog, err := config.ActiveHistory()
+	if err != nil {
+		return err
+	}
+
+	// Count and truncate under one lock so turns appended in between
+	// aren't the ones removed
+	total, removed := 0, 0
+	err = repo.WithLock(func() error {
+		history, err := historyLog.Load()
+		if err != nil {
+			return err
+		}
+
+		total = len(history.Turns())
+		if total == 0 {
+			return fmt.Errorf("nothing to undo: history is empty")
+		}
+		if n > total {
+			n = total
+		}
+
+		removed, err = historyLog.Rewind(total - n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("Removed %d turn(s); history now has %d turn(s)\n", removed, total-removed)
+	ctx.Println("Run 'gitr history restore' to bring them back")
+	ctx.SetResult(map[string]int{"removed": removed, "remaining": total - removed})
+	return nil
+}
@@ -3,41 +3,83 @@ package config
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/mysticshirou/gitroulette/internal/repo"
 )
 
 type Config struct {
-	API    APIConfig    `json:"api"`
-	Remote RemoteConfig `json:"remote"`
+	API      APIConfig                `json:"api"`
+	Remote   RemoteConfig             `json:"remote"`
+	History  HistoryConfig            `json:"history"`
+	Color    ColorConfig              `json:"color"`
+	LLM      LLMConfig                `json:"llm"`
+	Profiles map[string]ProfileConfig `json:"profiles"`
 }
 
 type APIConfig struct {
-	URL string `json:"url"`
-	Key string `json:"key"`
+	URL   string `json:"url"`
+	Key   string `json:"key"`
+	Model string `json:"model"`
+
+	// KeyHelper names the credential helper holding the key, so it doesn't
+	// have to be stored in plaintext
+	KeyHelper string `json:"key_helper"`
+
+	Timeout string `json:"timeout"`
 }
 
 type RemoteConfig struct {
-	URL    string `json:"url"`
-	RepoID string `json:"repo_id"`
+	URL     string `json:"url"`
+	RepoID  string `json:"repo_id"`
+	Timeout string `json:"timeout"`
+
+	// Token authenticates to the remote server; TokenHelper names the
+	// credential helper holding it instead
+	Token       string `json:"token"`
+	TokenHelper string `json:"token_helper"`
+
+	// Named holds the remote.<name>.* remotes, keyed by name
+	Named map[string]NamedRemote `json:"-"`
+}
+
+type HistoryConfig struct {
+	// PerBranch keeps a separate conversation log for every branch
+	PerBranch bool `json:"per_branch"`
 }
 
-// Load reads the config from .gitr/config.json
+type ColorConfig struct {
+	// UI is "auto", "always" or "never"
+	UI string `json:"ui"`
+}
+
+// Load reads the system, global and repository config files, merged in
+// that order, with GITR_* environment variables taking precedence
 func Load() (*Config, error) {
-	root, err := repo.GetGitrRoot()
+	entries, err := resolve()
 	if err != nil {
 		return nil, err
 	}
 
-	configPath := filepath.Join(root, repo.GitrDir, repo.ConfigFile)
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &Config{}, nil
+	flat := map[string]any{}
+	for _, option := range Schema {
+		if option.Default == "" || strings.Contains(option.Key, "*") {
+			continue
 		}
-		return nil, fmt.Errorf("failed to read config: %w", err)
+		value, err := option.Parse(option.Key, option.Default)
+		if err != nil {
+			return nil, err
+		}
+		flat[option.Key] = value
+	}
+	for key, entry := range entries {
+		flat[key] = entry.Value
+	}
+
+	data, err := json.Marshal(unflatten(flat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize config: %w", err)
 	}
 
 	var config Config
@@ -48,84 +90,204 @@ func Load() (*Config, error) {
 	return &config, nil
 }
 
-// Save writes the config to .gitr/config.json
-func Save(config *Config) error {
-	root, err := repo.GetGitrRoot()
+// DefaultScope is where 'gitr config set' writes without --global/--local:
+// the repository if there is one, otherwise the user's global config
+func DefaultScope() Scope {
+	if _, err := repo.GetGitrRoot(); err == nil {
+		return ScopeLocal
+	}
+	return ScopeGlobal
+}
+
+// Set sets a config value (e.g., "api.url" or "api.key") in the default scope
+func Set(key, value string) error {
+	return SetIn(DefaultScope(), key, value)
+}
+
+// SetIn sets a config value in one config file
+func SetIn(scope Scope, key, value string) error {
+	option, err := LookupOption(key)
+	if err != nil {
+		return err
+	}
+	parsed, err := option.Parse(key, value)
 	if err != nil {
 		return err
 	}
 
-	configPath := filepath.Join(root, repo.GitrDir, repo.ConfigFile)
-	data, err := json.MarshalIndent(config, "", "  ")
+	update := func() error {
+		layer, _, err := readLayer(scope)
+		if err != nil {
+			return err
+		}
+
+		setPath(layer, key, parsed)
+		return writeLayer(scope, layer)
+	}
+	if scope == ScopeLocal {
+		return repo.WithLock(update)
+	}
+	return update()
+}
+
+// Unset removes a config value from one config file
+func Unset(scope Scope, key string) error {
+	update := func() error {
+		layer, _, err := readLayer(scope)
+		if err != nil {
+			return err
+		}
+
+		if !deletePath(layer, key) {
+			return &NotSetError{Key: key, Scope: scope}
+		}
+		return writeLayer(scope, layer)
+	}
+	if scope == ScopeLocal {
+		return repo.WithLock(update)
+	}
+	return update()
+}
+
+// NotSetError is returned by Unset for keys the config file doesn't contain
+type NotSetError struct {
+	Key   string
+	Scope Scope
+}
+
+func (e *NotSetError) Error() string {
+	return fmt.Sprintf("%s is not set in %s config", e.Key, e.Scope)
+}
+
+// Get retrieves a merged config value, or the option's default if it
+// isn't set anywhere
+func Get(key string) (string, error) {
+	option, err := LookupOption(key)
 	if err != nil {
-		return fmt.Errorf("failed to serialize config: %w", err)
+		return "", err
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	if option.Secret {
+		config, err := Load()
+		if err != nil {
+			return "", err
+		}
+		return Secret(config, key)
 	}
 
-	return nil
+	entry, ok, err := Lookup(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return option.Default, nil
+	}
+	return option.Format(entry.Value), nil
 }
 
-// Set sets a config value (e.g., "api.url" or "api.key")
-func Set(key, value string) error {
-	config, err := Load()
+// Lookup returns a merged value together with where it came from. The
+// Origin is empty if the key isn't set anywhere.
+func Lookup(key string) (Entry, bool, error) {
+	entries, err := resolve()
 	if err != nil {
-		return err
+		return Entry{}, false, err
 	}
+	entry, ok := entries[key]
+	return entry, ok, nil
+}
 
-	switch key {
-	case "api.url":
-		config.API.URL = value
-	case "api.key":
-		config.API.Key = value
-	case "remote.url":
-		config.Remote.URL = value
-	case "remote.repo_id":
-		config.Remote.RepoID = value
-	default:
-		return fmt.Errorf("unknown config key: %s", key)
+// List returns every set value, merged across layers, ordered by key
+func List() ([]Entry, error) {
+	entries, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+	return sortedEntries(entries), nil
+}
+
+// ListIn returns the values set in a single config file, ordered by key
+func ListIn(scope Scope) ([]Entry, error) {
+	layer, path, err := readLayer(scope)
+	if err != nil {
+		return nil, err
 	}
 
-	return Save(config)
+	entries := map[string]Entry{}
+	for key, value := range flatten(layer) {
+		entries[key] = Entry{Key: key, Value: value, Origin: Origin{Scope: scope, Path: path}}
+	}
+	return sortedEntries(entries), nil
 }
 
-// Get retrieves a config value
-func Get(key string) (string, error) {
-	config, err := Load()
+// Aliases returns the alias.<name> entries, keyed by name
+func Aliases() (map[string]string, error) {
+	entries, err := resolve()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	switch key {
-	case "api.url":
-		return config.API.URL, nil
-	case "api.key":
-		return config.API.Key, nil
-	case "remote.url":
-		return config.Remote.URL, nil
-	case "remote.repo_id":
-		return config.Remote.RepoID, nil
-	default:
-		return "", fmt.Errorf("unknown config key: %s", key)
+	aliases := map[string]string{}
+	for key, entry := range entries {
+		if name, ok := strings.CutPrefix(key, "alias."); ok {
+			if definition, ok := entry.Value.(string); ok && definition != "" {
+				aliases[name] = definition
+			}
+		}
 	}
+	return aliases, nil
 }
 
-// Validate checks if the required config values are set
+// Validate checks every set value against the schema. What a command
+// needs to talk to the LLM depends on its profile, so ResolveProfile (or
+// CheckProfile) reports anything missing there.
 func Validate() error {
-	config, err := Load()
+	entries, err := resolve()
 	if err != nil {
 		return err
 	}
 
-	if config.API.URL == "" {
-		return fmt.Errorf("api.url is not set. Run: gitr config set api.url <url>")
+	for _, entry := range sortedEntries(entries) {
+		option, err := LookupOption(entry.Key)
+		if err != nil {
+			return fmt.Errorf("unknown config key %s in %s", entry.Key, entry.Origin)
+		}
+		if err := option.Check(entry.Key, entry.Value); err != nil {
+			return fmt.Errorf("invalid value in %s: %w", entry.Origin, err)
+		}
 	}
+	return nil
+}
 
-	if config.API.Key == "" {
-		return fmt.Errorf("api.key is not set. Run: gitr config set api.key <key>")
+// Duration parses a duration option already checked by the schema; zero
+// means no limit
+func Duration(value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
 	}
+	return d
+}
 
-	return nil
+// ActiveHistory returns the conversation log for the current branch: its
+// own log if history.per_branch is set, otherwise the shared one
+func ActiveHistory() (repo.HistoryLog, error) {
+	config, err := Load()
+	if err != nil {
+		return repo.HistoryLog{}, err
+	}
+
+	return HistoryFor(config)
+}
+
+// HistoryFor is ActiveHistory for an already loaded config
+func HistoryFor(config *Config) (repo.HistoryLog, error) {
+	if !config.History.PerBranch {
+		return repo.SharedHistory(), nil
+	}
+
+	branch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return repo.HistoryLog{}, fmt.Errorf("failed to get current branch: %w", err)
+	}
+	return repo.BranchHistory(branch), nil
 }
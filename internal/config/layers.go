@@ -0,0 +1,261 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// Scope identifies one layer of configuration
+type Scope string
+
+const (
+	ScopeSystem Scope = "system"
+	ScopeGlobal Scope = "global"
+	ScopeLocal  Scope = "local"
+	ScopeEnv    Scope = "env"
+)
+
+// Scopes lists the file layers in the order they are merged; later layers win
+var Scopes = []Scope{ScopeSystem, ScopeGlobal, ScopeLocal}
+
+// Path returns the config file backing a scope. The system and global
+// locations can be moved with GITR_CONFIG_SYSTEM and GITR_CONFIG_GLOBAL.
+func (s Scope) Path() (string, error) {
+	switch s {
+	case ScopeSystem:
+		if path := os.Getenv("GITR_CONFIG_SYSTEM"); path != "" {
+			return path, nil
+		}
+		if runtime.GOOS == "windows" {
+			return filepath.Join(os.Getenv("ProgramData"), "gitr", ConfigFileName), nil
+		}
+		return filepath.Join("/etc", "gitr", ConfigFileName), nil
+
+	case ScopeGlobal:
+		if path := os.Getenv("GITR_CONFIG_GLOBAL"); path != "" {
+			return path, nil
+		}
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate user config directory: %w", err)
+		}
+		return filepath.Join(dir, "gitr", ConfigFileName), nil
+
+	case ScopeLocal:
+		root, err := repo.GetGitrRoot()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(root, repo.GitrDir, repo.ConfigFile), nil
+
+	default:
+		return "", fmt.Errorf("scope %s has no config file", s)
+	}
+}
+
+// ConfigFileName is the file name used by the system and global layers
+const ConfigFileName = "config.json"
+
+// Origin records where a merged config value came from
+type Origin struct {
+	Scope Scope
+	Path  string // file path, or environment variable name for ScopeEnv
+}
+
+func (o Origin) String() string {
+	if o.Scope == ScopeEnv {
+		return "env:" + o.Path
+	}
+	return string(o.Scope) + ":" + o.Path
+}
+
+// Entry is a single resolved config value
+type Entry struct {
+	Key    string
+	Value  any
+	Origin Origin
+}
+
+// EnvVar returns the environment variable that overrides a key,
+// e.g. GITR_API_KEY for api.key
+func EnvVar(key string) string {
+	return "GITR_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// readLayer reads the raw contents of one config file. Missing files are empty.
+func readLayer(scope Scope) (map[string]any, string, error) {
+	path, err := scope.Path()
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]any{}, path, nil
+		}
+		return nil, path, fmt.Errorf("failed to read %s config: %w", scope, err)
+	}
+
+	layer := map[string]any{}
+	if err := json.Unmarshal(data, &layer); err != nil {
+		return nil, path, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return layer, path, nil
+}
+
+// writeLayer writes the raw contents of one config file
+func writeLayer(scope Scope, layer map[string]any) error {
+	path, err := scope.Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %w", err)
+	}
+
+	write := func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// Config files can hold secrets, so keep them private to the user
+		return repo.WriteFileAtomic(path, data, 0600)
+	}
+	if scope == ScopeLocal {
+		err = repo.WithLock(write)
+	} else {
+		err = write()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// resolve merges every available layer and the environment into a flat
+// key -> entry map
+func resolve() (map[string]Entry, error) {
+	entries := map[string]Entry{}
+
+	for _, scope := range Scopes {
+		if scope == ScopeLocal {
+			if _, err := repo.GetGitrRoot(); err != nil {
+				continue // outside a repository there is no local layer
+			}
+		}
+
+		layer, path, err := readLayer(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range flatten(layer) {
+			entries[key] = Entry{Key: key, Value: value, Origin: Origin{Scope: scope, Path: path}}
+		}
+	}
+
+	for _, option := range Schema {
+		if strings.Contains(option.Key, "*") {
+			continue // only fixed keys have environment overrides
+		}
+		key := option.Key
+		name := EnvVar(key)
+		raw, ok := os.LookupEnv(name)
+		if !ok || raw == "" {
+			continue
+		}
+
+		value, err := option.Parse(key, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		entries[key] = Entry{Key: key, Value: value, Origin: Origin{Scope: ScopeEnv, Path: name}}
+	}
+
+	return entries, nil
+}
+
+// sortedEntries returns entries ordered by key
+func sortedEntries(entries map[string]Entry) []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// flatten turns nested config objects into dotted keys
+func flatten(layer map[string]any) map[string]any {
+	flat := map[string]any{}
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for key, value := range m {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if nested, ok := value.(map[string]any); ok {
+				walk(key, nested)
+				continue
+			}
+			flat[key] = value
+		}
+	}
+	walk("", layer)
+	return flat
+}
+
+// unflatten turns dotted keys back into nested objects
+func unflatten(flat map[string]any) map[string]any {
+	layer := map[string]any{}
+	for key, value := range flat {
+		setPath(layer, key, value)
+	}
+	return layer
+}
+
+// setPath sets a dotted key inside nested objects, creating them as needed
+func setPath(layer map[string]any, key string, value any) {
+	parts := strings.Split(key, ".")
+	m := layer
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// deletePath removes a dotted key and any objects left empty by its removal
+func deletePath(layer map[string]any, key string) bool {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		if _, ok := layer[key]; !ok {
+			return false
+		}
+		delete(layer, key)
+		return true
+	}
+
+	nested, ok := layer[parts[0]].(map[string]any)
+	if !ok || !deletePath(nested, parts[1]) {
+		return false
+	}
+	if len(nested) == 0 {
+		delete(layer, parts[0])
+	}
+	return true
+}
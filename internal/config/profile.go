@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultModel is used when neither api.model nor the profile names one
+const DefaultModel = "deepseek-chat"
+
+// DefaultProfile is the name of the provider described by the api.* keys
+const DefaultProfile = "default"
+
+type LLMConfig struct {
+	// Profile is the profile used when a command has no mapping of its own
+	Profile string `json:"profile"`
+
+	// CommandProfile maps command names (e.g. "status") to profiles
+	CommandProfile map[string]string `json:"command_profile"`
+}
+
+// ProfileConfig is a named LLM provider. Unset fields fall back to api.*.
+type ProfileConfig struct {
+	URL         string   `json:"url"`
+	Model       string   `json:"model"`
+	Key         string   `json:"key"`
+	KeyHelper   string   `json:"key_helper"`
+	Temperature *float64 `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+}
+
+// Profile is a fully resolved provider for a single request
+type Profile struct {
+	Name        string
+	URL         string
+	Model       string
+	Key         string
+	Temperature *float64
+	MaxTokens   int
+}
+
+// ProfileName picks the profile for a command: GITR_PROFILE (set by the
+// --profile flag), then llm.command_profile.<command>, then llm.profile
+func ProfileName(config *Config, command string) string {
+	if name := os.Getenv("GITR_PROFILE"); name != "" {
+		return name
+	}
+	command = strings.TrimPrefix(command, "git ")
+	if name := config.LLM.CommandProfile[command]; name != "" {
+		return name
+	}
+	if config.LLM.Profile != "" {
+		return config.LLM.Profile
+	}
+	return DefaultProfile
+}
+
+// ResolveProfile returns the provider to use for a command, including its key
+func ResolveProfile(config *Config, command string) (*Profile, error) {
+	profile, keyName, err := profileSettings(config, command)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := Secret(config, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, missingKeyError(keyName)
+	}
+	profile.Key = key
+
+	return profile, nil
+}
+
+// CheckProfile reports what ResolveProfile would find missing for a
+// command, without asking a credential helper for the key
+func CheckProfile(config *Config, command string) error {
+	_, keyName, err := profileSettings(config, command)
+	if err != nil {
+		return err
+	}
+	if !secretConfigured(config, keyName) {
+		return missingKeyError(keyName)
+	}
+	return nil
+}
+
+// profileSettings resolves everything about a command's profile except
+// its key, returning the key that holds it
+func profileSettings(config *Config, command string) (*Profile, string, error) {
+	name := ProfileName(config, command)
+
+	profile := &Profile{
+		Name:  name,
+		URL:   config.API.URL,
+		Model: config.API.Model,
+	}
+
+	keyName := "api.key"
+	urlName := "api.url"
+	if name != DefaultProfile {
+		named, ok := config.Profiles[name]
+		if !ok {
+			return nil, "", fmt.Errorf("profile '%s' is not configured. Run: gitr config set profiles.%s.url <url>", name, name)
+		}
+
+		if named.URL != "" {
+			profile.URL = named.URL
+		}
+		if named.Model != "" {
+			profile.Model = named.Model
+		}
+		if named.Key != "" || named.KeyHelper != "" || os.Getenv(EnvVar("profiles."+name+".key")) != "" {
+			keyName = "profiles." + name + ".key"
+		}
+		profile.Temperature = named.Temperature
+		profile.MaxTokens = named.MaxTokens
+		urlName = "profiles." + name + ".url"
+	}
+
+	if profile.Model == "" {
+		profile.Model = DefaultModel
+	}
+	if profile.URL == "" {
+		return nil, "", fmt.Errorf("no API URL for profile '%s'. Run: gitr config set %s <url>", name, urlName)
+	}
+	return profile, keyName, nil
+}
+
+func missingKeyError(keyName string) error {
+	return fmt.Errorf("%s is not set. Run: gitr config set %s <key>", keyName, keyName)
+}
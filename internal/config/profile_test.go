@@ -0,0 +1,97 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inGlobalConfig runs the test outside any repository with empty system
+// and global config files and no GITR_* overrides
+func inGlobalConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("GITR_CONFIG_SYSTEM", filepath.Join(dir, "system.json"))
+	t.Setenv("GITR_CONFIG_GLOBAL", filepath.Join(dir, "global.json"))
+	t.Setenv("GITR_PROFILE", "")
+	for _, key := range []string{"api.url", "api.key", "llm.profile", "profiles.work.url", "profiles.work.key", "remote.url", "remote.token"} {
+		t.Setenv(EnvVar(key), "")
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func setGlobal(t *testing.T, values map[string]string) {
+	t.Helper()
+	for key, value := range values {
+		if err := SetIn(ScopeGlobal, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProfileOnlySetup(t *testing.T) {
+	inGlobalConfig(t)
+	setGlobal(t, map[string]string{
+		"llm.profile":       "work",
+		"profiles.work.url": "https://llm.example.com/v1/chat/completions",
+		"profiles.work.key": "sk-work",
+	})
+
+	if err := Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckProfile(config, "git status"); err != nil {
+		t.Errorf("CheckProfile() = %v", err)
+	}
+	profile, err := ResolveProfile(config, "git status")
+	if err != nil {
+		t.Fatalf("ResolveProfile() = %v", err)
+	}
+	if profile.Name != "work" || profile.Key != "sk-work" {
+		t.Errorf("ResolveProfile() = %s with key %q, want work with sk-work", profile.Name, profile.Key)
+	}
+}
+
+func TestCheckProfileReportsWhatIsMissing(t *testing.T) {
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{"nothing set", nil, "api.url"},
+		{"no key", map[string]string{"api.url": "https://llm.example.com"}, "api.key is not set"},
+		{"unknown profile", map[string]string{"llm.profile": "work"}, "profile 'work' is not configured"},
+		{"profile without url", map[string]string{"llm.profile": "work", "profiles.work.key": "sk-work"}, "profiles.work.url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inGlobalConfig(t)
+			setGlobal(t, tt.values)
+
+			if err := Validate(); err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			config, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckProfile(config, "git status")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CheckProfile() = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
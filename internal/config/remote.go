@@ -0,0 +1,239 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/credential"
+	"github.com/mysticshirou/gitroulette/internal/trace"
+)
+
+// DefaultRemote is the name of the remote described by remote.url and
+// remote.repo_id, and the one used when a branch tracks none
+const DefaultRemote = "origin"
+
+// NamedRemote is a remote configured with remote.<name>.* keys
+type NamedRemote struct {
+	URL    string `json:"url"`
+	RepoID string `json:"repo_id"`
+
+	Token       string `json:"token"`
+	TokenHelper string `json:"token_helper"`
+}
+
+// UnmarshalJSON reads the fixed remote.* keys and collects every
+// remote.<name> object into Named
+func (r *RemoteConfig) UnmarshalJSON(data []byte) error {
+	type plain RemoteConfig
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, raw := range fields {
+		if !strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+			continue
+		}
+		var named NamedRemote
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return fmt.Errorf("remote %s: %w", name, err)
+		}
+		if r.Named == nil {
+			r.Named = map[string]NamedRemote{}
+		}
+		r.Named[name] = named
+	}
+	return nil
+}
+
+// Remote is a fully resolved remote
+type Remote struct {
+	Name   string
+	URL    string
+	RepoID string
+}
+
+// ValidateRemoteName rejects names that can't be used in remote.<name>.*
+// keys or as a directory under refs/remotes
+func ValidateRemoteName(name string) error {
+	if name == "" {
+		return fmt.Errorf("remote name cannot be empty")
+	}
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid remote name: %s", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("invalid remote name: %s (use letters, digits, '-' and '_')", name)
+		}
+	}
+	if _, err := LookupOption("remote." + name); err == nil {
+		return fmt.Errorf("invalid remote name: %s (it is a remote.* setting)", name)
+	}
+	return nil
+}
+
+// RemoteNames returns every configured remote, sorted. The default remote
+// is included when remote.url or remote.repo_id is set.
+func RemoteNames(config *Config) []string {
+	names := []string{}
+	for name := range config.Remote.Named {
+		if ValidateRemoteName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	if _, ok := config.Remote.Named[DefaultRemote]; !ok && (config.Remote.URL != "" || config.Remote.RepoID != "") {
+		names = append(names, DefaultRemote)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupRemote returns a configured remote. The default remote's unset
+// fields fall back to remote.url and remote.repo_id.
+func LookupRemote(config *Config, name string) (*Remote, bool) {
+	if ValidateRemoteName(name) != nil {
+		return nil, false
+	}
+
+	named, ok := config.Remote.Named[name]
+	remote := &Remote{Name: name, URL: named.URL, RepoID: named.RepoID}
+	if name == DefaultRemote {
+		ok = ok || config.Remote.URL != "" || config.Remote.RepoID != ""
+		if remote.URL == "" {
+			remote.URL = config.Remote.URL
+		}
+		if remote.RepoID == "" {
+			remote.RepoID = config.Remote.RepoID
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return remote, true
+}
+
+// RemoteKey returns the key holding one field of a remote, e.g.
+// remote.upstream.url. The default remote uses remote.<field> unless it
+// is configured by name.
+func RemoteKey(config *Config, name, field string) string {
+	if _, ok := config.Remote.Named[name]; name == DefaultRemote && !ok {
+		return "remote." + field
+	}
+	return "remote." + name + "." + field
+}
+
+// RemoteToken returns the token for a remote: remote.<name>.token if it is
+// set, otherwise remote.token, which is only sent to the default remote
+// and to remotes on the same server. A token from a config file is only
+// sent to the URL it was issued for (see TokenURLKey), so one stored
+// globally never reaches a server a repository was just pointed at.
+func RemoteToken(config *Config, remote *Remote) (string, error) {
+	token, err := boundToken(config, "remote."+remote.Name+".token", remote.URL)
+	if token != "" || (err != nil && !errors.Is(err, credential.ErrNotFound)) {
+		return token, err
+	}
+	if remote.Name != DefaultRemote && remote.URL != config.Remote.URL {
+		return "", nil
+	}
+	return boundToken(config, "remote.token", remote.URL)
+}
+
+// TokenURLKey returns the key recording which server a token key's token
+// was issued for, e.g. remote.token_url for remote.token
+func TokenURLKey(tokenKey string) string {
+	return tokenKey + "_url"
+}
+
+// boundToken resolves a token key for a request to url. The environment
+// and credential helpers (which key tokens by URL) are trusted as they
+// are; a token from a config file only if the same file says it is for
+// url, with the token's _url key or else the remote's own URL key.
+func boundToken(config *Config, key, url string) (string, error) {
+	_, helperName, _, err := secretRequest(config, key)
+	if err != nil {
+		return "", err
+	}
+	if os.Getenv(EnvVar(key)) != "" || helperName != "" {
+		return Secret(config, key)
+	}
+
+	entry, ok, err := Lookup(key)
+	if err != nil || !ok {
+		return "", err
+	}
+	token, _ := entry.Value.(string)
+	if token == "" {
+		return "", nil
+	}
+
+	entries, err := ListIn(entry.Origin.Scope)
+	if err != nil {
+		return "", err
+	}
+	values := map[string]string{}
+	for _, e := range entries {
+		values[e.Key], _ = e.Value.(string)
+	}
+	prefix := strings.TrimSuffix(key, "token")
+	issued := values[TokenURLKey(key)]
+	if issued == "" {
+		issued = values[prefix+"url"]
+	}
+	if issued == "" && key == "remote.token" {
+		issued = values["remote."+DefaultRemote+".url"]
+	}
+	if !sameURL(issued, url) {
+		trace.Printf("not sending %s from %s to %s: it was issued for %q", key, entry.Origin, url, issued)
+		return "", nil
+	}
+	return token, nil
+}
+
+// sameURL compares server URLs, ignoring a trailing slash
+func sameURL(a, b string) bool {
+	return a != "" && strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
+
+// TrackingKey returns the key naming the remote a branch follows
+func TrackingKey(branch string) string {
+	return "branch." + branch + ".remote"
+}
+
+// TrackedRemote returns the remote a branch follows, or "" if it has none
+func TrackedRemote(branch string) (string, error) {
+	entry, ok, err := Lookup(TrackingKey(branch))
+	if err != nil || !ok {
+		return "", err
+	}
+	name, _ := entry.Value.(string)
+	return name, nil
+}
+
+// Tracking returns the remote each branch follows, keyed by branch
+func Tracking() (map[string]string, error) {
+	entries, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	tracking := map[string]string{}
+	for key, entry := range entries {
+		rest, ok := strings.CutPrefix(key, "branch.")
+		if !ok {
+			continue
+		}
+		branch, ok := strings.CutSuffix(rest, ".remote")
+		if name, _ := entry.Value.(string); ok && name != "" {
+			tracking[branch] = name
+		}
+	}
+	return tracking, nil
+}
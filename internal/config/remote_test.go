@@ -0,0 +1,95 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+func TestRemoteTokenOnlyGoesWhereItWasIssued(t *testing.T) {
+	tests := []struct {
+		name   string
+		global map[string]string
+		local  map[string]string
+		remote string
+		want   string
+	}{
+		{
+			name:   "global token for this server",
+			global: map[string]string{"remote.token": "gitr_a", "remote.token_url": "https://a.example"},
+			local:  map[string]string{"remote.url": "https://a.example/"},
+			want:   "gitr_a",
+		},
+		{
+			name:   "global token for another server",
+			global: map[string]string{"remote.token": "gitr_a", "remote.token_url": "https://a.example"},
+			local:  map[string]string{"remote.url": "https://b.example"},
+		},
+		{
+			name:   "global token for no server in particular",
+			global: map[string]string{"remote.token": "gitr_a"},
+			local:  map[string]string{"remote.url": "https://b.example"},
+		},
+		{
+			name:  "local token next to its url",
+			local: map[string]string{"remote.token": "gitr_b", "remote.url": "https://b.example"},
+			want:  "gitr_b",
+		},
+		{
+			name:  "local token issued for the old url",
+			local: map[string]string{"remote.token": "gitr_b", "remote.token_url": "https://old.example", "remote.url": "https://b.example"},
+		},
+		{
+			name:   "named remote on the same server",
+			local:  map[string]string{"remote.token": "gitr_b", "remote.url": "https://b.example", "remote.fork.url": "https://b.example", "remote.fork.repo_id": "x"},
+			remote: "fork",
+			want:   "gitr_b",
+		},
+		{
+			name:   "named remote on another server",
+			local:  map[string]string{"remote.token": "gitr_b", "remote.url": "https://b.example", "remote.fork.url": "https://c.example", "remote.fork.repo_id": "x"},
+			remote: "fork",
+		},
+		{
+			name:   "named remote's own token",
+			global: map[string]string{"remote.fork.token": "gitr_c", "remote.fork.token_url": "https://c.example"},
+			local:  map[string]string{"remote.fork.url": "https://c.example", "remote.fork.repo_id": "x"},
+			remote: "fork",
+			want:   "gitr_c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inGlobalConfig(t)
+			if err := repo.Init(); err != nil {
+				t.Fatal(err)
+			}
+			setGlobal(t, tt.global)
+			for key, value := range tt.local {
+				if err := SetIn(ScopeLocal, key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := tt.remote
+			if name == "" {
+				name = DefaultRemote
+			}
+			remote, ok := LookupRemote(config, name)
+			if !ok {
+				t.Fatalf("remote %s is not configured", name)
+			}
+			token, err := RemoteToken(config, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.want {
+				t.Errorf("RemoteToken() = %q, want %q", token, tt.want)
+			}
+		})
+	}
+}
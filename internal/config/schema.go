@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the kind of value a config option holds
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeURL
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeDuration
+	TypeEnum
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeURL:
+		return "url"
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "number"
+	case TypeBool:
+		return "bool"
+	case TypeDuration:
+		return "duration"
+	case TypeEnum:
+		return "enum"
+	default:
+		return "string"
+	}
+}
+
+// Option describes one config key
+type Option struct {
+	// Key is the dotted key; a "*" segment matches any single name,
+	// e.g. profiles.*.url, and a "**" segment one or more, for names that
+	// may contain dots, e.g. branch.**.remote
+	Key         string
+	Type        Type
+	Default     string
+	Description string
+
+	// Values lists the allowed values of an enum
+	Values []string
+
+	// Secret values are masked when shown and can live in a credential
+	// helper named by <key>_helper
+	Secret bool
+
+	// Validate adds checks beyond the type's own, given the parsed value
+	Validate func(value any) error
+}
+
+// Schema lists every supported config option
+var Schema = []*Option{
+	{Key: "api.url", Type: TypeURL, Description: "OpenAI-compatible chat completions endpoint"},
+	{Key: "api.key", Type: TypeString, Secret: true, Description: "API authentication key"},
+	{Key: "api.key_helper", Type: TypeString, Description: `Credential helper holding api.key ("file" or <name> for gitr-credential-<name>)`},
+	{Key: "api.model", Type: TypeString, Default: DefaultModel, Description: "Model name sent with every request"},
+	{Key: "api.timeout", Type: TypeDuration, Default: "2m", Description: "How long to wait for the LLM to answer", Validate: positiveDuration},
+	{Key: "remote.url", Type: TypeURL, Description: "Remote server URL"},
+	{Key: "remote.repo_id", Type: TypeString, Description: "Repository ID on the remote server"},
+	{Key: "remote.token", Type: TypeString, Secret: true, Description: "Bearer token sent to the remote server"},
+	{Key: "remote.token_helper", Type: TypeString, Description: "Credential helper holding remote.token"},
+	{Key: "remote.token_url", Type: TypeURL, Description: "Server remote.token was issued for; a token in a config file goes only there (default: remote.url in the same file)"},
+	{Key: "remote.timeout", Type: TypeDuration, Default: "30s", Description: "How long to wait for the remote server", Validate: positiveDuration},
+	{Key: "remote.*.url", Type: TypeURL, Description: "Server URL of a named remote (remote.origin.* overrides remote.url and remote.repo_id)"},
+	{Key: "remote.*.repo_id", Type: TypeString, Description: "Repository ID of a named remote"},
+	{Key: "remote.*.token", Type: TypeString, Secret: true, Description: "Bearer token for a named remote (default: remote.token, if on the same server)"},
+	{Key: "remote.*.token_helper", Type: TypeString, Description: "Credential helper holding the named remote's token"},
+	{Key: "remote.*.token_url", Type: TypeURL, Description: "Server the named remote's token was issued for (default: remote.<name>.url in the same file)"},
+	{Key: "branch.**.remote", Type: TypeString, Description: "Remote a branch pushes to and pulls from when none is named", Validate: nonEmpty},
+	{Key: "color.ui", Type: TypeEnum, Default: "auto", Values: []string{"auto", "always", "never"}, Description: "Color output: auto colors only on a terminal"},
+	{Key: "history.per_branch", Type: TypeBool, Default: "false", Description: "Keep a separate conversation history per branch"},
+	{Key: "llm.profile", Type: TypeString, Default: DefaultProfile, Description: "Profile used when a command has no mapping of its own"},
+	{Key: "llm.command_profile.*", Type: TypeString, Description: "Profile used for one command, e.g. llm.command_profile.merge"},
+	{Key: "profiles.*.url", Type: TypeURL, Description: "Endpoint of a named profile (default: api.url)"},
+	{Key: "profiles.*.model", Type: TypeString, Description: "Model of a named profile (default: api.model)"},
+	{Key: "profiles.*.key", Type: TypeString, Secret: true, Description: "Key of a named profile (default: api.key)"},
+	{Key: "profiles.*.key_helper", Type: TypeString, Description: "Credential helper holding the profile's key"},
+	{Key: "profiles.*.temperature", Type: TypeFloat, Description: "Sampling temperature of a named profile (0-2)", Validate: floatRange(0, 2)},
+	{Key: "profiles.*.max_tokens", Type: TypeInt, Description: "Response token limit of a named profile", Validate: positiveInt},
+	{Key: "alias.*", Type: TypeString, Description: `Command alias, e.g. alias.st = "status"; a leading "!" runs a shell command`, Validate: nonEmpty},
+}
+
+// LookupOption finds the option describing a key. A key can't sit inside
+// the value of another, e.g. remote.token.url under remote.token.
+func LookupOption(key string) (*Option, error) {
+	for _, option := range Schema {
+		if option.Matches(key) && !insideOption(key) {
+			return option, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown config key: %s (run 'gitr config describe' for the list)", key)
+}
+
+// insideOption reports whether a prefix of key is itself a config key
+func insideOption(key string) bool {
+	for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key[:i], ".") {
+		for _, option := range Schema {
+			if option.Matches(key[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches reports whether a concrete key is described by this option
+func (o *Option) Matches(key string) bool {
+	parts := strings.Split(key, ".")
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	return matchParts(strings.Split(o.Key, "."), parts)
+}
+
+func matchParts(pattern, parts []string) bool {
+	if len(pattern) == 0 || len(parts) == 0 {
+		return len(pattern) == len(parts)
+	}
+	switch pattern[0] {
+	case "**":
+		for n := 1; n <= len(parts); n++ {
+			if matchParts(pattern[1:], parts[n:]) {
+				return true
+			}
+		}
+		return false
+	case "*", parts[0]:
+		return matchParts(pattern[1:], parts[1:])
+	default:
+		return false
+	}
+}
+
+// Parse converts a string from the command line or environment into the
+// value stored in config files, rejecting values of the wrong type
+func (o *Option) Parse(key, value string) (any, error) {
+	var parsed any
+	switch o.Type {
+	case TypeURL:
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s must be an http(s) URL, got %q", key, value)
+		}
+		parsed = value
+
+	case TypeInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number, got %q", key, value)
+		}
+		parsed = n
+
+	case TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, got %q", key, value)
+		}
+		parsed = f
+
+	case TypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+		parsed = b
+
+	case TypeDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("%s must be a duration such as 30s or 2m, got %q", key, value)
+		}
+		parsed = value
+
+	case TypeEnum:
+		if !contains(o.Values, value) {
+			return nil, fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(o.Values, ", "), value)
+		}
+		parsed = value
+
+	default:
+		parsed = value
+	}
+
+	if o.Validate != nil {
+		if err := o.Validate(parsed); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return parsed, nil
+}
+
+// Check validates a value read from a config file. Empty strings, which
+// 'gitr init' writes for keys it leaves unset, are accepted.
+func (o *Option) Check(key string, value any) error {
+	if value == "" {
+		return nil
+	}
+	_, err := o.Parse(key, o.Format(value))
+	return err
+}
+
+// Format renders a stored value for display
+func (o *Option) Format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		if o.Type == TypeInt {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Describe returns a one-line summary of the option's type
+func (o *Option) Describe() string {
+	if o.Type == TypeEnum {
+		return "enum(" + strings.Join(o.Values, "|") + ")"
+	}
+	return o.Type.String()
+}
+
+func positiveDuration(value any) error {
+	d, _ := time.ParseDuration(value.(string))
+	if d <= 0 {
+		return fmt.Errorf("must be greater than zero")
+	}
+	return nil
+}
+
+func nonEmpty(value any) error {
+	if strings.TrimSpace(value.(string)) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func positiveInt(value any) error {
+	if value.(int) <= 0 {
+		return fmt.Errorf("must be greater than zero")
+	}
+	return nil
+}
+
+func floatRange(min, max float64) func(any) error {
+	return func(value any) error {
+		if f := value.(float64); f < min || f > max {
+			return fmt.Errorf("must be between %g and %g", min, max)
+		}
+		return nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
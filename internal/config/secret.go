@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/credential"
+)
+
+// IsSecret reports whether a key holds a credential that should be masked
+// when displayed and can be kept in a credential helper
+func IsSecret(key string) bool {
+	option, err := LookupOption(key)
+	return err == nil && option.Secret
+}
+
+// HelperKey returns the key naming the credential helper for a secret key
+func HelperKey(key string) string {
+	return key + "_helper"
+}
+
+// secretRequest returns the plaintext value, helper name and credential
+// request for a secret key
+func secretRequest(config *Config, key string) (string, string, credential.Request, error) {
+	switch {
+	case key == "api.key":
+		return config.API.Key, config.API.KeyHelper, credential.Request{Key: key, URL: config.API.URL}, nil
+	case key == "remote.token":
+		return config.Remote.Token, config.Remote.TokenHelper, credential.Request{Key: key, URL: config.Remote.URL}, nil
+	case IsSecret(key) && strings.HasPrefix(key, "remote."):
+		name := strings.Split(key, ".")[1]
+		named := config.Remote.Named[name]
+		url, helper := named.URL, named.TokenHelper
+		if url == "" && name == DefaultRemote {
+			url = config.Remote.URL
+		}
+		if helper == "" {
+			helper = config.Remote.TokenHelper
+		}
+		return named.Token, helper, credential.Request{Key: key, URL: url}, nil
+	case IsSecret(key) && strings.HasPrefix(key, "profiles."):
+		profile := config.Profiles[strings.Split(key, ".")[1]]
+		url := profile.URL
+		if url == "" {
+			url = config.API.URL
+		}
+		return profile.Key, profile.KeyHelper, credential.Request{Key: key, URL: url}, nil
+	default:
+		return "", "", credential.Request{}, fmt.Errorf("%s is not a secret config key", key)
+	}
+}
+
+// secretNotFoundError matches credential.ErrNotFound with errors.Is
+type secretNotFoundError struct {
+	msg string
+}
+
+func (e *secretNotFoundError) Error() string {
+	return e.msg
+}
+
+func (e *secretNotFoundError) Unwrap() error {
+	return credential.ErrNotFound
+}
+
+// secretConfigured reports whether a secret key has a value anywhere
+// Secret looks, without asking its credential helper
+func secretConfigured(config *Config, key string) bool {
+	plaintext, helperName, _, err := secretRequest(config, key)
+	if err != nil {
+		return false
+	}
+	return plaintext != "" || helperName != "" || os.Getenv(EnvVar(key)) != ""
+}
+
+// Secret resolves a secret key. The environment wins, then the configured
+// credential helper, then a plaintext value from a config file.
+func Secret(config *Config, key string) (string, error) {
+	plaintext, helperName, req, err := secretRequest(config, key)
+	if err != nil {
+		return "", err
+	}
+	if env := os.Getenv(EnvVar(key)); env != "" {
+		return env, nil
+	}
+	if helperName == "" {
+		return plaintext, nil
+	}
+
+	helper, err := credential.New(helperName)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := helper.Get(req)
+	if errors.Is(err, credential.ErrNotFound) {
+		return "", &secretNotFoundError{fmt.Sprintf("%s not found in credential helper '%s'. Run: gitr config set %s <value>", req, helperName, key)}
+	}
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// SetSecret stores a secret key. If a credential helper is configured the
+// value goes to the helper and any plaintext copy in the scope's config
+// file is removed; otherwise it is written to the file like any other key.
+// It reports whether the helper was used.
+func SetSecret(scope Scope, key, value string) (bool, error) {
+	config, err := Load()
+	if err != nil {
+		return false, err
+	}
+
+	_, helperName, req, err := secretRequest(config, key)
+	if err != nil {
+		return false, err
+	}
+	if helperName == "" {
+		return false, SetIn(scope, key, value)
+	}
+
+	helper, err := credential.New(helperName)
+	if err != nil {
+		return false, err
+	}
+	if err := helper.Store(req, value); err != nil {
+		return false, err
+	}
+
+	var notSet *NotSetError
+	if err := Unset(scope, key); err != nil && !errors.As(err, &notSet) {
+		return true, err
+	}
+	return true, nil
+}
+
+// UnsetSecret removes a secret key from a scope's config file and, if a
+// credential helper is configured, erases it there too. It reports whether
+// anything was removed.
+func UnsetSecret(scope Scope, key string) (bool, error) {
+	config, err := Load()
+	if err != nil {
+		return false, err
+	}
+
+	_, helperName, req, err := secretRequest(config, key)
+	if err != nil {
+		return false, err
+	}
+
+	removed := true
+	var notSet *NotSetError
+	if err := Unset(scope, key); errors.As(err, &notSet) {
+		removed = false
+	} else if err != nil {
+		return false, err
+	}
+
+	if helperName != "" {
+		helper, err := credential.New(helperName)
+		if err != nil {
+			return removed, err
+		}
+		if _, err := helper.Get(req); err == nil {
+			if err := helper.Erase(req); err != nil {
+				return removed, err
+			}
+			removed = true
+		}
+	}
+	return removed, nil
+}
+
+// MoveSecretToHelper moves a plaintext secret from a scope's config file
+// into the now configured credential helper. It reports whether anything
+// was moved.
+func MoveSecretToHelper(scope Scope, key string) (bool, error) {
+	entries, err := ListIn(scope)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.Key != key {
+			continue
+		}
+		value, ok := entry.Value.(string)
+		if !ok || value == "" {
+			return false, nil
+		}
+		return SetSecret(scope, key, value)
+	}
+	return false, nil
+}
+
+// DisplayValue formats a value for output, masking secrets
+func DisplayValue(key string, value any) string {
+	if s, ok := value.(string); ok && IsSecret(key) {
+		return credential.Mask(s)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,149 @@
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get when a helper has no value for a credential
+var ErrNotFound = errors.New("credential not found")
+
+// Request identifies a credential: the config key it stands in for and
+// the URL it is used with, since the same key (e.g. api.key) can hold
+// different secrets for different endpoints
+type Request struct {
+	Key string
+	URL string
+}
+
+func (r Request) String() string {
+	if r.URL == "" {
+		return r.Key
+	}
+	return r.Key + " for " + r.URL
+}
+
+// Helper stores and retrieves secrets outside of config files
+type Helper interface {
+	Get(req Request) (string, error)
+	Store(req Request, value string) error
+	Erase(req Request) error
+}
+
+// BuiltinFile is the name of the built-in encrypted file helper
+const BuiltinFile = "file"
+
+// New returns the helper for a name from config: "file" for the built-in
+// encrypted store, an absolute path, or a name <n> that runs the
+// gitr-credential-<n> program found on PATH
+func New(name string) (Helper, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("no credential helper configured")
+	case name == BuiltinFile:
+		return NewFileStore()
+	case filepath.IsAbs(name):
+		return &External{Program: name}, nil
+	default:
+		program, err := exec.LookPath("gitr-credential-" + name)
+		if err != nil {
+			return nil, fmt.Errorf("credential helper '%s' not found: %w", name, err)
+		}
+		return &External{Program: program}, nil
+	}
+}
+
+// External runs a helper program. The protocol mirrors git's: the program
+// is invoked with one of get, store or erase and receives key=value lines
+// on stdin, terminated by a blank line:
+//
+//	key=api.key
+//	url=https://api.deepseek.com/v1/chat/completions
+//	value=sk-...          (store only)
+//
+// For get it prints value=<secret> on stdout, or nothing if it has no
+// value. A non-zero exit status is an error.
+type External struct {
+	Program string
+}
+
+func (e *External) Get(req Request) (string, error) {
+	out, err := e.run("get", req, "")
+	if err != nil {
+		return "", err
+	}
+
+	attrs := parseAttributes(out)
+	value, ok := attrs["value"]
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (e *External) Store(req Request, value string) error {
+	_, err := e.run("store", req, value)
+	return err
+}
+
+func (e *External) Erase(req Request) error {
+	_, err := e.run("erase", req, "")
+	return err
+}
+
+func (e *External) run(operation string, req Request, value string) ([]byte, error) {
+	if strings.ContainsAny(req.Key+req.URL+value, "\n\x00") {
+		return nil, fmt.Errorf("credential values cannot contain newlines")
+	}
+
+	var input bytes.Buffer
+	fmt.Fprintf(&input, "key=%s\n", req.Key)
+	if req.URL != "" {
+		fmt.Fprintf(&input, "url=%s\n", req.URL)
+	}
+	if operation == "store" {
+		fmt.Fprintf(&input, "value=%s\n", value)
+	}
+	input.WriteString("\n")
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(e.Program, operation)
+	cmd.Stdin = &input
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %s %s failed: %w", filepath.Base(e.Program), operation, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func parseAttributes(data []byte) map[string]string {
+	attrs := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// Mask hides a secret for display. Only long secrets keep their last four
+// characters, so a short one can't be guessed from what is shown.
+func Mask(secret string) string {
+	if len(secret) < 16 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
@@ -0,0 +1,22 @@
+package credential
+
+import "testing"
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"", ""},
+		{"short", "*****"},
+		{"sk-123456", "*********"},
+		{"sk-1234567890", "*************"},
+		{"sk-123456789012", "***************"},
+		{"sk-1234567890abcd", "*************abcd"},
+	}
+	for _, tt := range tests {
+		if got := Mask(tt.secret); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.secret, got, tt.want)
+		}
+	}
+}
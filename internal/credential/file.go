@@ -0,0 +1,293 @@
+package credential
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// kdfIterations is the PBKDF2-HMAC-SHA256 work factor for the store key
+	kdfIterations = 210000
+	saltSize      = 16
+	keySize       = 32
+)
+
+// FileStore is the built-in helper: an AES-256-GCM encrypted file whose key
+// is derived from a passphrase. The passphrase is read from
+// GITR_CREDENTIAL_PASSPHRASE or asked for on the terminal.
+type FileStore struct {
+	Path string
+
+	passphrase []byte
+}
+
+// storeFile is the on-disk format of the encrypted store
+type storeFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// NewFileStore opens the store at GITR_CREDENTIAL_FILE, or
+// credentials.enc next to the global config
+func NewFileStore() (*FileStore, error) {
+	path := os.Getenv("GITR_CREDENTIAL_FILE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate user config directory: %w", err)
+		}
+		path = filepath.Join(dir, "gitr", "credentials.enc")
+	}
+	return &FileStore{Path: path}, nil
+}
+
+func (f *FileStore) Get(req Request) (string, error) {
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secrets[storeKey(req)]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *FileStore) Store(req Request, value string) error {
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	secrets[storeKey(req)] = value
+	return f.save(secrets)
+}
+
+func (f *FileStore) Erase(req Request) error {
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[storeKey(req)]; !ok {
+		return nil
+	}
+	delete(secrets, storeKey(req))
+	return f.save(secrets)
+}
+
+func storeKey(req Request) string {
+	return req.Key + " " + req.URL
+}
+
+func (f *FileStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to read credential store: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store: %w", err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported credential store version %d", file.Version)
+	}
+
+	passphrase, err := f.getPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential store: wrong passphrase?")
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store: %w", err)
+	}
+	return secrets, nil
+}
+
+func (f *FileStore) save(secrets map[string]string) error {
+	_, statErr := os.Stat(f.Path)
+	passphrase, err := f.getPassphrase(os.IsNotExist(statErr))
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to serialize credentials: %w", err)
+	}
+
+	// A fresh salt and nonce on every write
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt, kdfIterations)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data, err := json.MarshalIndent(storeFile{
+		Version:    1,
+		Iterations: kdfIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Data:       gcm.Seal(nil, nonce, plaintext, nil),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize credential store: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create credential store directory: %w", err)
+	}
+
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	if err := os.Rename(tmp, f.Path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	return nil
+}
+
+func (f *FileStore) getPassphrase(create bool) ([]byte, error) {
+	if f.passphrase != nil {
+		return f.passphrase, nil
+	}
+
+	if env := os.Getenv("GITR_CREDENTIAL_PASSPHRASE"); env != "" {
+		f.passphrase = []byte(env)
+		return f.passphrase, nil
+	}
+
+	passphrase, err := Prompt("Credential store passphrase: ")
+	if errors.Is(err, ErrNoTerminal) {
+		return nil, fmt.Errorf("%w to ask for the credential store passphrase; set GITR_CREDENTIAL_PASSPHRASE", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		confirm, err := Prompt("Confirm passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if confirm != passphrase {
+			return nil, fmt.Errorf("passphrases do not match")
+		}
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	f.passphrase = []byte(passphrase)
+	return f.passphrase, nil
+}
+
+// ErrNoTerminal is returned by Prompt when there is no terminal to ask on
+var ErrNoTerminal = errors.New("no terminal")
+
+// Prompt asks for a secret on the terminal without echoing it
+func Prompt(message string) (string, error) {
+	in, out, err := openTerminal()
+	if err != nil {
+		return "", ErrNoTerminal
+	}
+	defer in.Close()
+	if out != in {
+		defer out.Close()
+	}
+
+	fmt.Fprint(out, message)
+	restore, err := disableEcho(in)
+	if err != nil {
+		return "", fmt.Errorf("failed to turn off terminal echo: %w", err)
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	restore()
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from the terminal: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func newGCM(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 {
+		return nil, fmt.Errorf("invalid credential store iteration count")
+	}
+
+	block, err := aes.NewCipher(pbkdf2SHA256(passphrase, salt, iterations, keySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a key as in RFC 8018, section 5.2
+func pbkdf2SHA256(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (length + hashLen - 1) / hashLen
+
+	key := make([]byte, 0, blocks*hashLen)
+	var counter [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:length]
+}
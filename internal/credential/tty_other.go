@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package credential
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("reading a passphrase from the terminal is not supported on this platform")
+
+func openTerminal() (*os.File, *os.File, error) {
+	return nil, nil, errNoTerminal
+}
+
+func disableEcho(*os.File) (func(), error) {
+	return nil, errNoTerminal
+}
@@ -0,0 +1,46 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package credential
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openTerminal opens the controlling terminal, returning it for both
+// reading and writing
+func openTerminal() (*os.File, *os.File, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tty, tty, nil
+}
+
+// disableEcho stops the terminal from showing what is typed and returns
+// a function that restores it
+func disableEcho(tty *os.File) (func(), error) {
+	fd := tty.Fd()
+	var termios syscall.Termios
+	if err := ioctlTermios(fd, ioctlGetTermios, &termios); err != nil {
+		return nil, err
+	}
+
+	old := termios
+	termios.Lflag &^= syscall.ECHO
+	termios.Lflag |= syscall.ICANON | syscall.ISIG
+	termios.Iflag |= syscall.ICRNL
+	if err := ioctlTermios(fd, ioctlSetTermios, &termios); err != nil {
+		return nil, err
+	}
+	return func() { ioctlTermios(fd, ioctlSetTermios, &old) }, nil
+}
+
+func ioctlTermios(fd, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
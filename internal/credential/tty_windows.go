@@ -0,0 +1,47 @@
+//go:build windows
+
+package credential
+
+import (
+	"os"
+	"syscall"
+)
+
+const enableEchoInput = 0x0004
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// openTerminal opens the console's input and output
+func openTerminal() (*os.File, *os.File, error) {
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+// disableEcho stops the console from showing what is typed and returns a
+// function that restores it
+func disableEcho(in *os.File) (func(), error) {
+	handle := syscall.Handle(in.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(handle, mode&^enableEchoInput); err != nil {
+		return nil, err
+	}
+	return func() { setConsoleMode(handle, mode) }, nil
+}
+
+func setConsoleMode(handle syscall.Handle, mode uint32) error {
+	if ok, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode)); ok == 0 {
+		return err
+	}
+	return nil
+}
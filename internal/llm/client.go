@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/mysticshirou/gitroulette/internal/config"
 	"github.com/mysticshirou/gitroulette/internal/repo"
+	"github.com/mysticshirou/gitroulette/internal/trace"
 )
 
 type Message struct {
@@ -17,8 +20,10 @@ type Message struct {
 }
 
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
 }
 
 type ChatResponse struct {
@@ -32,80 +37,36 @@ type ChatResponse struct {
 	} `json:"error,omitempty"`
 }
 
-// SendCommand sends a git command with repo context to the LLM
-func SendCommand(command string, args []string) (string, error) {
-	// Load config
-	cfg, err := config.Load()
-	if err != nil {
-		return "", err
-	}
-
-	if err := config.Validate(); err != nil {
-		return "", err
-	}
-
-	// Get current branch
-	currentBranch, err := repo.GetCurrentBranch()
-	if err != nil {
-		return "", fmt.Errorf("failed to get current branch: %w", err)
-	}
-
-	// Get all files in repo
-	files, err := repo.GetAllFiles()
+// BuildMessages renders the prompt for a command and assembles the full
+// message list: system prompt, pinned facts, conversation history and the
+// command itself
+func BuildMessages(historyLog repo.HistoryLog, command string, args []string) (*Prompt, []Message, error) {
+	prompt, err := RenderPrompt(command, args)
 	if err != nil {
-		return "", fmt.Errorf("failed to read repository files: %w", err)
-	}
-
-	// Build file context
-	filesContext := "Repository files:\n"
-	for path, content := range files {
-		filesContext += fmt.Sprintf("\n--- %s ---\n%s\n", path, content)
+		return nil, nil, err
 	}
 
-	// Build user message
-	fullCommand := command
-	if len(args) > 0 {
-		for _, arg := range args {
-			fullCommand += " " + arg
-		}
-	}
-
-	userMessage := fmt.Sprintf("Current branch: %s\nCommand: %s\n\n%s", currentBranch, fullCommand, filesContext)
-
-	// Load history and build messages array
-	history, err := repo.LoadHistory()
+	history, err := historyLog.Load()
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	messages := []Message{
 		{
-			Role: "system",
-			Content: `You are a humorous implementation of git that runs entirely through an LLM.
-When users run git commands, you should:
-1. Try your best to simulate what real git would output
-2. Maintain consistency with the conversation history (previous commits, file states, branches, etc.)
-3. Be creative but stay in character as a git-like tool
-4. Format your output exactly as git would (use proper formatting, colors are not needed)
-5. Remember all previous commits and changes from the chat history
-6. When showing diffs, try to accurately show what changed based on the file contents
-7. For 'git status', show what files have changed since the last commit on the current branch
-8. For 'git log', show the commit history from previous commits in this conversation for the current branch
-9. For 'git commit', create a commit with the provided message and remember it on the current branch
-10. For 'git branch', list all branches you've seen created (mark current branch with *)
-11. For 'git checkout', switch to the specified branch and update working tree if needed
-12. For 'git merge', merge the specified branch into the current branch (be creative with conflicts!)
-
-Branch handling:
-- Track which commits belong to which branches from the conversation history
-- When switching branches, the file contents should reflect that branch's state
-- When merging, simulate merge conflicts if files changed on both branches
-- Remember branch creation from 'git branch <name>' or 'git checkout -b <name>' commands
-
-You don't have real version control - you're inferring everything from chat history and current file state. Do your best!`,
+			Role:    "system",
+			Content: prompt.System,
 		},
 	}
 
+	// Pinned facts go right after the system prompt so they are never
+	// lost when the history is truncated
+	if prompt.Pins != "" {
+		messages = append(messages, Message{
+			Role:    "system",
+			Content: prompt.Pins,
+		})
+	}
+
 	// Add conversation history
 	for _, msg := range history.Messages {
 		messages = append(messages, Message{
@@ -117,13 +78,46 @@ You don't have real version control - you're inferring everything from chat hist
 	// Add current command
 	messages = append(messages, Message{
 		Role:    "user",
-		Content: userMessage,
+		Content: prompt.User,
 	})
 
+	return prompt, messages, nil
+}
+
+// SendCommand sends a git command with repo context to the LLM
+func SendCommand(command string, args []string) (string, error) {
+	// Load config
+	if err := config.Validate(); err != nil {
+		return "", err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return "", err
+	}
+
+	profile, err := config.ResolveProfile(cfg, command)
+	if err != nil {
+		return "", err
+	}
+
+	// Load history and build messages array
+	historyLog, err := config.HistoryFor(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	prompt, messages, err := BuildMessages(historyLog, command, args)
+	if err != nil {
+		return "", err
+	}
+	userMessage := prompt.User
+
 	// Make API request
 	requestBody := ChatRequest{
-		Model:    "deepseek-chat", // Default for DeepSeek, can be made configurable
-		Messages: messages,
+		Model:       profile.Model,
+		Messages:    messages,
+		Temperature: profile.Temperature,
+		MaxTokens:   profile.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
@@ -131,19 +125,22 @@ You don't have real version control - you're inferring everything from chat hist
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", cfg.API.URL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", profile.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+cfg.API.Key)
+	req.Header.Set("Authorization", "Bearer "+profile.Key)
 
-	client := &http.Client{}
+	trace.Printf("sending %s with profile %s (model %s, %d messages) to %s", command, profile.Name, profile.Model, len(messages), profile.URL)
+	start := time.Now()
+	client := &http.Client{Timeout: config.Duration(cfg.API.Timeout)}
 	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %w", err)
 	}
+	trace.Printf("LLM answered %s in %s", resp.Status, time.Since(start).Round(time.Millisecond))
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
@@ -151,8 +148,10 @@ You don't have real version control - you're inferring everything from chat hist
 		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
+	// Error bodies come from the provider (or whatever answers at its URL)
+	// and end up on the terminal, so they are cleaned like a response
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("API error (status %d, profile %s): %s", resp.StatusCode, profile.Name, Sanitize(string(body)))
 	}
 
 	var chatResp ChatResponse
@@ -161,22 +160,28 @@ You don't have real version control - you're inferring everything from chat hist
 	}
 
 	if chatResp.Error != nil {
-		return "", fmt.Errorf("API error: %s", chatResp.Error.Message)
+		return "", fmt.Errorf("API error: %s", Sanitize(chatResp.Error.Message))
 	}
 
 	if len(chatResp.Choices) == 0 {
 		return "", fmt.Errorf("no response from API")
 	}
 
-	response := chatResp.Choices[0].Message.Content
-
-	// Save to history
-	if err := repo.AppendMessage("user", userMessage); err != nil {
-		return "", fmt.Errorf("failed to save user message: %w", err)
+	// Clean the response before it is printed or kept in the history
+	raw := chatResp.Choices[0].Message.Content
+	response := Sanitize(raw)
+	if response != strings.TrimRight(raw, "\n") {
+		trace.Printf("sanitized the LLM response for the terminal")
 	}
 
-	if err := repo.AppendMessage("assistant", response); err != nil {
-		return "", fmt.Errorf("failed to save assistant message: %w", err)
+	// Save to history
+	now := time.Now()
+	err = historyLog.Append(
+		repo.Message{Role: "user", Content: userMessage, Timestamp: now, Profile: profile.Name},
+		repo.Message{Role: "assistant", Content: response, Timestamp: now, Profile: profile.Name, Model: profile.Model},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to save conversation: %w", err)
 	}
 
 	return response, nil
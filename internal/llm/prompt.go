@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// PromptsDir holds user overrides of the embedded prompt templates
+const PromptsDir = "prompts"
+
+//go:embed prompts/*.tmpl
+var defaultPrompts embed.FS
+
+// PromptData is what prompt templates can refer to
+type PromptData struct {
+	Name    string            // command name without "git", e.g. "commit"
+	Command string            // full command line, e.g. "git commit -m msg"
+	Args    []string          // command arguments
+	Branch  string            // current branch
+	Files   map[string]string // repository files by path
+	Staged  []string          // paths staged by 'gitr add'
+	Pins    []string          // pinned facts
+}
+
+// Prompt is a rendered prompt, before history is added
+type Prompt struct {
+	System string
+	Pins   string // empty when nothing is pinned
+	User   string
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// newPromptData gathers the repository state templates can use
+func newPromptData(command string, args []string) (*PromptData, error) {
+	currentBranch, err := repo.GetCurrentBranch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	files, err := repo.GetAllFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository files: %w", err)
+	}
+
+	index, err := repo.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	pins, err := repo.LoadPins()
+	if err != nil {
+		return nil, err
+	}
+
+	fullCommand := command
+	for _, arg := range args {
+		fullCommand += " " + arg
+	}
+
+	return &PromptData{
+		Name:    strings.TrimPrefix(command, "git "),
+		Command: fullCommand,
+		Args:    args,
+		Branch:  currentBranch,
+		Files:   files,
+		Staged:  index.StagedPaths(),
+		Pins:    pins.Facts,
+	}, nil
+}
+
+// RenderPrompt renders the system, pinned-facts and user templates for a
+// command. Templates are looked up as .gitr/prompts/<command>.<kind>.tmpl,
+// then .gitr/prompts/<kind>.tmpl, then the embedded defaults.
+func RenderPrompt(command string, args []string) (*Prompt, error) {
+	data, err := newPromptData(command, args)
+	if err != nil {
+		return nil, err
+	}
+
+	system, err := renderTemplate(data.Name, "system", data)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := renderTemplate(data.Name, "user", data)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := &Prompt{
+		System: strings.TrimSpace(system),
+		User:   user,
+	}
+
+	if len(data.Pins) > 0 {
+		pins, err := renderTemplate(data.Name, "pins", data)
+		if err != nil {
+			return nil, err
+		}
+		prompt.Pins = strings.TrimSpace(pins)
+	}
+
+	return prompt, nil
+}
+
+func renderTemplate(name, kind string, data *PromptData) (string, error) {
+	file, text, err := loadTemplate(name, kind)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(file).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse prompt template %s: %w", file, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %s: %w", file, err)
+	}
+	return buf.String(), nil
+}
+
+// loadTemplate returns the most specific template for a command and its source
+func loadTemplate(name, kind string) (string, string, error) {
+	candidates := []string{kind + ".tmpl"}
+	if name != "" && !strings.ContainsAny(name, `/\ `) {
+		candidates = []string{name + "." + kind + ".tmpl", kind + ".tmpl"}
+	}
+
+	root, err := repo.GetGitrRoot()
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(root, repo.GitrDir, PromptsDir, candidate)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return filepath.Join(repo.GitrDir, PromptsDir, candidate), string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("failed to read prompt template: %w", err)
+		}
+	}
+
+	for _, candidate := range candidates {
+		data, err := defaultPrompts.ReadFile("prompts/" + candidate)
+		if err == nil {
+			return "embedded " + candidate, string(data), nil
+		}
+	}
+
+	return "", "", fmt.Errorf("no %s prompt template found", kind)
+}
@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const esc = 0x1b
+
+// Sanitize makes a response safe to print to a terminal. The model only
+// ever needs plain text, so it removes every escape sequence (colors,
+// cursor movement, OSC 8 hyperlinks, window title and clipboard writes),
+// other control characters except tab and newline, and bidirectional
+// overrides that could make text display differently than it reads. It
+// also normalizes line endings to "\n" and unwraps a response the model
+// wrapped in a markdown code fence.
+func Sanitize(text string) string {
+	text = strings.ToValidUTF8(text, "�")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = stripControls(text)
+	text = unfence(text)
+	return strings.TrimRight(text, "\n")
+}
+
+// stripControls removes escape sequences and control characters
+func stripControls(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == esc:
+			i += escapeLength(text[i:])
+			continue
+		case r >= 0x80 && r <= 0x9f:
+			// C1 controls are the 8-bit forms of ESC sequences
+			i += c1Length(text[i:], r)
+			continue
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			// Other C0 controls: bell, backspace, form feed, ...
+		case isBidiControl(r):
+		default:
+			b.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// escapeLength returns the length of the escape sequence at the start of s
+func escapeLength(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		// CSI: parameters and intermediates, then a final byte
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s)
+	case ']', 'P', 'X', '^', '_':
+		// OSC, DCS, SOS, PM and APC run to a string terminator
+		return 2 + stringLength(s[2:])
+	}
+	// Two-character sequences such as ESC c (reset), plus any
+	// intermediate bytes before the final one
+	i := 1
+	for i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f {
+		i++
+	}
+	if i < len(s) {
+		i++
+	}
+	return i
+}
+
+// c1Length returns the length of the sequence introduced by a C1 control
+func c1Length(s string, r rune) int {
+	size := utf8.RuneLen(r)
+	switch r {
+	case 0x9b: // CSI
+		for i := size; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s)
+	case 0x9d, 0x90, 0x98, 0x9e, 0x9f: // OSC, DCS, SOS, PM, APC
+		return size + stringLength(s[size:])
+	}
+	return size
+}
+
+// stringLength returns how far a control string runs, including its
+// terminator: BEL, ESC \ or the C1 string terminator
+func stringLength(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == 0x07:
+			return i + 1
+		case s[i] == esc && i+1 < len(s) && s[i+1] == '\\':
+			return i + 2
+		case strings.HasPrefix(s[i:], "\u009c"):
+			return i + len("\u009c")
+		}
+	}
+	return len(s)
+}
+
+// isBidiControl reports the characters that reorder text on display
+func isBidiControl(r rune) bool {
+	return (r >= 0x202a && r <= 0x202e) || (r >= 0x2066 && r <= 0x2069) || r == 0x200e || r == 0x200f || r == 0x061c
+}
+
+// unfence removes a markdown code fence wrapped around the whole text
+func unfence(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+		return text
+	}
+	fence := trimmed[:3]
+
+	lines := strings.Split(trimmed, "\n")
+	if len(lines) < 2 || strings.TrimSpace(lines[len(lines)-1]) != fence {
+		return text
+	}
+	// The opening line may name a language but nothing else
+	if info := strings.TrimSpace(strings.TrimPrefix(lines[0], fence)); strings.ContainsAny(info, " `~") {
+		return text
+	}
+	body := lines[1 : len(lines)-1]
+	for _, line := range body {
+		if strings.HasPrefix(strings.TrimSpace(line), fence) {
+			// More than one block: the fences are part of the answer
+			return text
+		}
+	}
+	return strings.Join(body, "\n")
+}
@@ -0,0 +1,59 @@
+package llm
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "On branch main\nnothing to commit", "On branch main\nnothing to commit"},
+		{"tabs kept", "\tmodified: a.txt", "\tmodified: a.txt"},
+
+		{"CSI color", "\x1b[31mred\x1b[0m text", "red text"},
+		{"CSI cursor movement", "a\x1b[2J\x1b[1;1Hb", "ab"},
+		{"CSI private mode", "\x1b[?25lhidden cursor", "hidden cursor"},
+		{"8-bit CSI", "\u009b31mred", "red"},
+
+		{"OSC 8 hyperlink with BEL", "\x1b]8;;https://evil.example\x07click\x1b]8;;\x07", "click"},
+		{"OSC 8 hyperlink with ST", "\x1b]8;;https://evil.example\x1b\\click\x1b]8;;\x1b\\", "click"},
+		{"OSC 52 clipboard write", "before\x1b]52;c;cm0gLXJmIH4K\x07after", "beforeafter"},
+		{"OSC window title", "\x1b]0;pwned\x07ok", "ok"},
+		{"8-bit OSC", "\u009d52;c;cm0=\u009cok", "ok"},
+		{"unterminated OSC", "ok\x1b]52;c;cm0gLXJm", "ok"},
+
+		{"DCS", "a\x1bP1$r0m\x1b\\b", "ab"},
+		{"8-bit DCS", "a\u00901$r\u009cb", "ab"},
+		{"APC", "a\x1b_payload\x1b\\b", "ab"},
+		{"two-character escape", "a\x1bcb", "ab"},
+		{"escape with intermediate", "a\x1b(Bb", "ab"},
+		{"trailing escape", "ok\x1b", "ok"},
+
+		{"C1 controls", "a\u0085b\u008ec\u009ad", "abcd"},
+		{"C0 controls", "be\x07ll\bs\x0cand\x00nul\x7f", "bellsandnul"},
+
+		{"bidi overrides", "user\u202e\u2066txt.exe\u2069\u202c", "usertxt.exe"},
+		{"bidi marks", "a\u200eb\u200fc\u061cd", "abcd"},
+
+		{"CRLF", "one\r\ntwo\r\n", "one\ntwo"},
+		{"lone CR", "progress 10%\rprogress 100%", "progress 10%\nprogress 100%"},
+		{"invalid UTF-8", "a\xffb", "a�b"},
+
+		{"code fence", "```\nOn branch main\n```", "On branch main"},
+		{"code fence with language", "```text\nOn branch main\n```\n", "On branch main"},
+		{"tilde fence", "~~~\nclean\n~~~", "clean"},
+		{"fence around CRLF", "```\r\none\r\ntwo\r\n```", "one\ntwo"},
+		{"two code blocks kept", "```\na\n```\n```\nb\n```", "```\na\n```\n```\nb\n```"},
+		{"fence inside text kept", "Output:\n```\na\n```", "Output:\n```\na\n```"},
+		{"unclosed fence kept", "```\na", "```\na"},
+		{"fence info with spaces kept", "```not a language\na\n```", "```not a language\na\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
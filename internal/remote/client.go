@@ -1,61 +1,184 @@
 package remote
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mysticshirou/gitroulette/internal/config"
+	"github.com/mysticshirou/gitroulette/internal/credential"
+	"github.com/mysticshirou/gitroulette/internal/trace"
 )
 
 // Client handles communication with the gitroulette remote API
 type Client struct {
 	baseURL string
 	repoID  string
+	token   string
 	client  *http.Client
+
+	// gzipRequests is set once the server accepts gzipped request bodies
+	gzipRequests bool
+	stats        Stats
 }
 
-// NewClient creates a new remote API client
-func NewClient() (*Client, error) {
+// NewClient creates a client for a configured remote, e.g.
+// config.DefaultRemote
+func NewClient(name string) (*Client, error) {
 	cfg, err := config.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	if cfg.Remote.URL == "" {
+	r, ok := config.LookupRemote(cfg, name)
+	if !ok && name == config.DefaultRemote {
 		return nil, fmt.Errorf("remote.url is not configured. Run: gitr config set remote.url <url>")
 	}
+	if !ok {
+		if err := config.ValidateRemoteName(name); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("remote '%s' is not configured. Run: gitr remote add %s <url>/<repo-id>", name, name)
+	}
+
+	if r.URL == "" {
+		key := config.RemoteKey(cfg, name, "url")
+		return nil, fmt.Errorf("%s is not configured. Run: gitr config set %s <url>", key, key)
+	}
+
+	if r.RepoID == "" {
+		key := config.RemoteKey(cfg, name, "repo_id")
+		return nil, fmt.Errorf("%s is not configured. Run: gitr config set %s <id>", key, key)
+	}
 
-	if cfg.Remote.RepoID == "" {
-		return nil, fmt.Errorf("remote.repo_id is not configured. Run: gitr config set remote.repo_id <id>")
+	// Without a token the server decides whether to let the request in
+	token, err := config.RemoteToken(cfg, r)
+	if err != nil && !errors.Is(err, credential.ErrNotFound) {
+		return nil, err
 	}
 
 	return &Client{
-		baseURL: cfg.Remote.URL,
-		repoID:  cfg.Remote.RepoID,
-		client:  &http.Client{},
+		baseURL: r.URL,
+		repoID:  r.RepoID,
+		token:   token,
+		client:  &http.Client{Timeout: config.Duration(cfg.Remote.Timeout)},
 	}, nil
 }
 
-// NewClientWithURL creates a client with just a base URL (for creating repos)
-func NewClientWithURL(baseURL string) *Client {
+// do sends a request with the client's token, tracing it with --verbose.
+// It asks for a gzipped response, which readBody decompresses.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept-Encoding", "gzip")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		trace.Printf("%s %s failed after %s: %v", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	trace.Printf("%s %s: %s in %s", req.Method, req.URL, resp.Status, time.Since(start).Round(time.Millisecond))
+	return resp, nil
+}
+
+// NewClientWithURL creates a client with just a base URL and token (for
+// creating repos and logging in)
+func NewClientWithURL(baseURL, token string) *Client {
+	return NewClientForRepo(baseURL, "", token)
+}
+
+// NewClientForRepo creates a client for a repository without reading the
+// config, e.g. to talk to a server in tests
+func NewClientForRepo(baseURL, repoID, token string) *Client {
 	return &Client{
 		baseURL: baseURL,
-		repoID:  "",
+		repoID:  repoID,
+		token:   token,
 		client:  &http.Client{},
 	}
 }
 
+// statusError describes a failed request, with a hint when the server
+// rejected or lacked credentials
+func statusError(operation string, status int, body []byte) error {
+	err := fmt.Errorf("%s failed (status %d): %s", operation, status, strings.TrimSpace(string(body)))
+	switch status {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%w\nHint: log in with a token for this server: gitr remote login", err)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w\nHint: ask the server's admin for a token with access to this repository", err)
+	}
+	return err
+}
+
 // PushData represents data sent during a push operation
 type PushData struct {
-	Branch   string            `json:"branch"`
-	Commits  []Commit          `json:"commits"`
-	Files    map[string]string `json:"files"`
-	History  []Message         `json:"history"`
+	Branch  string            `json:"branch"`
+	Commits []Commit          `json:"commits"`
+	Files   map[string]string `json:"files"`
+	History []Message         `json:"history"`
+
+	// Histories holds one conversation log per branch when the repository
+	// keeps per-branch histories
+	Histories map[string][]Message `json:"histories,omitempty"`
+
+	// PerBranch marks a push from a repository keeping per-branch
+	// histories. History is then empty and the remote's shared history
+	// is left alone; the branch's log travels in Histories[Branch], and
+	// the lease covers only that log.
+	PerBranch bool `json:"per_branch,omitempty"`
+
+	// An incremental push (see Negotiate) sends Tree, mapping each path
+	// to a content hash, instead of Files, plus the Blobs the server is
+	// missing. History and Histories then hold only the messages after
+	// HistoryBase and HistoriesBase, which the server must still be at.
+	Tree          map[string]string   `json:"tree,omitempty"`
+	Blobs         map[string]string   `json:"blobs,omitempty"`
+	HistoryBase   *Position           `json:"history_base,omitempty"`
+	HistoriesBase map[string]Position `json:"histories_base,omitempty"`
+
+	// Lease is the remote state the push was built on; the server rejects
+	// the push if the branch or history has moved since. Unless
+	// AllowRewrite is set it also rejects pushes whose history doesn't
+	// continue the server's. Pushes without a lease overwrite anything.
+	Lease        *Lease `json:"lease,omitempty"`
+	AllowRewrite bool   `json:"allow_rewrite,omitempty"`
 }
 
+// Lease describes a remote branch as a client last saw it
+type Lease struct {
+	// Head is the hash of the branch's newest commit, empty if it has none
+	Head    string   `json:"head"`
+	History Position `json:"history"`
+
+	// Histories is where each per-branch log ended, if the client
+	// recorded it; a log missing from it was never seen
+	Histories map[string]Position `json:"histories,omitempty"`
+}
+
+// ErrNonFastForward is returned by Push when the server rejected the push
+// because it would discard changes on the remote
+var ErrNonFastForward = errors.New("non-fast-forward")
+
+// pushRejection is the body of a rejected push
+type pushRejection struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// NonFastForwardCode marks a push rejection caused by a stale lease
+const NonFastForwardCode = "non_fast_forward"
+
+// ErrHistoryMoved is returned by Push when the server's history no longer
+// matches the base an incremental push was built on
+var ErrHistoryMoved = errors.New("the remote history changed during the push")
+
 // Commit represents a commit to be pushed
 type Commit struct {
 	Hash      string `json:"hash"`
@@ -69,71 +192,164 @@ type Message struct {
 	Role      string `json:"role"`
 	Content   string `json:"content"`
 	Timestamp string `json:"timestamp"`
+	Profile   string `json:"profile,omitempty"`
+	Model     string `json:"model,omitempty"`
 }
 
 // PullData represents data received during a pull operation
 type PullData struct {
-	Branch  string            `json:"branch"`
-	Files   map[string]string `json:"files"`
-	History []Message         `json:"history"`
+	Branch    string               `json:"branch"`
+	Files     map[string]string    `json:"files"`
+	History   []Message            `json:"history"`
+	Histories map[string][]Message `json:"histories,omitempty"`
+
+	// Head is the hash of the branch's newest commit, if the server says
+	Head string `json:"head,omitempty"`
+
+	// A pull with a depth gets only the last turns of History. Shallow
+	// then describes the messages left out and FullHistory the whole log.
+	Shallow     *Position `json:"shallow,omitempty"`
+	FullHistory *Position `json:"full_history,omitempty"`
+}
+
+// Shorten cuts History down to its last depth turns, recording what was
+// left out
+func (d *PullData) Shorten(depth int) {
+	tail := LastTurns(d.History, depth)
+	if len(tail) == len(d.History) {
+		return
+	}
+	omitted := PositionOf(d.History[:len(d.History)-len(tail)])
+	full := PositionOf(d.History)
+	d.History, d.Shallow, d.FullHistory = tail, &omitted, &full
+}
+
+// HistoryPosition returns where the remote's history ends, even if only
+// part of it was pulled
+func (d *PullData) HistoryPosition() Position {
+	if d.FullHistory != nil {
+		return *d.FullHistory
+	}
+	return PositionOf(d.History)
+}
+
+// LastTurns returns the messages of the last n turns, a turn being a user
+// message and the responses that follow it
+func LastTurns(messages []Message, n int) []Message {
+	start := len(messages)
+	for turns := 0; start > 0 && turns < n; {
+		start--
+		if messages[start].Role == "user" {
+			turns++
+		}
+	}
+	return messages[start:]
+}
+
+// PushResult is the server's answer to a push
+type PushResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+
+	// History is where the remote's history ends after the push, and
+	// Histories where each per-branch log ends, if the server says
+	History   *Position           `json:"history,omitempty"`
+	Histories map[string]Position `json:"histories,omitempty"`
+
+	// LeaseChecked is set by servers that checked the push's lease; older
+	// ones ignore it and accept the push regardless
+	LeaseChecked bool `json:"lease_checked,omitempty"`
 }
 
 // Push sends local repository state to the remote
-func (c *Client) Push(data *PushData) error {
+func (c *Client) Push(data *PushData) (*PushResult, error) {
 	url := fmt.Sprintf("%s/api/repos/%s/push", c.baseURL, c.repoID)
 
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal push data: %w", err)
+		return nil, fmt.Errorf("failed to marshal push data: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := c.newRequest("POST", url, jsonData)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusConflict {
+		var rejection pushRejection
+		if json.Unmarshal(body, &rejection) == nil && rejection.Code == NonFastForwardCode {
+			return nil, fmt.Errorf("%w: %s", ErrNonFastForward, rejection.Error)
+		}
+		if data.HistoryBase != nil {
+			return nil, ErrHistoryMoved
+		}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("push failed (status %d): %s", resp.StatusCode, string(body))
+		return nil, statusError("push", resp.StatusCode, body)
 	}
 
-	return nil
+	// Only the status matters; older servers may say nothing else
+	result := PushResult{Success: true}
+	json.Unmarshal(body, &result)
+	return &result, nil
 }
 
+// ErrBranchNotFound is returned by PullBranch when the remote doesn't have
+// the branch asked for
+var ErrBranchNotFound = errors.New("branch not found")
+
 // Pull retrieves repository state from the remote
 func (c *Client) Pull() (*PullData, error) {
+	return c.PullBranch("", 0)
+}
+
+// PullBranch retrieves a branch, or the remote's default branch if branch
+// is empty, with only the last depth turns of history if depth > 0
+func (c *Client) PullBranch(branch string, depth int) (*PullData, error) {
+	query := url.Values{}
+	if branch != "" {
+		query.Set("branch", branch)
+	}
+	if depth > 0 {
+		query.Set("depth", strconv.Itoa(depth))
+	}
 	url := fmt.Sprintf("%s/api/repos/%s/pull", c.baseURL, c.repoID)
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := c.newRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusNotFound && branch != "" && strings.Contains(string(body), "Branch not found") {
+		return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, branch)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("pull failed (status %d): %s", resp.StatusCode, string(body))
+		return nil, statusError("pull", resp.StatusCode, body)
 	}
 
 	var data PullData
@@ -141,6 +357,11 @@ func (c *Client) Pull() (*PullData, error) {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
+	// Servers that don't know about depth send everything
+	if depth > 0 && data.Shallow == nil {
+		data.Shorten(depth)
+	}
+
 	return &data, nil
 }
 
@@ -154,26 +375,24 @@ func (c *Client) CreateRepo(name string) (string, error) {
 		return "", fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := c.newRequest("POST", url, jsonData)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.readBody(resp)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("create repo failed (status %d): %s", resp.StatusCode, string(body))
+		return "", statusError("create repo", resp.StatusCode, body)
 	}
 
 	var result map[string]string
@@ -188,3 +407,75 @@ func (c *Client) CreateRepo(name string) (string, error) {
 
 	return repoID, nil
 }
+
+// CheckAccess asks the server for a repository, or the repository list if
+// repoID is empty, to find out whether it accepts the client's token
+func (c *Client) CheckAccess(repoID string) error {
+	url := fmt.Sprintf("%s/api/repos", c.baseURL)
+	if repoID != "" {
+		url += "/" + repoID
+	}
+
+	req, err := c.newRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("the server rejected the token: %s", strings.TrimSpace(string(body)))
+	}
+	return statusError("access check", resp.StatusCode, body)
+}
+
+// Branches lists the names of the repository's branches
+func (c *Client) Branches() ([]string, error) {
+	url := fmt.Sprintf("%s/api/repos/%s", c.baseURL, c.repoID)
+
+	req, err := c.newRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("list branches", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Branches []struct {
+			Name string `json:"name"`
+		} `json:"branches"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	names := make([]string, 0, len(result.Branches))
+	for _, branch := range result.Branches {
+		names = append(names, branch.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,110 @@
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the smallest request body worth compressing
+const minCompressSize = 1024
+
+// Stats counts the bytes a client has transferred, both as they went over
+// the wire and as they were before compression
+type Stats struct {
+	Sent        int64 `json:"sent"`
+	SentRaw     int64 `json:"sent_uncompressed"`
+	Received    int64 `json:"received"`
+	ReceivedRaw int64 `json:"received_uncompressed"`
+}
+
+// Stats returns the bytes transferred by the client so far
+func (c *Client) Stats() Stats {
+	return c.stats
+}
+
+// newRequest creates a request carrying body as JSON, compressed with gzip
+// once the server has said it accepts gzip-encoded requests
+func (c *Client) newRequest(method, url string, body []byte) (*http.Request, error) {
+	if body == nil {
+		return http.NewRequest(method, url, nil)
+	}
+
+	payload, encoding := body, ""
+	if c.gzipRequests && len(body) >= minCompressSize {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		payload, encoding = buf.Bytes(), "gzip"
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	c.stats.Sent += int64(len(payload))
+	c.stats.SentRaw += int64(len(body))
+	return req, nil
+}
+
+// readBody reads a response body, decompressing it if the server sent it
+// gzipped, and notes whether the server accepts gzipped requests
+func (c *Client) readBody(resp *http.Response) ([]byte, error) {
+	if AcceptsGzip(resp.Header.Get("Accept-Encoding")) {
+		c.gzipRequests = true
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.stats.Received += int64(len(raw))
+
+	body := raw
+	switch encoding := resp.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported response encoding: %s", encoding)
+	}
+	c.stats.ReceivedRaw += int64(len(body))
+	return body, nil
+}
+
+// AcceptsGzip reports whether an Accept-Encoding header value lists gzip
+func AcceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		// "gzip;q=0" means the opposite
+		q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err != nil || weight > 0
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotSupported is returned by Negotiate when the server predates
+// incremental pushes; the caller falls back to sending everything
+var ErrNotSupported = errors.New("the remote server does not support incremental push")
+
+// Position identifies the start of a message log: how many messages it
+// holds and a hash of them, so both sides can tell whether one log
+// continues the other
+type Position struct {
+	Length int    `json:"length"`
+	Hash   string `json:"hash"`
+}
+
+// NegotiateRequest describes what a push is about to send. A fetch sends
+// only Branch, to learn whether it changed.
+type NegotiateRequest struct {
+	Branch string `json:"branch"`
+
+	// Files maps each path to the hash of its content (see HashContent)
+	Files map[string]string `json:"files"`
+}
+
+// NegotiateResponse tells the client what the server already has
+type NegotiateResponse struct {
+	// Missing lists the content hashes the server has no blob for
+	Missing []string `json:"missing"`
+
+	// Head is the hash of the branch's newest commit, if the server says
+	Head string `json:"head,omitempty"`
+
+	// History is where the server's history ends, and Histories where
+	// each branch's history ends
+	History   Position            `json:"history"`
+	Histories map[string]Position `json:"histories,omitempty"`
+}
+
+// HashContent returns the hash a file's content is stored under
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashMessages returns the hash of a message log
+func HashMessages(messages []Message) string {
+	h := sha256.New()
+	for _, msg := range messages {
+		data, _ := json.Marshal(msg)
+		h.Write(data)
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PositionOf returns the position at the end of messages
+func PositionOf(messages []Message) Position {
+	return Position{Length: len(messages), Hash: HashMessages(messages)}
+}
+
+// PositionsOf returns where each of a set of logs ends, or nil if there
+// are none
+func PositionsOf(logs map[string][]Message) map[string]Position {
+	if len(logs) == 0 {
+		return nil
+	}
+	positions := map[string]Position{}
+	for name, messages := range logs {
+		positions[name] = PositionOf(messages)
+	}
+	return positions
+}
+
+// Continues reports whether messages start with the log ending at p, in
+// which case only messages[p.Length:] need to be sent
+func (p Position) Continues(messages []Message) bool {
+	return p.Length <= len(messages) && HashMessages(messages[:p.Length]) == p.Hash
+}
+
+// Negotiate asks the server which blobs and messages a push needs to send
+func (c *Client) Negotiate(request *NegotiateRequest) (*NegotiateResponse, error) {
+	url := fmt.Sprintf("%s/api/repos/%s/negotiate", c.baseURL, c.repoID)
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal negotiation: %w", err)
+	}
+
+	req, err := c.newRequest("POST", url, jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && !bytes.Contains(body, []byte("Repository not found")),
+		resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil, ErrNotSupported
+	case resp.StatusCode != http.StatusOK:
+		return nil, statusError("negotiation", resp.StatusCode, body)
+	}
+
+	var data NegotiateResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &data, nil
+}
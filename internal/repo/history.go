@@ -0,0 +1,521 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	HistoryBackupDir = "history.bak"
+
+	// maxHistoryBackups is how many backups are kept before the oldest are pruned
+	maxHistoryBackups = 20
+)
+
+// HistoryLog is one conversation log. The shared log lives in
+// .gitr/history.json; per-branch logs live in .gitr/histories/<branch>.json.
+type HistoryLog struct {
+	Branch string // empty for the shared log
+}
+
+// SharedHistory returns the log shared by all branches
+func SharedHistory() HistoryLog {
+	return HistoryLog{}
+}
+
+// BranchHistory returns the log belonging to a single branch
+func BranchHistory(branch string) HistoryLog {
+	return HistoryLog{Branch: branch}
+}
+
+// Name describes the log for messages
+func (l HistoryLog) Name() string {
+	if l.Branch == "" {
+		return "shared history"
+	}
+	return fmt.Sprintf("history of branch '%s'", l.Branch)
+}
+
+// root returns the repository root, first making sure a branch log's name
+// can't point outside .gitr/histories
+func (l HistoryLog) root() (string, error) {
+	if l.Branch != "" {
+		if err := ValidateBranchName(l.Branch); err != nil {
+			return "", err
+		}
+	}
+	return GetGitrRoot()
+}
+
+func (l HistoryLog) path(root string) string {
+	if l.Branch == "" {
+		return filepath.Join(root, GitrDir, HistoryFile)
+	}
+	return filepath.Join(root, GitrDir, HistoriesDir, filepath.FromSlash(l.Branch)+".json")
+}
+
+func (l HistoryLog) backupDir(root string) string {
+	if l.Branch == "" {
+		return filepath.Join(root, GitrDir, HistoryBackupDir)
+	}
+	return filepath.Join(root, GitrDir, HistoryBackupDir, HistoriesDir, filepath.FromSlash(l.Branch))
+}
+
+// Exists reports whether the log has been written yet
+func (l HistoryLog) Exists() bool {
+	root, err := l.root()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(l.path(root))
+	return err == nil
+}
+
+// Load reads the log. A branch log that hasn't been written yet starts
+// from the shared history, so enabling per-branch logs keeps the
+// conversation so far.
+func (l HistoryLog) Load() (*History, error) {
+	root, err := l.root()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(l.path(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if l.Branch != "" {
+				return SharedHistory().Load()
+			}
+			return &History{Messages: []Message{}}, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var history History
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+
+	return &history, nil
+}
+
+// Save writes the log
+func (l HistoryLog) Save(history *History) error {
+	root, err := l.root()
+	if err != nil {
+		return err
+	}
+
+	historyPath := l.path(root)
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize history: %w", err)
+	}
+
+	err = WithLock(func() error {
+		if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+			return err
+		}
+		return WriteFileAtomic(historyPath, data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
+
+// Append adds messages to the log in a single locked update, so a command
+// and its response are never interleaved with another process
+func (l HistoryLog) Append(messages ...Message) error {
+	return WithLock(func() error {
+		history, err := l.Load()
+		if err != nil {
+			return err
+		}
+
+		history.Messages = append(history.Messages, messages...)
+		return l.Save(history)
+	})
+}
+
+// Fork starts this log as a copy of another one. It does nothing if the
+// log already exists.
+func (l HistoryLog) Fork(from HistoryLog) error {
+	return WithLock(func() error {
+		if l.Exists() {
+			return nil
+		}
+
+		history, err := from.Load()
+		if err != nil {
+			return err
+		}
+		return l.Save(history)
+	})
+}
+
+// Remove deletes a branch log
+func (l HistoryLog) Remove() error {
+	if l.Branch == "" {
+		return fmt.Errorf("cannot remove the shared history")
+	}
+
+	root, err := l.root()
+	if err != nil {
+		return err
+	}
+
+	return WithLock(func() error {
+		if _, err := l.Backup(); err != nil {
+			return err
+		}
+		if err := os.Remove(l.path(root)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove history: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListBranchHistories returns the branches that have their own log
+func ListBranchHistories() ([]string, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, GitrDir, HistoriesDir)
+	var branches []string
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		branches = append(branches, filepath.ToSlash(strings.TrimSuffix(rel, ".json")))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branch histories: %w", err)
+	}
+
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// ValidateBranchName rejects branch names that can't be stored under .gitr
+func ValidateBranchName(name string) error {
+	if name == "" {
+		return fmt.Errorf("branch name cannot be empty")
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid branch name: %s", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." || strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid branch name: %s", name)
+		}
+	}
+	if strings.ContainsAny(name, "\\:*?\"<>| \t\n") {
+		return fmt.Errorf("invalid branch name: %s", name)
+	}
+	return nil
+}
+
+// Turn is a command sent to the LLM together with its response
+type Turn struct {
+	Number    int // 1-based
+	Index     int // index of the turn's first message in History.Messages
+	User      *Message
+	Assistant *Message
+}
+
+// Command returns the gitr command line that started the turn
+func (t Turn) Command() string {
+	if t.User == nil {
+		return ""
+	}
+	for _, line := range strings.Split(t.User.Content, "\n") {
+		if strings.HasPrefix(line, "Command: ") {
+			return strings.TrimPrefix(line, "Command: ")
+		}
+	}
+	return firstLine(t.User.Content)
+}
+
+// Turns groups the history into numbered command/response pairs
+func (h *History) Turns() []Turn {
+	var turns []Turn
+	for i := 0; i < len(h.Messages); i++ {
+		turn := Turn{Number: len(turns) + 1, Index: i}
+		if h.Messages[i].Role == "user" {
+			turn.User = &h.Messages[i]
+			if i+1 < len(h.Messages) && h.Messages[i+1].Role == "assistant" {
+				i++
+				turn.Assistant = &h.Messages[i]
+			}
+		} else {
+			// An assistant message without a preceding command; keep it as
+			// its own turn so numbering still covers every message
+			turn.Assistant = &h.Messages[i]
+		}
+		turns = append(turns, turn)
+	}
+	return turns
+}
+
+// Truncate drops every turn after the first n
+func (h *History) Truncate(n int) {
+	turns := h.Turns()
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(turns) {
+		return
+	}
+	h.Messages = h.Messages[:turns[n].Index]
+}
+
+// CommonPrefix returns how many leading messages two histories share
+func (h *History) CommonPrefix(other *History) int {
+	n := 0
+	for n < len(h.Messages) && n < len(other.Messages) && h.Messages[n].Equal(other.Messages[n]) {
+		n++
+	}
+	return n
+}
+
+// Equal reports whether two messages are the same turn of the conversation
+func (m Message) Equal(other Message) bool {
+	return m.Role == other.Role && m.Content == other.Content && m.Timestamp.Equal(other.Timestamp)
+}
+
+// Rewind backs up the log and truncates it to the first n turns,
+// returning the number of turns removed
+func (l HistoryLog) Rewind(n int) (int, error) {
+	removed := 0
+	err := WithLock(func() error {
+		history, err := l.Load()
+		if err != nil {
+			return err
+		}
+
+		total := len(history.Turns())
+		if n < 0 || n > total {
+			return fmt.Errorf("turn %d out of range (history has %d turns)", n, total)
+		}
+		if n == total {
+			return nil
+		}
+
+		if _, err := l.Backup(); err != nil {
+			return err
+		}
+
+		history.Truncate(n)
+		removed = total - n
+		return l.Save(history)
+	})
+	return removed, err
+}
+
+// EditTurn backs up the log and replaces the assistant response of turn n
+func (l HistoryLog) EditTurn(n int, content string) error {
+	return WithLock(func() error {
+		history, err := l.Load()
+		if err != nil {
+			return err
+		}
+
+		turn, err := history.turn(n)
+		if err != nil {
+			return err
+		}
+		if turn.Assistant == nil {
+			return fmt.Errorf("turn %d has no response to edit", n)
+		}
+
+		if _, err := l.Backup(); err != nil {
+			return err
+		}
+
+		turn.Assistant.Content = content
+		return l.Save(history)
+	})
+}
+
+// DropTurn backs up the log and removes turn n, keeping later turns
+func (l HistoryLog) DropTurn(n int) error {
+	return WithLock(func() error {
+		history, err := l.Load()
+		if err != nil {
+			return err
+		}
+
+		turn, err := history.turn(n)
+		if err != nil {
+			return err
+		}
+
+		if _, err := l.Backup(); err != nil {
+			return err
+		}
+
+		end := turn.Index + 1
+		if turn.User != nil && turn.Assistant != nil {
+			end++
+		}
+		history.Messages = append(history.Messages[:turn.Index], history.Messages[end:]...)
+		return l.Save(history)
+	})
+}
+
+func (h *History) turn(n int) (Turn, error) {
+	turns := h.Turns()
+	if n < 1 || n > len(turns) {
+		return Turn{}, fmt.Errorf("turn %d out of range (history has %d turns)", n, len(turns))
+	}
+	return turns[n-1], nil
+}
+
+// Backup copies the log into .gitr/history.bak/ and returns the backup's name
+func (l HistoryLog) Backup() (string, error) {
+	root, err := l.root()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(l.path(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read history: %w", err)
+	}
+
+	dir := l.backupDir(root)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + ".json"
+	if err := WriteFileAtomic(filepath.Join(dir, name), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write history backup: %w", err)
+	}
+
+	pruneHistoryBackups(dir)
+	return name, nil
+}
+
+// Backups returns the log's backups, oldest first
+func (l HistoryLog) Backups() ([]string, error) {
+	root, err := l.root()
+	if err != nil {
+		return nil, err
+	}
+	return listBackups(l.backupDir(root))
+}
+
+// Restore replaces the log with a backup. The log being replaced is itself
+// backed up first, so a restore can be undone too. An empty name restores
+// the most recent backup.
+func (l HistoryLog) Restore(name string) (string, error) {
+	root, err := l.root()
+	if err != nil {
+		return "", err
+	}
+	dir := l.backupDir(root)
+
+	err = WithLock(func() error {
+		backups, err := listBackups(dir)
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no backups of the %s", l.Name())
+		}
+
+		if name == "" {
+			name = backups[len(backups)-1]
+		} else if !strings.HasSuffix(name, ".json") {
+			name += ".json"
+		}
+		if filepath.Base(name) != name {
+			return fmt.Errorf("invalid backup name: %s", name)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no such history backup: %s", name)
+			}
+			return fmt.Errorf("failed to read history backup: %w", err)
+		}
+
+		if _, err := l.Backup(); err != nil {
+			return err
+		}
+
+		historyPath := l.path(root)
+		if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+		if err := WriteFileAtomic(historyPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write history: %w", err)
+		}
+		return nil
+	})
+	return name, err
+}
+
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	// Names are UTC timestamps, so lexical order is chronological
+	sort.Strings(names)
+	return names, nil
+}
+
+func pruneHistoryBackups(dir string) {
+	names, err := listBackups(dir)
+	if err != nil {
+		return
+	}
+	for len(names) > maxHistoryBackups {
+		os.Remove(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
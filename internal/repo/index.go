@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const IndexFile = "index"
+
+// Index tracks file contents by hash: what the last commit contained and
+// what 'gitr add' has staged since
+type Index struct {
+	Committed map[string]string `json:"committed"`
+	Staged    map[string]string `json:"staged"`
+}
+
+// HashContent returns the content hash used by the index
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadIndex loads .gitr/index
+func LoadIndex() (*Index, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{Committed: map[string]string{}, Staged: map[string]string{}}
+	data, err := os.ReadFile(filepath.Join(root, GitrDir, IndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	if index.Committed == nil {
+		index.Committed = map[string]string{}
+	}
+	if index.Staged == nil {
+		index.Staged = map[string]string{}
+	}
+
+	return index, nil
+}
+
+// SaveIndex writes .gitr/index
+func SaveIndex(index *Index) error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize index: %w", err)
+	}
+
+	err = WithLock(func() error {
+		return WriteFileAtomic(filepath.Join(root, GitrDir, IndexFile), data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
+
+// StageFiles stages every file whose content differs from the last commit
+func StageFiles(files map[string]string) error {
+	return WithLock(func() error {
+		index, err := LoadIndex()
+		if err != nil {
+			return err
+		}
+
+		index.Staged = map[string]string{}
+		for path, content := range files {
+			hash := HashContent(content)
+			if index.Committed[path] != hash {
+				index.Staged[path] = hash
+			}
+		}
+		// Files that disappeared since the last commit are staged as deletions
+		for path := range index.Committed {
+			if _, ok := files[path]; !ok {
+				index.Staged[path] = ""
+			}
+		}
+
+		return SaveIndex(index)
+	})
+}
+
+// CommitIndex records the staged files as committed
+func CommitIndex() error {
+	return WithLock(func() error {
+		index, err := LoadIndex()
+		if err != nil {
+			return err
+		}
+
+		for path, hash := range index.Staged {
+			if hash == "" {
+				delete(index.Committed, path)
+			} else {
+				index.Committed[path] = hash
+			}
+		}
+		index.Staged = map[string]string{}
+
+		return SaveIndex(index)
+	})
+}
+
+// StagedPaths returns the staged paths in sorted order
+func (i *Index) StagedPaths() []string {
+	paths := make([]string, 0, len(i.Staged))
+	for path := range i.Staged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	LockFile = "index.lock"
+
+	// LockTimeout is how long Lock waits for another gitr process to
+	// release the repository before giving up.
+	LockTimeout = 10 * time.Second
+
+	// StaleLockAge is the age after which a lock whose owner can't be
+	// checked (e.g. it was taken on another host) is considered abandoned.
+	StaleLockAge = 10 * time.Minute
+
+	lockRetryInterval = 50 * time.Millisecond
+)
+
+// LockInfo describes the process holding .gitr/index.lock
+type LockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// Age returns how long the lock has been held
+func (l *LockInfo) Age() time.Duration {
+	return time.Since(l.Created)
+}
+
+// Stale reports whether the lock's owner is gone. A lock taken on this
+// host is stale only once its process has exited, however long it is held
+// (e.g. while an editor is open); one from another host, whose owner
+// can't be checked, once it is too old.
+func (l *LockInfo) Stale() bool {
+	hostname, _ := os.Hostname()
+	if l.Hostname == hostname {
+		return !processAlive(l.PID)
+	}
+	return l.Age() > StaleLockAge
+}
+
+// The lock is reentrant within a process: nested WithLock calls (e.g.
+// AppendMessage inside a pull) share the outer acquisition.
+var (
+	lockMu    sync.Mutex
+	lockDepth int
+	lockPath  string
+)
+
+// WithLock runs fn while holding the repository lock
+func WithLock(fn func() error) error {
+	if err := Lock(); err != nil {
+		return err
+	}
+	defer Unlock()
+	return fn()
+}
+
+// Lock acquires .gitr/index.lock, waiting up to LockTimeout for other
+// gitr processes to release it
+func Lock() error {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	if lockDepth > 0 {
+		lockDepth++
+		return nil
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, GitrDir, LockFile)
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := createLockFile(path)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create lock: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return lockTimeoutError(path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	lockDepth = 1
+	lockPath = path
+	return nil
+}
+
+// Unlock releases a lock taken with Lock
+func Unlock() {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	if lockDepth == 0 {
+		return
+	}
+	lockDepth--
+	if lockDepth == 0 {
+		os.Remove(lockPath)
+		lockPath = ""
+	}
+}
+
+// ReadLock returns the current lock holder, or nil if the repository is unlocked
+func ReadLock() (*LockInfo, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+	return readLockFile(filepath.Join(root, GitrDir, LockFile))
+}
+
+// RemoveStaleLock deletes .gitr/index.lock if its owner is gone
+func RemoveStaleLock() (bool, error) {
+	info, err := ReadLock()
+	if err != nil || info == nil {
+		return false, err
+	}
+	if !info.Stale() {
+		return false, nil
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(filepath.Join(root, GitrDir, LockFile)); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return true, nil
+}
+
+func createLockFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	info := LockInfo{PID: os.Getpid(), Hostname: hostname, Created: time.Now()}
+	data, _ := json.Marshal(info)
+
+	_, werr := f.Write(data)
+	cerr := f.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write lock: %v", errors.Join(werr, cerr))
+	}
+	return nil
+}
+
+func readLockFile(path string) (*LockInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		// A lock we can't parse was probably left half-written by a crash;
+		// fall back to the file's mtime so it still ages out.
+		stat, serr := os.Stat(path)
+		if serr != nil {
+			return nil, fmt.Errorf("failed to read lock: %w", serr)
+		}
+		info = LockInfo{Created: stat.ModTime()}
+	}
+	return &info, nil
+}
+
+func lockTimeoutError(path string) error {
+	msg := fmt.Sprintf("unable to acquire %s after %s: another gitr process is using this repository", filepath.Join(GitrDir, LockFile), LockTimeout)
+
+	if info, _ := readLockFile(path); info != nil {
+		if info.PID != 0 {
+			msg += fmt.Sprintf(" (pid %d on %s, held for %s)", info.PID, info.Hostname, info.Age().Round(time.Second))
+		}
+		if info.Stale() {
+			msg += "\nThe lock appears to be stale. Run 'gitr doctor --fix' to remove it"
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned for paths that would leave the working tree
+// or reach into .gitr
+var ErrUnsafePath = errors.New("unsafe path")
+
+// ValidatePath checks a slash-separated, repository-relative path that
+// came from outside, such as a file name sent by a remote. It must name
+// something inside the working tree and outside .gitr.
+func ValidatePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	if strings.HasPrefix(path, "/") || filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return fmt.Errorf("%w %q: absolute path", ErrUnsafePath, path)
+	}
+	if strings.ContainsAny(path, "\\\x00") {
+		return fmt.Errorf("%w %q: invalid character", ErrUnsafePath, path)
+	}
+	for _, part := range strings.Split(path, "/") {
+		switch {
+		case part == "" || part == ".":
+			return fmt.Errorf("%w %q: empty path component", ErrUnsafePath, path)
+		case part == "..":
+			return fmt.Errorf("%w %q: '..' component", ErrUnsafePath, path)
+		case strings.EqualFold(part, GitrDir):
+			return fmt.Errorf("%w %q: inside %s", ErrUnsafePath, path, GitrDir)
+		}
+	}
+	return nil
+}
+
+// ResolvePath validates path and returns it joined to root, also refusing
+// paths that leave root or enter .gitr through a symlink
+func ResolvePath(root, path string) (string, error) {
+	if err := ValidatePath(path); err != nil {
+		return "", err
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve repository root: %w", err)
+	}
+	full := filepath.Join(root, filepath.FromSlash(path))
+
+	// Resolve the deepest part of the path that exists; whatever is
+	// created below it stays where it points
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrUnsafePath, path, err)
+	}
+
+	rel, err := filepath.Rel(realRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w %q: leads outside the repository through a symlink", ErrUnsafePath, path)
+	}
+	if first, _, _ := strings.Cut(rel, string(filepath.Separator)); strings.EqualFold(first, GitrDir) {
+		return "", fmt.Errorf("%w %q: leads into %s through a symlink", ErrUnsafePath, path, GitrDir)
+	}
+	return full, nil
+}
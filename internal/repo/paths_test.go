@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"file.txt", true},
+		{"dir/file.txt", true},
+		{"dir/.hidden", true},
+		{"..file", true},
+		{"file..", true},
+		{".gitrignore", true},
+		{"dir/.gitr-notes", true},
+
+		{"", false},
+		{"/etc/passwd", false},
+		{"/abs/file.txt", false},
+		{"C:/Windows/system.ini", runtime.GOOS != "windows"},
+		{"..", false},
+		{"../file.txt", false},
+		{"dir/../../file.txt", false},
+		{"dir/..", false},
+		{".", false},
+		{"./file.txt", false},
+		{"dir//file.txt", false},
+		{"dir/", false},
+		{".gitr", false},
+		{".gitr/config.json", false},
+		{".GITR/HEAD", false},
+		{"dir/.Gitr/history.json", false},
+		{`dir\file.txt`, false},
+		{`..\file.txt`, false},
+		{`.gitr\HEAD`, false},
+		{"file\x00.txt", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePath(tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("ValidatePath(%q) = %v, want nil", tt.path, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("ValidatePath(%q) = %v, want ErrUnsafePath", tt.path, err)
+		}
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	mustMkdir(t, filepath.Join(root, GitrDir))
+	mustMkdir(t, filepath.Join(root, "dir"))
+	symlink(t, outside, filepath.Join(root, "escape"))
+	symlink(t, filepath.Join(root, GitrDir), filepath.Join(root, "meta"))
+	symlink(t, filepath.Join(root, "dir"), filepath.Join(root, "alias"))
+	symlink(t, filepath.Join(outside, "target.txt"), filepath.Join(root, "dangling"))
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"file.txt", true},
+		{"dir/file.txt", true},
+		{"new/deeper/file.txt", true},
+		{"alias/file.txt", true},
+
+		{"../file.txt", false},
+		{"/tmp/file.txt", false},
+		{".GITR/HEAD", false},
+		{`dir\..\..\file.txt`, false},
+		{"escape", false},
+		{"escape/file.txt", false},
+		{"escape/new/file.txt", false},
+		{"meta/HEAD", false},
+		{"dangling", false},
+	}
+
+	for _, tt := range tests {
+		full, err := ResolvePath(root, tt.path)
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("ResolvePath(%q) = %v, want nil", tt.path, err)
+		case tt.ok && full != filepath.Join(root, filepath.FromSlash(tt.path)):
+			t.Errorf("ResolvePath(%q) = %q, want it joined to the root", tt.path, full)
+		case !tt.ok && !errors.Is(err, ErrUnsafePath):
+			t.Errorf("ResolvePath(%q) = %q, %v, want ErrUnsafePath", tt.path, full, err)
+		}
+	}
+}
+
+func mustMkdir(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// symlink creates a symlink, skipping the test where that needs privileges
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		if runtime.GOOS == "windows" {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const PinsFile = "pins.json"
+
+// Pins are facts the user has asserted about the repository. They are sent
+// with every command so they survive history truncation.
+type Pins struct {
+	Facts []string `json:"facts"`
+}
+
+// LoadPins loads pinned facts from .gitr/pins.json
+func LoadPins() (*Pins, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, GitrDir, PinsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Pins{Facts: []string{}}, nil
+		}
+		return nil, fmt.Errorf("failed to read pins: %w", err)
+	}
+
+	var pins Pins
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("failed to parse pins: %w", err)
+	}
+
+	return &pins, nil
+}
+
+// SavePins saves pinned facts to .gitr/pins.json
+func SavePins(pins *Pins) error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize pins: %w", err)
+	}
+
+	err = WithLock(func() error {
+		return WriteFileAtomic(filepath.Join(root, GitrDir, PinsFile), data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write pins: %w", err)
+	}
+
+	return nil
+}
+
+// AddPin pins a new fact and returns its 1-based number
+func AddPin(fact string) (int, error) {
+	fact = strings.TrimSpace(fact)
+	if fact == "" {
+		return 0, fmt.Errorf("pinned fact cannot be empty")
+	}
+
+	n := 0
+	err := WithLock(func() error {
+		pins, err := LoadPins()
+		if err != nil {
+			return err
+		}
+
+		pins.Facts = append(pins.Facts, fact)
+		n = len(pins.Facts)
+		return SavePins(pins)
+	})
+	return n, err
+}
+
+// RemovePin unpins fact n (1-based) and returns it
+func RemovePin(n int) (string, error) {
+	fact := ""
+	err := WithLock(func() error {
+		pins, err := LoadPins()
+		if err != nil {
+			return err
+		}
+
+		if n < 1 || n > len(pins.Facts) {
+			return fmt.Errorf("pin %d out of range (%d pinned facts)", n, len(pins.Facts))
+		}
+
+		fact = pins.Facts[n-1]
+		pins.Facts = append(pins.Facts[:n-1], pins.Facts[n:]...)
+		return SavePins(pins)
+	})
+	return fact, err
+}
+
+// ClearPins removes every pinned fact and returns how many were removed
+func ClearPins() (int, error) {
+	n := 0
+	err := WithLock(func() error {
+		pins, err := LoadPins()
+		if err != nil {
+			return err
+		}
+
+		n = len(pins.Facts)
+		return SavePins(&Pins{Facts: []string{}})
+	})
+	return n, err
+}
@@ -0,0 +1,299 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HeadsDir holds one file per branch, containing the id of the branch's
+// last commit (empty until the branch has one)
+const HeadsDir = "refs/heads"
+
+func refPath(root, branch string) string {
+	return filepath.Join(root, GitrDir, filepath.FromSlash(HeadsDir), filepath.FromSlash(branch))
+}
+
+// ReadRef returns the commit a branch points to, and whether the branch
+// exists in the refs store
+func ReadRef(branch string) (string, bool, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return "", false, err
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return "", false, err
+	}
+
+	data, err := os.ReadFile(refPath(root, branch))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to read ref for %s: %w", branch, err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// WriteRef points a branch at a commit, creating the branch if needed
+func WriteRef(branch, commit string) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	path := refPath(root, branch)
+	err = WithLock(func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return WriteFileAtomic(path, []byte(commit+"\n"), 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write ref for %s: %w", branch, err)
+	}
+	return nil
+}
+
+// DeleteRef removes a branch from the refs store
+func DeleteRef(branch string) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	return WithLock(func() error {
+		err := os.Remove(refPath(root, branch))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete ref for %s: %w", branch, err)
+		}
+		return nil
+	})
+}
+
+// ListBranches returns every branch in the refs store plus the current
+// branch, which has no ref until something creates one
+func ListBranches() ([]string, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	if current, err := GetCurrentBranch(); err == nil && current != "" {
+		seen[current] = true
+	}
+
+	dir := filepath.Join(root, GitrDir, filepath.FromSlash(HeadsDir))
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		seen[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branches := make([]string, 0, len(seen))
+	for branch := range seen {
+		branches = append(branches, branch)
+	}
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// RemotesDir holds what was last seen of each remote branch, one file per
+// <remote>/<branch>
+const RemotesDir = "refs/remotes"
+
+// RemoteState is the state of a remote branch: its newest commit, where
+// the remote's history and per-branch logs ended and the hash of each of
+// its files
+type RemoteState struct {
+	Head          string                 `json:"head"`
+	HistoryLength int                    `json:"history_length"`
+	HistoryHash   string                 `json:"history_hash"`
+	Histories     map[string]LogPosition `json:"histories,omitempty"`
+	Tree          map[string]string      `json:"tree,omitempty"`
+}
+
+// LogPosition is where a remote's per-branch log ended: its length and
+// the hash of its messages
+type LogPosition struct {
+	Length int    `json:"length"`
+	Hash   string `json:"hash"`
+}
+
+// RemoteRef is a remote branch as of the last fetch, push or pull, with
+// its history
+type RemoteRef struct {
+	RemoteState
+	History []Message `json:"history,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+
+	// Base is the state as of the last push or pull if a fetch has
+	// updated the ref since; the next push's lease and pull's merge start
+	// from there
+	Base *RemoteState `json:"base,omitempty"`
+}
+
+// Synced returns the state of the remote branch as of the last push or pull
+func (r *RemoteRef) Synced() RemoteState {
+	if r.Base != nil {
+		return *r.Base
+	}
+	return r.RemoteState
+}
+
+func remoteRefPath(root, remote, branch string) string {
+	return filepath.Join(root, GitrDir, filepath.FromSlash(RemotesDir), remote, filepath.FromSlash(branch))
+}
+
+// ReadRemoteRef returns what was last seen of a remote branch, or nil if
+// it has never been pushed or pulled
+func ReadRemoteRef(remote, branch string) (*RemoteRef, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(remoteRefPath(root, remote, branch))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read ref for %s/%s: %w", remote, branch, err)
+	}
+
+	var ref RemoteRef
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return nil, fmt.Errorf("failed to parse ref for %s/%s: %w", remote, branch, err)
+	}
+	return &ref, nil
+}
+
+// WriteRemoteRef records the state of a remote branch
+func WriteRemoteRef(remote, branch string, ref *RemoteRef) error {
+	if err := ValidateBranchName(branch); err != nil {
+		return err
+	}
+
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(ref, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize ref: %w", err)
+	}
+
+	path := remoteRefPath(root, remote, branch)
+	err = WithLock(func() error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return WriteFileAtomic(path, data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write ref for %s/%s: %w", remote, branch, err)
+	}
+	return nil
+}
+
+// ListRemoteRefs returns the branches recorded for a remote, sorted
+func ListRemoteRefs(remote string) ([]string, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	dir := filepath.Join(root, GitrDir, filepath.FromSlash(RemotesDir), remote)
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		branches = append(branches, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs of remote %s: %w", remote, err)
+	}
+
+	sort.Strings(branches)
+	return branches, nil
+}
+
+// RenameRemoteRefs moves everything recorded for a remote to a new name
+func RenameRemoteRefs(oldName, newName string) error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(root, GitrDir, filepath.FromSlash(RemotesDir))
+	return WithLock(func() error {
+		err := os.Rename(filepath.Join(dir, oldName), filepath.Join(dir, newName))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rename refs of remote %s: %w", oldName, err)
+		}
+		return nil
+	})
+}
+
+// DeleteRemoteRefs forgets everything recorded for a remote
+func DeleteRemoteRefs(remote string) error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	return WithLock(func() error {
+		if err := os.RemoveAll(filepath.Join(root, GitrDir, filepath.FromSlash(RemotesDir), remote)); err != nil {
+			return fmt.Errorf("failed to delete refs of remote %s: %w", remote, err)
+		}
+		return nil
+	})
+}
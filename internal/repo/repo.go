@@ -3,7 +3,6 @@ package repo
 import (
 	"bytes"
 	"crypto/sha1"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,16 +11,19 @@ import (
 )
 
 const (
-	GitrDir     = ".gitr"
-	HistoryFile = "history.json"
-	ConfigFile  = "config.json"
-	HEADFile    = "HEAD"
+	GitrDir      = ".gitr"
+	HistoryFile  = "history.json"
+	HistoriesDir = "histories"
+	ConfigFile   = "config.json"
+	HEADFile     = "HEAD"
 )
 
 type Message struct {
 	Role      string    `json:"role"` // "user" or "assistant"
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	Profile   string    `json:"profile,omitempty"` // LLM profile that handled the command
+	Model     string    `json:"model,omitempty"`   // model that produced an assistant message
 }
 
 type History struct {
@@ -83,62 +85,26 @@ func Init() error {
 
 // LoadHistory loads the chat history from .gitr/history.json
 func LoadHistory() (*History, error) {
-	root, err := GetGitrRoot()
-	if err != nil {
-		return nil, err
-	}
-
-	historyPath := filepath.Join(root, GitrDir, HistoryFile)
-	data, err := os.ReadFile(historyPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &History{Messages: []Message{}}, nil
-		}
-		return nil, fmt.Errorf("failed to read history: %w", err)
-	}
-
-	var history History
-	if err := json.Unmarshal(data, &history); err != nil {
-		return nil, fmt.Errorf("failed to parse history: %w", err)
-	}
-
-	return &history, nil
+	return SharedHistory().Load()
 }
 
 // SaveHistory saves the chat history to .gitr/history.json
 func SaveHistory(history *History) error {
-	root, err := GetGitrRoot()
-	if err != nil {
-		return err
-	}
-
-	historyPath := filepath.Join(root, GitrDir, HistoryFile)
-	data, err := json.MarshalIndent(history, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to serialize history: %w", err)
-	}
-
-	if err := os.WriteFile(historyPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write history: %w", err)
-	}
-
-	return nil
+	return SharedHistory().Save(history)
 }
 
 // AppendMessage adds a new message to the history
 func AppendMessage(role, content string) error {
-	history, err := LoadHistory()
-	if err != nil {
-		return err
-	}
-
-	history.Messages = append(history.Messages, Message{
+	return AppendMessages(Message{
 		Role:      role,
 		Content:   content,
 		Timestamp: time.Now(),
 	})
+}
 
-	return SaveHistory(history)
+// AppendMessages adds messages to .gitr/history.json in a single locked update
+func AppendMessages(messages ...Message) error {
+	return SharedHistory().Append(messages...)
 }
 
 // GetAllFiles recursively gets all files in the repository (excluding .gitr)
@@ -167,6 +133,11 @@ func GetAllFiles() (map[string]string, error) {
 			return nil
 		}
 
+		// Skip symlinks, which may point outside the repository
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
 		// Skip files that are too large (> 1MB)
 		const maxFileSize = 1 * 1024 * 1024 // 1MB
 		if info.Size() > maxFileSize {
@@ -231,7 +202,10 @@ func SetCurrentBranch(branch string) error {
 	}
 
 	headPath := filepath.Join(root, GitrDir, HEADFile)
-	if err := os.WriteFile(headPath, []byte(branch), 0644); err != nil {
+	err = WithLock(func() error {
+		return WriteFileAtomic(headPath, []byte(branch), 0644)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to write HEAD: %w", err)
 	}
 
@@ -252,6 +226,36 @@ func WriteFile(path string, content string) error {
 	return nil
 }
 
+// WriteFileAtomic writes data to a temporary file and renames it into place,
+// so concurrent readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
 // ParseTimestamp parses an ISO 8601 timestamp string
 func ParseTimestamp(ts string) (time.Time, error) {
 	// Try RFC3339 format first
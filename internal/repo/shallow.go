@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ShallowFile marks a repository cloned with only the last turns of its
+// history, recording the messages left out
+const ShallowFile = "shallow"
+
+// Shallow describes the messages a shallow clone left out of its history:
+// how many there are and their hash (see remote.HashMessages)
+type Shallow struct {
+	Length int    `json:"length"`
+	Hash   string `json:"hash"`
+}
+
+// ReadShallow returns what a shallow clone left out, or nil if the
+// repository has its whole history
+func ReadShallow() (*Shallow, error) {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, GitrDir, ShallowFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read shallow file: %w", err)
+	}
+
+	var shallow Shallow
+	if err := json.Unmarshal(data, &shallow); err != nil {
+		return nil, fmt.Errorf("failed to parse shallow file: %w", err)
+	}
+	return &shallow, nil
+}
+
+// WriteShallow marks the repository as shallow
+func WriteShallow(shallow *Shallow) error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(shallow, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize shallow file: %w", err)
+	}
+
+	err = WithLock(func() error {
+		return WriteFileAtomic(filepath.Join(root, GitrDir, ShallowFile), data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write shallow file: %w", err)
+	}
+	return nil
+}
+
+// RemoveShallow marks the repository as having its whole history
+func RemoveShallow() error {
+	root, err := GetGitrRoot()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(root, GitrDir, ShallowFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove shallow file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mysticshirou/gitroulette/internal/remote"
+)
+
+// minCompressSize is the smallest response worth compressing
+const minCompressSize = 1024
+
+// decodeBody replaces a gzipped request body with its decompressed
+// content; readJSON's size limit then applies to what it decompresses to
+func decodeBody(w http.ResponseWriter, r *http.Request) bool {
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return true
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid gzip body: %v", err))
+			return false
+		}
+		r.Body = zr
+		return true
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Encoding: %s", encoding))
+		return false
+	}
+}
+
+// gzipWriter buffers a response so finish can gzip it if it's big enough
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// finish sends the buffered response
+func (w *gzipWriter) finish() {
+	body := w.buf.Bytes()
+	if len(body) >= minCompressSize {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if zw.Close() == nil {
+			body = buf.Bytes()
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// serveCompressed runs handler, gzipping its response if the client
+// accepts gzip, and tells clients they may gzip request bodies
+func serveCompressed(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
+	w.Header().Set("Accept-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !decodeBody(w, r) {
+		return
+	}
+	if !remote.AcceptsGzip(r.Header.Get("Accept-Encoding")) {
+		handler(w, r)
+		return
+	}
+	gw := &gzipWriter{ResponseWriter: w}
+	handler(gw, r)
+	gw.finish()
+}
@@ -0,0 +1,641 @@
+// Package server implements the remote HTTP API that remote.Client talks
+// to, storing repositories in a Store on the local filesystem
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// maxBodySize bounds how much a single request may upload
+const maxBodySize = 64 << 20
+
+// Server serves the remote API:
+//
+//	POST /api/repos               create a repository
+//	GET  /api/repos               list repositories
+//	GET  /api/repos/:id           repository info
+//	POST /api/repos/:id/negotiate find out what an incremental push must send
+//	POST /api/repos/:id/push      push a branch's files, commits and history
+//	GET  /api/repos/:id/pull      pull a branch (?branch=, default main;
+//	                              ?depth= for only the last turns of history)
+//	GET  /api/repos/:id/commits   list commits (?branch=)
+//	GET  /api/repos/:id/tree      list a branch's files (?branch=)
+//
+// Once the store has tokens, every request needs an "Authorization: Bearer"
+// header with a token granting read access to the repository, or write
+// access for push. Creating repositories needs write access to all of them.
+//
+// A push with a lease is rejected with 409 and code "non_fast_forward" if
+// the branch or history has moved since the client last saw it. A
+// per-branch push ("per_branch": true) leases only the branch's own log
+// and leaves the shared history alone. Accepted pushes with a lease say so
+// with "lease_checked": true.
+//
+// Request bodies may be gzipped (Content-Encoding: gzip), which every
+// response advertises with "Accept-Encoding: gzip", and responses are
+// gzipped for clients that send "Accept-Encoding: gzip".
+type Server struct {
+	store *Store
+	log   io.Writer
+}
+
+// New returns a server for store, logging requests to log if it isn't nil
+func New(store *Store, log io.Writer) *Server {
+	return &Server{store: store, log: log}
+}
+
+// statusWriter remembers the status code for the request log
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	serveCompressed(sw, r, s.route)
+	if s.log != nil {
+		fmt.Fprintf(s.log, "%s %s %s %d %s\n", start.Format("2006-01-02 15:04:05"), r.Method, r.URL.Path, sw.status, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// route dispatches on the path; the API is small enough not to need a router
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(path.Clean(r.URL.Path), "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" || parts[1] != "repos" {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		if _, ok := s.authorize(w, r, AllRepos, AccessWrite); ok {
+			s.createRepo(w, r)
+		}
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		if token, ok := s.authorize(w, r, "", AccessRead); ok {
+			s.listRepos(w, r, token)
+		}
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		if _, ok := s.authorize(w, r, parts[2], AccessRead); ok {
+			s.getRepo(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[3] == "negotiate" && r.Method == http.MethodPost:
+		// Negotiating tells no more than a pull, and fetch does it too
+		if _, ok := s.authorize(w, r, parts[2], AccessRead); ok {
+			s.negotiate(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[3] == "push" && r.Method == http.MethodPost:
+		if _, ok := s.authorize(w, r, parts[2], AccessWrite); ok {
+			s.push(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[3] == "pull" && r.Method == http.MethodGet:
+		if _, ok := s.authorize(w, r, parts[2], AccessRead); ok {
+			s.pull(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[3] == "commits" && r.Method == http.MethodGet:
+		if _, ok := s.authorize(w, r, parts[2], AccessRead); ok {
+			s.commits(w, r, parts[2])
+		}
+	case len(parts) == 4 && parts[3] == "tree" && r.Method == http.MethodGet:
+		if _, ok := s.authorize(w, r, parts[2], AccessRead); ok {
+			s.tree(w, r, parts[2])
+		}
+	case len(parts) <= 4:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// authorize checks the request's bearer token against a repository; an
+// empty repoID only requires a valid token. It answers the request itself
+// and returns false if access is denied. The token is nil while
+// authentication is off and the server is open to everyone.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, repoID string, access Access) (*Token, bool) {
+	enabled, err := s.store.AuthEnabled()
+	if err != nil {
+		s.storeError(w, err, "Failed to check credentials")
+		return nil, false
+	}
+	if !enabled {
+		return nil, true
+	}
+
+	scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || secret == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gitr"`)
+		writeError(w, http.StatusUnauthorized, "Authentication required")
+		return nil, false
+	}
+	token, err := s.store.Authenticate(strings.TrimSpace(secret))
+	if err != nil {
+		s.storeError(w, err, "Failed to check credentials")
+		return nil, false
+	}
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gitr", error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "Invalid token")
+		return nil, false
+	}
+
+	if repoID != "" && !token.Allows(repoID, access) {
+		if repoID == AllRepos {
+			writeError(w, http.StatusForbidden, "Token does not allow creating repositories")
+		} else {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("Token does not grant %s access to repository %s", access, repoID))
+		}
+		return nil, false
+	}
+	return token, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// storeError reports a store failure, hiding internal details from clients
+func (s *Server) storeError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Repository not found")
+		return
+	}
+	if s.log != nil {
+		fmt.Fprintf(s.log, "error: %v\n", err)
+	}
+	writeError(w, http.StatusInternalServerError, message)
+}
+
+// readJSON decodes a request body into v
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// repoInfo is a repository without its contents
+type repoInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type branchInfo struct {
+	ID        string `json:"id"`
+	RepoID    string `json:"repo_id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+type commitInfo struct {
+	ID         string `json:"id"`
+	RepoID     string `json:"repo_id"`
+	BranchID   string `json:"branch_id"`
+	Message    string `json:"message"`
+	Hash       string `json:"commit_hash"`
+	CreatedAt  string `json:"created_at"`
+	BranchName string `json:"branch_name,omitempty"`
+}
+
+type treeEntry struct {
+	ID        string `json:"id"`
+	Path      string `json:"path"`
+	CreatedAt string `json:"created_at"`
+}
+
+func infoOf(r *Repository) repoInfo {
+	return repoInfo{ID: r.ID, Name: r.Name, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt}
+}
+
+func commitInfoOf(r *Repository, b *Branch, c *Commit) commitInfo {
+	return commitInfo{ID: c.ID, RepoID: r.ID, BranchID: b.ID, Message: c.Message, Hash: c.Hash, CreatedAt: c.CreatedAt}
+}
+
+func (s *Server) createRepo(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		writeError(w, http.StatusBadRequest, "Repository name is required")
+		return
+	}
+
+	created, err := s.store.CreateRepo(body.Name)
+	if err != nil {
+		s.storeError(w, err, "Failed to create repository")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": created.ID, "name": created.Name})
+}
+
+// listRepos lists the repositories token can read (all of them with no token)
+func (s *Server) listRepos(w http.ResponseWriter, r *http.Request, token *Token) {
+	repos, err := s.store.Repos()
+	if err != nil {
+		s.storeError(w, err, "Failed to fetch repositories")
+		return
+	}
+	infos := []repoInfo{}
+	for _, repository := range repos {
+		if token == nil || token.Allows(repository.ID, AccessRead) {
+			infos = append(infos, infoOf(repository))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"repositories": infos})
+}
+
+func (s *Server) getRepo(w http.ResponseWriter, r *http.Request, id string) {
+	repository, err := s.store.Repo(id)
+	if err != nil {
+		s.storeError(w, err, "Failed to fetch repository")
+		return
+	}
+
+	branches := []branchInfo{}
+	count := 0
+	for _, branch := range repository.SortedBranches() {
+		branches = append(branches, branchInfo{ID: branch.ID, RepoID: repository.ID, Name: branch.Name, CreatedAt: branch.CreatedAt})
+		count += len(branch.Commits)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"repository":   infoOf(repository),
+		"branches":     branches,
+		"commit_count": count,
+	})
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request, id string) {
+	var data remote.PushData
+	if !readJSON(w, r, &data) {
+		return
+	}
+	if err := repo.ValidateBranchName(data.Branch); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for branch := range data.Histories {
+		if err := repo.ValidateBranchName(branch); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for p := range data.Files {
+		if err := repo.ValidatePath(p); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	for p := range data.Tree {
+		if err := repo.ValidatePath(p); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if _, err := s.store.Repo(id); err != nil {
+		s.storeError(w, err, "Failed to push to repository")
+		return
+	}
+
+	// Store contents first; objects nobody refers to are harmless
+	tree := map[string]string{}
+	for p, content := range data.Files {
+		hash, err := s.store.WriteObject(id, content)
+		if err != nil {
+			s.storeError(w, err, "Failed to push to repository")
+			return
+		}
+		tree[p] = hash
+	}
+	for hash, content := range data.Blobs {
+		if remote.HashContent(content) != hash {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("blob does not match its hash: %s", hash))
+			return
+		}
+		if _, err := s.store.WriteObject(id, content); err != nil {
+			s.storeError(w, err, "Failed to push to repository")
+			return
+		}
+	}
+	for p, hash := range data.Tree {
+		if !s.store.HasObject(id, hash) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("missing blob %s for %s", hash, p))
+			return
+		}
+		tree[p] = hash
+	}
+
+	var pushed remote.Position
+	var histories map[string]remote.Position
+	err := s.store.Update(id, func(repository *Repository) error {
+		if data.Lease != nil {
+			if err := checkLease(repository, &data); err != nil {
+				return err
+			}
+		}
+
+		branch := repository.Branches[data.Branch]
+		if branch == nil {
+			branch = &Branch{ID: newID(), Name: data.Branch, CreatedAt: now()}
+			repository.Branches[data.Branch] = branch
+		}
+		for _, commit := range data.Commits {
+			branch.Commits = append(branch.Commits, &Commit{
+				ID:        newID(),
+				Hash:      commit.Hash,
+				Message:   commit.Message,
+				CreatedAt: now(),
+				Files:     tree,
+			})
+		}
+
+		// A per-branch push carries only the branch's own log
+		if !data.PerBranch {
+			history, err := extend(repository.History, data.HistoryBase, data.History)
+			if err != nil {
+				return err
+			}
+			if data.Lease != nil && !data.AllowRewrite && !keepsAll(history, repository.History) {
+				return &rejectedError{"The push would drop turns from the remote history"}
+			}
+			repository.History = history
+		}
+		pushed = remote.PositionOf(repository.History)
+
+		// Per-branch logs the push doesn't carry are left as they are
+		if repository.Histories == nil && data.Histories != nil {
+			repository.Histories = map[string][]remote.Message{}
+		}
+		for name, messages := range data.Histories {
+			var base *remote.Position
+			if position, ok := data.HistoriesBase[name]; ok {
+				base = &position
+			}
+			log, err := extend(repository.Histories[name], base, messages)
+			if err != nil {
+				return err
+			}
+			if data.Lease != nil && !data.AllowRewrite && !keepsAll(log, repository.Histories[name]) {
+				return &rejectedError{fmt.Sprintf("The push would drop turns from the history of branch %s", name)}
+			}
+			repository.Histories[name] = log
+		}
+		histories = remote.PositionsOf(repository.Histories)
+		return nil
+	})
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": rejected.message, "code": remote.NonFastForwardCode})
+		return
+	}
+	if errors.Is(err, remote.ErrHistoryMoved) {
+		writeError(w, http.StatusConflict, "History changed since negotiation; push again")
+		return
+	}
+	if err != nil {
+		s.storeError(w, err, "Failed to push to repository")
+		return
+	}
+	writeJSON(w, http.StatusOK, &remote.PushResult{Success: true, Message: "Pushed successfully", History: &pushed, Histories: histories, LeaseChecked: data.Lease != nil})
+}
+
+// rejectedError is a push refused as non-fast-forward
+type rejectedError struct {
+	message string
+}
+
+func (e *rejectedError) Error() string {
+	return e.message
+}
+
+// leaseOf returns the state a push to branch must have been built on
+func leaseOf(repository *Repository, branch string) remote.Lease {
+	lease := remote.Lease{History: remote.PositionOf(repository.History)}
+	if b := repository.Branches[branch]; b != nil && b.Latest() != nil {
+		lease.Head = b.Latest().Hash
+	}
+	return lease
+}
+
+// checkLease rejects a push built on a stale view of the repository
+func checkLease(repository *Repository, data *remote.PushData) error {
+	current := leaseOf(repository, data.Branch)
+	if current.Head != data.Lease.Head || (!data.PerBranch && current.History != data.Lease.History) {
+		return &rejectedError{fmt.Sprintf("The remote has changes on %s that the push doesn't include", data.Branch)}
+	}
+
+	// Older clients don't record per-branch logs; keepsAll still guards them
+	if data.Lease.Histories == nil && !data.PerBranch {
+		return nil
+	}
+	for name := range data.Histories {
+		leased, ok := data.Lease.Histories[name]
+		if !ok {
+			leased = remote.PositionOf(nil)
+		}
+		if remote.PositionOf(repository.Histories[name]) != leased {
+			return &rejectedError{fmt.Sprintf("The remote has changes to the history of branch %s that the push doesn't include", name)}
+		}
+	}
+	return nil
+}
+
+// keepsAll reports whether messages contains every message of log in the
+// same order, as a merged history does
+func keepsAll(messages, log []remote.Message) bool {
+	i := 0
+	for _, msg := range messages {
+		if i < len(log) && msg == log[i] {
+			i++
+		}
+	}
+	return i == len(log)
+}
+
+// extend appends messages to the part of a log that base describes, or
+// replaces the log if there is no base
+func extend(log []remote.Message, base *remote.Position, messages []remote.Message) ([]remote.Message, error) {
+	if base == nil {
+		log = nil
+	} else {
+		if !base.Continues(log) {
+			return nil, remote.ErrHistoryMoved
+		}
+		log = log[:base.Length:base.Length]
+	}
+	return append(append([]remote.Message{}, log...), messages...), nil
+}
+
+// negotiate reports which of a push's blobs are missing and where the
+// stored histories end
+func (s *Server) negotiate(w http.ResponseWriter, r *http.Request, id string) {
+	var request remote.NegotiateRequest
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	repository, err := s.store.Repo(id)
+	if err != nil {
+		s.storeError(w, err, "Failed to negotiate push")
+		return
+	}
+
+	response := remote.NegotiateResponse{
+		Missing: []string{},
+		Head:    leaseOf(repository, request.Branch).Head,
+		History: remote.PositionOf(repository.History),
+	}
+	seen := map[string]bool{}
+	for _, hash := range request.Files {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if !s.store.HasObject(id, hash) {
+			response.Missing = append(response.Missing, hash)
+		}
+	}
+	sort.Strings(response.Missing)
+
+	response.Histories = remote.PositionsOf(repository.Histories)
+	writeJSON(w, http.StatusOK, &response)
+}
+
+// pullBranch picks the branch asked for, else main, else the oldest one
+func pullBranch(repository *Repository, name string) *Branch {
+	if name != "" {
+		return repository.Branches[name]
+	}
+	if branch, ok := repository.Branches["main"]; ok {
+		return branch
+	}
+	if branches := repository.SortedBranches(); len(branches) > 0 {
+		return branches[0]
+	}
+	return nil
+}
+
+func (s *Server) pull(w http.ResponseWriter, r *http.Request, id string) {
+	repository, err := s.store.Repo(id)
+	if err != nil {
+		s.storeError(w, err, "Failed to pull from repository")
+		return
+	}
+
+	name := r.URL.Query().Get("branch")
+	branch := pullBranch(repository, name)
+	if branch == nil && name != "" {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Branch not found: %s", name))
+		return
+	}
+	if branch == nil {
+		writeError(w, http.StatusNotFound, "No branches found")
+		return
+	}
+
+	files, err := s.store.ReadFiles(id, branch.Latest())
+	if err != nil {
+		s.storeError(w, err, "Failed to pull from repository")
+		return
+	}
+
+	data := &remote.PullData{
+		Branch:    branch.Name,
+		Files:     files,
+		History:   repository.History,
+		Histories: repository.Histories,
+		Head:      leaseOf(repository, branch.Name).Head,
+	}
+	if depth, err := strconv.Atoi(r.URL.Query().Get("depth")); err == nil && depth > 0 {
+		data.Shorten(depth)
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (s *Server) commits(w http.ResponseWriter, r *http.Request, id string) {
+	repository, err := s.store.Repo(id)
+	if err != nil {
+		s.storeError(w, err, "Failed to fetch commits")
+		return
+	}
+
+	branches := repository.SortedBranches()
+	if branch := repository.Branches[r.URL.Query().Get("branch")]; branch != nil {
+		branches = []*Branch{branch}
+	}
+
+	commits := []commitInfo{}
+	for _, branch := range branches {
+		for _, commit := range branch.Commits {
+			info := commitInfoOf(repository, branch, commit)
+			info.BranchName = branch.Name
+			commits = append(commits, info)
+		}
+	}
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].CreatedAt > commits[j].CreatedAt })
+	if len(commits) > 100 {
+		commits = commits[:100]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"commits": commits})
+}
+
+func (s *Server) tree(w http.ResponseWriter, r *http.Request, id string) {
+	repository, err := s.store.Repo(id)
+	if err != nil {
+		s.storeError(w, err, "Failed to fetch tree")
+		return
+	}
+
+	name := r.URL.Query().Get("branch")
+	if name == "" {
+		name = "main"
+	}
+	branch := repository.Branches[name]
+	if branch == nil {
+		writeError(w, http.StatusNotFound, "Branch not found")
+		return
+	}
+
+	commit := branch.Latest()
+	if commit == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"tree": []treeEntry{}, "commit": nil})
+		return
+	}
+
+	tree := []treeEntry{}
+	for p, hash := range commit.Files {
+		tree = append(tree, treeEntry{ID: hash, Path: p, CreatedAt: commit.CreatedAt})
+	}
+	sort.Slice(tree, func(i, j int) bool { return tree[i].Path < tree[j].Path })
+	writeJSON(w, http.StatusOK, map[string]any{"tree": tree, "commit": commitInfoOf(repository, branch, commit)})
+}
@@ -0,0 +1,408 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mysticshirou/gitroulette/internal/remote"
+)
+
+// newTestServer serves a fresh store and returns it with the server's URL
+func newTestServer(t *testing.T) (*Store, string) {
+	t.Helper()
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(store, nil))
+	t.Cleanup(ts.Close)
+	return store, ts.URL
+}
+
+// newTestRepo creates a repository and returns a client for it
+func newTestRepo(t *testing.T, url, token string) *remote.Client {
+	t.Helper()
+	id, err := remote.NewClientWithURL(url, token).CreateRepo("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remote.NewClientForRepo(url, id, token)
+}
+
+func turn(command, response string) []remote.Message {
+	return []remote.Message{
+		{Role: "user", Content: command, Timestamp: "2024-01-01T00:00:00Z"},
+		{Role: "assistant", Content: response, Timestamp: "2024-01-01T00:00:00Z"},
+	}
+}
+
+func TestPushPullRoundTrip(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	history := turn("git commit -m first", "committed")
+	_, err := client.Push(&remote.PushData{
+		Branch:  "main",
+		Commits: []remote.Commit{{Hash: "c1", Message: "first", Branch: "main"}},
+		Files:   map[string]string{"a.txt": "a", "dir/b.txt": "b"},
+		History: history,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pulled, err := client.Pull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.Branch != "main" || pulled.Head != "c1" {
+		t.Errorf("pulled branch %s at %s, want main at c1", pulled.Branch, pulled.Head)
+	}
+	if len(pulled.Files) != 2 || pulled.Files["a.txt"] != "a" || pulled.Files["dir/b.txt"] != "b" {
+		t.Errorf("pulled files %v", pulled.Files)
+	}
+	if remote.PositionOf(pulled.History) != remote.PositionOf(history) {
+		t.Errorf("pulled history %v, want %v", pulled.History, history)
+	}
+
+	if _, err := client.PullBranch("feature", 0); !errors.Is(err, remote.ErrBranchNotFound) {
+		t.Errorf("PullBranch(feature) = %v, want ErrBranchNotFound", err)
+	}
+
+	branches, err := client.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 1 || branches[0] != "main" {
+		t.Errorf("Branches() = %v, want [main]", branches)
+	}
+}
+
+func TestPullWithDepth(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	var history []remote.Message
+	for _, command := range []string{"git status", "git add .", "git commit"} {
+		history = append(history, turn(command, "ok")...)
+	}
+	if _, err := client.Push(&remote.PushData{Branch: "main", Files: map[string]string{}, History: history}); err != nil {
+		t.Fatal(err)
+	}
+
+	pulled, err := client.PullBranch("main", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled.History) != 2 || pulled.History[0].Content != "git commit" {
+		t.Errorf("shallow pull got %v, want the last turn", pulled.History)
+	}
+	if pulled.Shallow == nil || pulled.Shallow.Length != 4 {
+		t.Errorf("Shallow = %v, want the first 4 messages", pulled.Shallow)
+	}
+	if pulled.HistoryPosition() != remote.PositionOf(history) {
+		t.Errorf("HistoryPosition() = %v, want the whole log", pulled.HistoryPosition())
+	}
+}
+
+func TestNegotiateAndIncrementalPush(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	first := turn("git commit -m first", "committed")
+	if _, err := client.Push(&remote.PushData{
+		Branch:  "main",
+		Commits: []remote.Commit{{Hash: "c1"}},
+		Files:   map[string]string{"a.txt": "a"},
+		History: first,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := map[string]string{"a.txt": remote.HashContent("a"), "b.txt": remote.HashContent("b")}
+	negotiated, err := client.Negotiate(&remote.NegotiateRequest{Branch: "main", Files: tree})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(negotiated.Missing) != 1 || negotiated.Missing[0] != tree["b.txt"] {
+		t.Errorf("Missing = %v, want only b.txt's blob", negotiated.Missing)
+	}
+	if negotiated.Head != "c1" || negotiated.History != remote.PositionOf(first) {
+		t.Errorf("negotiated head %q at %v, want c1 at the pushed history", negotiated.Head, negotiated.History)
+	}
+
+	second := turn("git commit -m second", "committed")
+	result, err := client.Push(&remote.PushData{
+		Branch:      "main",
+		Commits:     []remote.Commit{{Hash: "c2"}},
+		Tree:        tree,
+		Blobs:       map[string]string{tree["b.txt"]: "b"},
+		History:     second,
+		HistoryBase: &negotiated.History,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := append(append([]remote.Message{}, first...), second...)
+	if result.History == nil || *result.History != remote.PositionOf(full) {
+		t.Errorf("push result history = %v, want both turns", result.History)
+	}
+
+	pulled, err := client.Pull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.Head != "c2" || pulled.Files["b.txt"] != "b" || len(pulled.History) != 4 {
+		t.Errorf("pulled %s with files %v and %d messages", pulled.Head, pulled.Files, len(pulled.History))
+	}
+
+	// A base that isn't where the server's log is gets refused rather than spliced in
+	stale := remote.Position{Length: len(first), Hash: remote.HashMessages(second)}
+	_, err = client.Push(&remote.PushData{Branch: "main", Tree: tree, History: second, HistoryBase: &stale})
+	if !errors.Is(err, remote.ErrHistoryMoved) {
+		t.Errorf("push on a stale base = %v, want ErrHistoryMoved", err)
+	}
+
+	// Blobs have to match the hash they are sent under
+	_, err = client.Push(&remote.PushData{Branch: "main", Tree: tree, Blobs: map[string]string{tree["b.txt"]: "not b"}})
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("push with a bad blob = %v, want a hash mismatch", err)
+	}
+}
+
+func TestPushLease(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	empty := remote.PositionOf(nil)
+	first := turn("git commit -m first", "committed")
+	result, err := client.Push(&remote.PushData{
+		Branch:  "main",
+		Commits: []remote.Commit{{Hash: "c1"}},
+		Files:   map[string]string{},
+		History: first,
+		Lease:   &remote.Lease{History: empty},
+	})
+	if err != nil {
+		t.Fatalf("push onto an empty repository: %v", err)
+	}
+	if !result.LeaseChecked {
+		t.Error("push result doesn't confirm the lease was checked")
+	}
+
+	// Another client that still thinks the repository is empty
+	second := turn("git commit -m other", "committed")
+	_, err = client.Push(&remote.PushData{
+		Branch:  "main",
+		Commits: []remote.Commit{{Hash: "c2"}},
+		Files:   map[string]string{},
+		History: second,
+		Lease:   &remote.Lease{History: empty},
+	})
+	if !errors.Is(err, remote.ErrNonFastForward) {
+		t.Fatalf("push on a stale lease = %v, want ErrNonFastForward", err)
+	}
+
+	// Up to date, but dropping the turn already pushed
+	lease := &remote.Lease{Head: "c1", History: remote.PositionOf(first)}
+	_, err = client.Push(&remote.PushData{Branch: "main", Files: map[string]string{}, History: second, Lease: lease})
+	if !errors.Is(err, remote.ErrNonFastForward) {
+		t.Fatalf("push dropping turns = %v, want ErrNonFastForward", err)
+	}
+	if _, err := client.Push(&remote.PushData{Branch: "main", Files: map[string]string{}, History: second, Lease: lease, AllowRewrite: true}); err != nil {
+		t.Fatalf("push with allow_rewrite: %v", err)
+	}
+
+	// A branch the remote doesn't have yet leases an empty head
+	lease = &remote.Lease{History: remote.PositionOf(second)}
+	if _, err := client.Push(&remote.PushData{Branch: "feature", Commits: []remote.Commit{{Hash: "f1"}}, Files: map[string]string{}, History: second, Lease: lease}); err != nil {
+		t.Fatalf("push of a new branch: %v", err)
+	}
+}
+
+func TestPushLeaseOnBranchHistories(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	main := turn("git status", "clean")
+	result, err := client.Push(&remote.PushData{
+		Branch:    "main",
+		Files:     map[string]string{},
+		History:   main,
+		Histories: map[string][]remote.Message{"main": main},
+		Lease:     &remote.Lease{History: remote.PositionOf(nil), Histories: map[string]remote.Position{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Histories["main"] != remote.PositionOf(main) {
+		t.Errorf("push result histories = %v", result.Histories)
+	}
+
+	// A client that never saw main's log can't overwrite it
+	_, err = client.Push(&remote.PushData{
+		Branch:    "main",
+		Files:     map[string]string{},
+		History:   main,
+		Histories: map[string][]remote.Message{"main": turn("git log", "...")},
+		Lease:     &remote.Lease{History: remote.PositionOf(main), Histories: map[string]remote.Position{}},
+	})
+	if !errors.Is(err, remote.ErrNonFastForward) {
+		t.Errorf("push over an unseen branch log = %v, want ErrNonFastForward", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	store, url := newTestServer(t)
+	id, err := remote.NewClientWithURL(url, "").CreateRepo("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := store.AddToken("writer", map[string]Access{AllRepos: AccessWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := store.AddToken("reader", map[string]Access{id: AccessRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := &remote.PushData{Branch: "main", Files: map[string]string{"a.txt": "a"}}
+	if _, err := remote.NewClientForRepo(url, id, "").Push(push); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("push without a token = %v, want 401", err)
+	}
+	if _, err := remote.NewClientForRepo(url, id, "gitr_wrong").Push(push); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("push with an unknown token = %v, want 401", err)
+	}
+	if _, err := remote.NewClientForRepo(url, id, reader).Push(push); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("push with a read token = %v, want 403", err)
+	}
+	if _, err := remote.NewClientWithURL(url, reader).CreateRepo("other"); err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("create with a read token = %v, want 403", err)
+	}
+
+	if _, err := remote.NewClientForRepo(url, id, writer).Push(push); err != nil {
+		t.Fatalf("push with a write token: %v", err)
+	}
+	if _, err := remote.NewClientForRepo(url, id, reader).Pull(); err != nil {
+		t.Errorf("pull with a read token: %v", err)
+	}
+	// fetch negotiates before pulling
+	if _, err := remote.NewClientForRepo(url, id, reader).Negotiate(&remote.NegotiateRequest{Branch: "main"}); err != nil {
+		t.Errorf("negotiate with a read token: %v", err)
+	}
+	if err := remote.NewClientWithURL(url, reader).CheckAccess(id); err != nil {
+		t.Errorf("CheckAccess with a read token: %v", err)
+	}
+
+	// Revoking every token keeps the server locked
+	for _, name := range []string{"writer", "reader"} {
+		if err := store.RevokeToken(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := remote.NewClientForRepo(url, id, "").Pull(); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("pull after revoking every token = %v, want 401", err)
+	}
+
+	if err := store.SetAuth(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.NewClientForRepo(url, id, "").Pull(); err != nil {
+		t.Errorf("pull with authentication off: %v", err)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	big := strings.Repeat("all work and no play makes jack a dull boy\n", 2000)
+	push := &remote.PushData{Branch: "main", Commits: []remote.Commit{{Hash: "c1"}}, Files: map[string]string{"big.txt": big}}
+
+	// The first request learns that the server takes gzipped bodies
+	if _, err := client.Push(push); err != nil {
+		t.Fatal(err)
+	}
+	before := client.Stats()
+	if _, err := client.Push(push); err != nil {
+		t.Fatal(err)
+	}
+	after := client.Stats()
+	if sent, raw := after.Sent-before.Sent, after.SentRaw-before.SentRaw; sent*10 > raw {
+		t.Errorf("push sent %d bytes for %d uncompressed, want it gzipped", sent, raw)
+	}
+
+	before = client.Stats()
+	pulled, err := client.Pull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.Files["big.txt"] != big {
+		t.Error("pulled file differs from the pushed one")
+	}
+	after = client.Stats()
+	if received, raw := after.Received-before.Received, after.ReceivedRaw-before.ReceivedRaw; received*10 > raw {
+		t.Errorf("pull received %d bytes for %d uncompressed, want it gzipped", received, raw)
+	}
+}
+
+func TestPerBranchPush(t *testing.T) {
+	_, url := newTestServer(t)
+	client := newTestRepo(t, url, "")
+
+	shared := turn("git status", "clean")
+	if _, err := client.Push(&remote.PushData{Branch: "main", Commits: []remote.Commit{{Hash: "m0"}}, Files: map[string]string{}, History: shared}); err != nil {
+		t.Fatal(err)
+	}
+
+	push := func(branch, head string, lease *remote.Lease, log []remote.Message) (*remote.PushResult, error) {
+		return client.Push(&remote.PushData{
+			Branch:    branch,
+			Commits:   []remote.Commit{{Hash: head}},
+			Files:     map[string]string{},
+			Histories: map[string][]remote.Message{branch: log},
+			PerBranch: true,
+			Lease:     lease,
+		})
+	}
+
+	main := turn("git commit -m main", "committed")
+	result, err := push("main", "m1", &remote.Lease{Head: "m0"}, main)
+	if err != nil {
+		t.Fatalf("push of main: %v", err)
+	}
+	mainLease := &remote.Lease{Head: "m1", Histories: result.Histories}
+
+	// The shared history the lease doesn't mention stays as it was
+	feature := turn("git commit -m feature", "committed")
+	if _, err := push("feature", "f1", &remote.Lease{Histories: result.Histories}, feature); err != nil {
+		t.Fatalf("push of feature: %v", err)
+	}
+
+	// Pushing feature didn't move main's lease
+	main = append(main, turn("git commit -m more", "committed")...)
+	if _, err := push("main", "m2", mainLease, main); err != nil {
+		t.Fatalf("second push of main: %v", err)
+	}
+
+	pulled, err := client.Pull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.PositionOf(pulled.History) != remote.PositionOf(shared) {
+		t.Errorf("shared history = %v, want it untouched", pulled.History)
+	}
+	if len(pulled.Histories["main"]) != 4 || len(pulled.Histories["feature"]) != 2 {
+		t.Errorf("pulled histories %v", pulled.Histories)
+	}
+
+	// main's log moved, so a lease on its old end is stale
+	stale := &remote.Lease{Head: "m2", Histories: mainLease.Histories}
+	if _, err := push("main", "m3", stale, main); !errors.Is(err, remote.ErrNonFastForward) {
+		t.Errorf("push on a stale branch log = %v, want ErrNonFastForward", err)
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mysticshirou/gitroulette/internal/remote"
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// ErrNotFound is returned for repositories that don't exist
+var ErrNotFound = errors.New("repository not found")
+
+// Store keeps repositories on the local filesystem:
+//
+//	<dir>/repos/<id>/repo.json          name, branches, commits and history
+//	<dir>/repos/<id>/objects/<sha256>   file contents, each stored once
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Repository is a repository as the store keeps it
+type Repository struct {
+	ID        string                      `json:"id"`
+	Name      string                      `json:"name"`
+	CreatedAt string                      `json:"created_at"`
+	UpdatedAt string                      `json:"updated_at"`
+	Branches  map[string]*Branch          `json:"branches"`
+	History   []remote.Message            `json:"history"`
+	Histories map[string][]remote.Message `json:"histories,omitempty"`
+}
+
+// Branch is a named line of commits
+type Branch struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt string    `json:"created_at"`
+	Commits   []*Commit `json:"commits"`
+}
+
+// Commit is a pushed commit with the files it recorded
+type Commit struct {
+	ID        string `json:"id"`
+	Hash      string `json:"commit_hash"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+
+	// Files maps each path to the object holding its content
+	Files map[string]string `json:"files"`
+}
+
+// Latest returns the branch's newest commit, or nil if it has none
+func (b *Branch) Latest() *Commit {
+	if len(b.Commits) == 0 {
+		return nil
+	}
+	return b.Commits[len(b.Commits)-1]
+}
+
+// SortedBranches returns the repository's branches, oldest first
+func (r *Repository) SortedBranches() []*Branch {
+	branches := make([]*Branch, 0, len(r.Branches))
+	for _, branch := range r.Branches {
+		branches = append(branches, branch)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].CreatedAt != branches[j].CreatedAt {
+			return branches[i].CreatedAt < branches[j].CreatedAt
+		}
+		return branches[i].Name < branches[j].Name
+	})
+	return branches
+}
+
+// OpenStore opens the store in dir, creating it if needed
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "repos"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory the store lives in
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// now is the timestamp format the store records, matching the web remote
+func now() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// newID returns an id in the web remote's "<millis>-<random>" format
+func newID() string {
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+	suffix := make([]byte, 9)
+	for i := range suffix {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			panic(err)
+		}
+		suffix[i] = alphabet[n.Int64()]
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixMilli(), suffix)
+}
+
+// validID reports whether id is safe to use as a directory name
+func validID(id string) bool {
+	if id == "" || strings.HasPrefix(id, ".") {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) repoDir(id string) string {
+	return filepath.Join(s.dir, "repos", id)
+}
+
+// CreateRepo creates an empty repository with a main branch
+func (s *Store) CreateRepo(name string) (*Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := now()
+	r := &Repository{
+		ID:        newID(),
+		Name:      name,
+		CreatedAt: created,
+		UpdatedAt: created,
+		Branches: map[string]*Branch{
+			"main": {ID: newID(), Name: "main", CreatedAt: created},
+		},
+		History: []remote.Message{},
+	}
+	if err := os.MkdirAll(filepath.Join(s.repoDir(r.ID), "objects"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	if err := s.save(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Repo loads a repository
+func (s *Store) Repo(id string) (*Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(id)
+}
+
+// Repos lists every repository, most recently updated first
+func (s *Store) Repos() ([]*Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, "repos"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	var repos []*Repository
+	for _, entry := range entries {
+		if !entry.IsDir() || !validID(entry.Name()) {
+			continue
+		}
+		r, err := s.load(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].UpdatedAt > repos[j].UpdatedAt })
+	return repos, nil
+}
+
+// Update loads a repository, lets fn change it and saves it, holding the
+// store's lock throughout so concurrent pushes can't interleave
+func (s *Store) Update(id string, fn func(*Repository) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.load(id)
+	if err != nil {
+		return err
+	}
+	if err := fn(r); err != nil {
+		return err
+	}
+	r.UpdatedAt = now()
+	return s.save(r)
+}
+
+// WriteObject stores content and returns its hash
+func (s *Store) WriteObject(id, content string) (string, error) {
+	hash := remote.HashContent(content)
+	path := filepath.Join(s.repoDir(id), "objects", hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := repo.WriteFileAtomic(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to store object: %w", err)
+	}
+	return hash, nil
+}
+
+// HasObject reports whether content with the given hash is stored
+func (s *Store) HasObject(id, hash string) bool {
+	if !validID(hash) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.repoDir(id), "objects", hash))
+	return err == nil
+}
+
+// ReadObject returns the content stored under hash
+func (s *Store) ReadObject(id, hash string) (string, error) {
+	if !validID(hash) {
+		return "", fmt.Errorf("invalid object hash: %s", hash)
+	}
+	data, err := os.ReadFile(filepath.Join(s.repoDir(id), "objects", hash))
+	if err != nil {
+		return "", fmt.Errorf("failed to read object %s: %w", hash, err)
+	}
+	return string(data), nil
+}
+
+// ReadFiles returns the contents of every file a commit recorded
+func (s *Store) ReadFiles(id string, commit *Commit) (map[string]string, error) {
+	files := map[string]string{}
+	if commit == nil {
+		return files, nil
+	}
+	for path, hash := range commit.Files {
+		content, err := s.ReadObject(id, hash)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = content
+	}
+	return files, nil
+}
+
+// load reads a repository; the caller holds the lock
+func (s *Store) load(id string) (*Repository, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.repoDir(id), "repo.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository: %w", err)
+	}
+
+	var r Repository
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse repository %s: %w", id, err)
+	}
+	if r.Branches == nil {
+		r.Branches = map[string]*Branch{}
+	}
+	return &r, nil
+}
+
+// save writes a repository; the caller holds the lock
+func (s *Store) save(r *Repository) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize repository: %w", err)
+	}
+	if err := repo.WriteFileAtomic(filepath.Join(s.repoDir(r.ID), "repo.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save repository: %w", err)
+	}
+	return nil
+}
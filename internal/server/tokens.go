@@ -0,0 +1,247 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mysticshirou/gitroulette/internal/repo"
+)
+
+// Access is what a token may do with a repository
+type Access string
+
+const (
+	AccessRead  Access = "read"
+	AccessWrite Access = "write" // implies read
+)
+
+// AllRepos scopes a token to every repository, including ones it creates
+const AllRepos = "*"
+
+// tokenPrefix marks gitr tokens so they are easy to spot in logs and leaks
+const tokenPrefix = "gitr_"
+
+// Token is an access token as the store keeps it: only a hash of the
+// secret is stored, so the tokens file doesn't grant access by itself
+type Token struct {
+	Name      string            `json:"name"`
+	Hash      string            `json:"hash"`
+	Scopes    map[string]Access `json:"scopes"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// Allows reports whether the token grants access to a repository
+func (t *Token) Allows(repoID string, access Access) bool {
+	for _, scope := range []string{repoID, AllRepos} {
+		switch t.Scopes[scope] {
+		case AccessWrite:
+			return true
+		case AccessRead:
+			if access == AccessRead {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckScope validates a repository id given as a token scope
+func CheckScope(repoID string) error {
+	if repoID != AllRepos && !validID(repoID) {
+		return fmt.Errorf("invalid repository id: %s", repoID)
+	}
+	return nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) tokensPath() string {
+	return filepath.Join(s.dir, "tokens.json")
+}
+
+// Tokens lists the tokens, sorted by name
+func (s *Store) Tokens() ([]*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadTokens()
+}
+
+func (s *Store) authPath() string {
+	return filepath.Join(s.dir, "auth.json")
+}
+
+// authState is what auth.json records
+type authState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// AuthEnabled reports whether requests need a token. The first token
+// added turns authentication on and only SetAuth turns it off, so revoking
+// every token locks the server instead of opening it.
+func (s *Store) AuthEnabled() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authEnabled()
+}
+
+// SetAuth turns authentication on or off
+func (s *Store) SetAuth(enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveAuth(enabled)
+}
+
+// authEnabled reads auth.json; the caller holds the lock. Stores from
+// before it existed require tokens while they have any.
+func (s *Store) authEnabled() (bool, error) {
+	data, err := os.ReadFile(s.authPath())
+	if errors.Is(err, os.ErrNotExist) {
+		tokens, err := s.loadTokens()
+		return len(tokens) > 0, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read auth state: %w", err)
+	}
+
+	var state authState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("failed to parse auth state: %w", err)
+	}
+	return state.Enabled, nil
+}
+
+// saveAuth writes auth.json; the caller holds the lock
+func (s *Store) saveAuth(enabled bool) error {
+	data, err := json.MarshalIndent(authState{Enabled: enabled}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize auth state: %w", err)
+	}
+	if err := repo.WriteFileAtomic(s.authPath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to save auth state: %w", err)
+	}
+	return nil
+}
+
+// Authenticate finds the token a secret belongs to
+func (s *Store) Authenticate(secret string) (*Token, error) {
+	tokens, err := s.Tokens()
+	if err != nil {
+		return nil, err
+	}
+	hash := hashToken(secret)
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			return token, nil
+		}
+	}
+	return nil, nil
+}
+
+// AddToken creates a token and returns its secret, which is shown only once
+func (s *Store) AddToken(name string, scopes map[string]Access) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return "", fmt.Errorf("invalid token name: %q", name)
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("a token needs at least one --read or --write scope")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.loadTokens()
+	if err != nil {
+		return "", err
+	}
+	for _, token := range tokens {
+		if token.Name == name {
+			return "", fmt.Errorf("token '%s' already exists", name)
+		}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	secret := tokenPrefix + hex.EncodeToString(random)
+
+	tokens = append(tokens, &Token{Name: name, Hash: hashToken(secret), Scopes: scopes, CreatedAt: now()})
+	if err := s.saveTokens(tokens); err != nil {
+		return "", err
+	}
+	if err := s.saveAuth(true); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// RevokeToken deletes a token by name
+func (s *Store) RevokeToken(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.loadTokens()
+	if err != nil {
+		return err
+	}
+	enabled, err := s.authEnabled()
+	if err != nil {
+		return err
+	}
+	for i, token := range tokens {
+		if token.Name == name {
+			// Record the state first, so losing the last token of an
+			// older store doesn't turn authentication off
+			if err := s.saveAuth(enabled); err != nil {
+				return err
+			}
+			return s.saveTokens(append(tokens[:i], tokens[i+1:]...))
+		}
+	}
+	return fmt.Errorf("no token named '%s'", name)
+}
+
+// loadTokens reads the tokens file; the caller holds the lock
+func (s *Store) loadTokens() ([]*Token, error) {
+	data, err := os.ReadFile(s.tokensPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens: %w", err)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+// saveTokens writes the tokens file, readable only by its owner
+func (s *Store) saveTokens(tokens []*Token) error {
+	if tokens == nil {
+		tokens = []*Token{}
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize tokens: %w", err)
+	}
+	if err := repo.WriteFileAtomic(s.tokensPath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+// Package trace prints diagnostics enabled by gitr's --verbose flag
+package trace
+
+import (
+	"fmt"
+	"os"
+)
+
+// Enabled turns tracing on
+var Enabled bool
+
+// Printf writes a diagnostic line to stderr when tracing is enabled
+func Printf(format string, args ...any) {
+	if !Enabled {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "gitr: "+format+"\n", args...)
+}
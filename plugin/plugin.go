@@ -0,0 +1,83 @@
+// Package plugin helps write gitr plugins: executables named gitr-<name> on
+// PATH that gitr runs for 'gitr <name>'.
+//
+// gitr describes the repository to a plugin through its environment:
+//
+//	GITR_ROOT        repository root (unset outside a repository)
+//	GITR_BRANCH      current branch
+//	GITR_CONFIG      the repository's config file
+//	GITR_EXECUTABLE  the gitr binary, for calling back into gitr
+//	GITR_PROFILE     the LLM profile chosen with --profile, if any
+//	GITR_JSON        "1" when gitr was run with --json
+//	GITR_VERBOSE     "1" when gitr was run with --verbose
+//
+// A minimal plugin:
+//
+//	func main() {
+//		response, err := plugin.SendCommand("git shortlog", os.Args[1:])
+//		if err != nil {
+//			fmt.Fprintln(os.Stderr, "Error:", err)
+//			os.Exit(1)
+//		}
+//		fmt.Println(response)
+//	}
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mysticshirou/gitroulette/internal/llm"
+	"github.com/mysticshirou/gitroulette/internal/trace"
+)
+
+// Env is the environment gitr passes to a plugin
+type Env struct {
+	Root       string // repository root
+	Branch     string // current branch
+	ConfigPath string // repository config file
+	Executable string // the gitr binary
+	Profile    string // LLM profile chosen with --profile
+	JSON       bool   // --json was given
+	Verbose    bool   // --verbose was given
+}
+
+// ErrNotRepo is returned when the plugin wasn't run inside a gitr repository
+var ErrNotRepo = errors.New("not a gitr repository (GITR_ROOT is not set)")
+
+// FromEnv reads the environment gitr set up for the plugin. It fails with
+// ErrNotRepo outside a repository or when the plugin was started directly
+// rather than through gitr.
+func FromEnv() (*Env, error) {
+	env := &Env{
+		Root:       os.Getenv("GITR_ROOT"),
+		Branch:     os.Getenv("GITR_BRANCH"),
+		ConfigPath: os.Getenv("GITR_CONFIG"),
+		Executable: os.Getenv("GITR_EXECUTABLE"),
+		Profile:    os.Getenv("GITR_PROFILE"),
+		JSON:       os.Getenv("GITR_JSON") == "1",
+		Verbose:    os.Getenv("GITR_VERBOSE") == "1",
+	}
+	if env.Root == "" {
+		return env, ErrNotRepo
+	}
+	return env, nil
+}
+
+// SendCommand sends a git command to the LLM the way gitr's own commands
+// do: with the repository's configured provider (honouring --profile and
+// llm.command_profile.<command>), its prompt templates and its conversation
+// history, which the exchange is added to. command is the full command
+// line, e.g. "git shortlog".
+func SendCommand(command string, args []string) (string, error) {
+	env, err := FromEnv()
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chdir(env.Root); err != nil {
+		return "", fmt.Errorf("failed to enter repository: %w", err)
+	}
+	trace.Enabled = env.Verbose
+	return llm.SendCommand(command, args)
+}
@@ -4,9 +4,10 @@
 # Tests all functionality: CLI operations, remote operations, and edge cases
 #
 # Usage:
-#   ./test.sh                                    # Test with default remote (Vercel)
-#   BACKEND_URL=http://localhost:3000 ./test.sh  # Test with local backend
+#   ./test.sh                                    # Test remotes against a local 'gitr serve'
+#   BACKEND_URL=https://gitroulette.vercel.app ./test.sh  # Test with another backend
 #   GITR_API_KEY=sk-xxx ./test.sh                # Set API key inline
+#   API_URL=http://localhost:8000/v1/chat/completions ./test.sh  # Use a local LLM
 
 set -e
 
@@ -18,7 +19,9 @@ NC='\033[0m' # No Color
 
 # Configuration
 BACKEND_PORT=3000
-BACKEND_URL="${BACKEND_URL:-https://gitroulette.vercel.app}"
+BACKEND_URL="${BACKEND_URL:-}"
+API_URL="${API_URL:-https://api.deepseek.com/v1/chat/completions}"
+SERVE_PID=""
 
 # Functions
 log_info() { echo -e "${GREEN}✓${NC} $1"; }
@@ -27,13 +30,16 @@ log_warn() { echo -e "${YELLOW}!${NC} $1"; }
 
 cleanup() {
     log_info "Cleaning up test directories..."
+    if [ -n "$SERVE_PID" ]; then
+        kill "$SERVE_PID" 2>/dev/null || true
+    fi
     rm -rf /tmp/gitr-test-* 2>/dev/null || true
 }
 
 trap cleanup EXIT
 
 echo "=== GitRoulette Test Suite ==="
-echo "Backend URL: $BACKEND_URL"
+echo "Backend URL: ${BACKEND_URL:-local gitr serve}"
 echo ""
 
 # Check for API key
@@ -48,6 +54,15 @@ echo "=== Building CLI ==="
 go build -o gitr ./cmd/gitr
 GITR_BIN="$(pwd)/gitr"
 log_info "Build successful"
+
+# Without a backend, serve one locally so remote tests run offline
+if [ -z "$BACKEND_URL" ]; then
+    BACKEND_URL="http://127.0.0.1:$BACKEND_PORT"
+    "$GITR_BIN" serve --addr "127.0.0.1:$BACKEND_PORT" --dir "/tmp/gitr-test-server-$(date +%s)" >/dev/null 2>&1 &
+    SERVE_PID=$!
+    sleep 1
+    log_info "Started local remote at $BACKEND_URL"
+fi
 echo ""
 
 # Test 1: Local Operations
@@ -57,7 +72,7 @@ mkdir -p "$TEST_DIR"
 cd "$TEST_DIR"
 
 "$GITR_BIN" init
-"$GITR_BIN" config set api.url "https://api.deepseek.com/v1/chat/completions"
+"$GITR_BIN" config set api.url "$API_URL"
 "$GITR_BIN" config set api.key "$GITR_API_KEY"
 
 echo "Test content" > file1.txt
@@ -99,7 +114,7 @@ mkdir -p "$TEST_DIR_2"
 cd "$TEST_DIR_2"
 
 "$GITR_BIN" init
-"$GITR_BIN" config set api.url "https://api.deepseek.com/v1/chat/completions"
+"$GITR_BIN" config set api.url "$API_URL"
 "$GITR_BIN" config set api.key "$GITR_API_KEY"
 
 # Create a binary file (copy the gitr binary)
@@ -117,9 +132,13 @@ fi
 cd - >/dev/null
 echo ""
 
-# Test 3: Remote Operations (optional)
-read -p "Test remote operations? (requires backend at $BACKEND_URL) [y/N]: " -n 1 -r
-echo
+# Test 3: Remote Operations (optional against an external backend)
+if [ -n "$SERVE_PID" ]; then
+    REPLY=y
+else
+    read -p "Test remote operations? (requires backend at $BACKEND_URL) [y/N]: " -n 1 -r
+    echo
+fi
 if [[ $REPLY =~ ^[Yy]$ ]]; then
     echo "=== Test 3: Remote Operations ==="
 
@@ -129,7 +148,7 @@ if [[ $REPLY =~ ^[Yy]$ ]]; then
         log_error "Backend not accessible at $BACKEND_URL"
         echo "Options:"
         echo "  1. For remote testing: Make sure your Vercel app is deployed"
-        echo "  2. For local testing: Set BACKEND_URL=http://localhost:3000 and run 'npm run dev' in web/"
+        echo "  2. For local testing: Unset BACKEND_URL to use 'gitr serve', or run 'npm run dev' in web/"
         exit 1
     fi
     log_info "Backend is accessible"
@@ -139,7 +158,7 @@ if [[ $REPLY =~ ^[Yy]$ ]]; then
     cd "$TEST_DIR_3"
 
     "$GITR_BIN" init
-    "$GITR_BIN" config set api.url "https://api.deepseek.com/v1/chat/completions"
+    "$GITR_BIN" config set api.url "$API_URL"
     "$GITR_BIN" config set api.key "$GITR_API_KEY"
     "$GITR_BIN" config set remote.url "$BACKEND_URL"
 
@@ -162,6 +181,17 @@ if [[ $REPLY =~ ^[Yy]$ ]]; then
         exit 1
     fi
 
+    # The first push of a new branch builds on the history main pushed
+    "$GITR_BIN" checkout -b feature >/dev/null 2>&1
+    "$GITR_BIN" commit -m "Feature work" >/dev/null 2>&1
+    if "$GITR_BIN" push >/dev/null 2>&1; then
+        log_info "Push of a new branch works"
+    else
+        log_error "Push of a new branch was rejected"
+        exit 1
+    fi
+    "$GITR_BIN" checkout main >/dev/null 2>&1
+
     # Get the repo ID from config
     REPO_ID=$("$GITR_BIN" config get remote.repo_id 2>/dev/null || grep -oP '"repo_id":\s*"\K[^"]+' .gitr/config.json)
     if [ -z "$REPO_ID" ]; then
@@ -176,7 +206,7 @@ if [[ $REPLY =~ ^[Yy]$ ]]; then
     cd "$TEST_DIR_4"
 
     "$GITR_BIN" init
-    "$GITR_BIN" config set api.url "https://api.deepseek.com/v1/chat/completions"
+    "$GITR_BIN" config set api.url "$API_URL"
     "$GITR_BIN" config set api.key "$GITR_API_KEY"
     "$GITR_BIN" config set remote.url "$BACKEND_URL"
     "$GITR_BIN" config set remote.repo_id "$REPO_ID"
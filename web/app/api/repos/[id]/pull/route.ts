@@ -23,8 +23,15 @@ export async function GET(
       );
     }
 
-    // Get main branch (or first branch)
+    // Get the branch asked for, or main (or the first branch)
     const branches = await db.getBranches(repoId);
+    const name = request.nextUrl.searchParams.get('branch');
+    if (name && !branches.some(b => b.name === name)) {
+      return NextResponse.json(
+        { error: `Branch not found: ${name}` },
+        { status: 404 }
+      );
+    }
     if (branches.length === 0) {
       return NextResponse.json(
         { error: 'No branches found' },
@@ -32,19 +39,25 @@ export async function GET(
       );
     }
 
-    const branch = branches.find(b => b.name === 'main') || branches[0];
+    const branch = branches.find(b => b.name === (name || 'main')) || branches[0];
 
     // Get latest files
     const files = await db.getLatestFiles(repoId, branch.id);
+    const latest = await db.getLatestCommit(repoId, branch.id);
 
     // Get LLM history
     const history = await db.getLLMHistory(repoId);
+    const histories = await db.getLLMHistories(repoId);
 
     const response: PullResponse = {
       branch: branch.name,
+      head: latest?.commit_hash || '',
       files,
       history,
     };
+    if (Object.keys(histories).length > 0) {
+      response.histories = histories;
+    }
 
     return NextResponse.json(response);
   } catch (error) {
@@ -1,11 +1,33 @@
 import { NextRequest, NextResponse } from 'next/server';
 import db from '@/lib/db';
-import { PushRequest } from '@/lib/types';
+import { LLMMessage, PushRequest, PushResponse } from '@/lib/types';
 
 // Increase body size limit for this route
 export const runtime = 'nodejs';
 export const maxDuration = 30;
 
+type Messages = PushRequest['history'];
+
+// keepsAll reports whether messages contains every message of log in the
+// same order, as a merged history does. Stored timestamps don't round-trip
+// exactly, so messages are compared by role and content.
+function keepsAll(messages: Messages, log: LLMMessage[]): boolean {
+  let i = 0;
+  for (const msg of messages) {
+    if (i < log.length && msg.role === log[i].role && msg.content === log[i].content) {
+      i++;
+    }
+  }
+  return i === log.length;
+}
+
+function rejected(error: string) {
+  return NextResponse.json(
+    { error, code: 'non_fast_forward' },
+    { status: 409 }
+  );
+}
+
 // POST /api/repos/:id/push - Push commits, files, and history
 export async function POST(
   request: NextRequest,
@@ -24,8 +46,28 @@ export async function POST(
       );
     }
 
-    // Get or create branch
     let branch = await db.getBranch(repoId, data.branch);
+
+    // A push with a lease must be built on the branch as it is now and,
+    // unless it may rewrite, keep every turn the remote has
+    if (data.lease) {
+      const latest = branch ? await db.getLatestCommit(repoId, branch.id) : null;
+      if ((latest?.commit_hash || '') !== data.lease.head) {
+        return rejected(`The remote has changes on ${data.branch} that the push doesn't include`);
+      }
+      if (!data.allow_rewrite) {
+        if (!data.per_branch && !keepsAll(data.history || [], await db.getLLMHistory(repoId))) {
+          return rejected('The push would drop turns from the remote history');
+        }
+        for (const [name, messages] of Object.entries(data.histories || {})) {
+          if (!keepsAll(messages, await db.getLLMHistory(repoId, name))) {
+            return rejected(`The push would drop turns from the history of branch ${name}`);
+          }
+        }
+      }
+    }
+
+    // Get or create branch
     if (!branch) {
       branch = await db.createBranch(repoId, data.branch);
     }
@@ -43,18 +85,38 @@ export async function POST(
       await db.saveFiles(repoId, commit.id, data.files);
     }
 
-    // Store LLM history
-    const llmMessages = data.history.map(h => ({
-      role: h.role as 'user' | 'assistant',
-      content: h.content,
-      timestamp: h.timestamp,
-    }));
-    await db.saveLLMHistory(repoId, llmMessages);
+    // Store LLM history; a per-branch push leaves the shared log alone
+    if (!data.per_branch) {
+      const llmMessages = (data.history || []).map(h => ({
+        role: h.role as 'user' | 'assistant',
+        content: h.content,
+        timestamp: h.timestamp,
+      }));
+      await db.saveLLMHistory(repoId, llmMessages);
+    }
+
+    // Store per-branch logs, leaving those of branches not pushed alone
+    for (const [name, messages] of Object.entries(data.histories || {})) {
+      await db.saveLLMHistory(
+        repoId,
+        messages.map(h => ({
+          role: h.role as 'user' | 'assistant',
+          content: h.content,
+          timestamp: h.timestamp,
+        })),
+        name
+      );
+    }
 
     // Update repository timestamp
     await db.updateRepository(repoId);
 
-    return NextResponse.json({ success: true, message: 'Pushed successfully' });
+    const response: PushResponse = {
+      success: true,
+      message: 'Pushed successfully',
+      lease_checked: !!data.lease,
+    };
+    return NextResponse.json(response);
   } catch (error) {
     console.error('Error pushing to repository:', error);
     return NextResponse.json(
@@ -198,31 +198,58 @@ export const db = {
   },
 
   // LLM History
-  async saveLLMHistory(repoId: string, messages: LLMMessage[]): Promise<void> {
-    // Remove old history for this repo
+  // branch is null for the shared log, or names a per-branch log
+  async saveLLMHistory(
+    repoId: string,
+    messages: LLMMessage[],
+    branch: string | null = null
+  ): Promise<void> {
+    // Remove the old log
     await sql`
-      DELETE FROM llm_history WHERE repo_id = ${repoId}
+      DELETE FROM llm_history
+      WHERE repo_id = ${repoId} AND branch IS NOT DISTINCT FROM ${branch}
     `;
 
     // Add new messages
     for (const msg of messages) {
       const id = generateId();
       await sql`
-        INSERT INTO llm_history (id, repo_id, role, content, created_at)
-        VALUES (${id}, ${repoId}, ${msg.role}, ${msg.content}, ${msg.timestamp})
+        INSERT INTO llm_history (id, repo_id, role, content, branch, created_at)
+        VALUES (${id}, ${repoId}, ${msg.role}, ${msg.content}, ${branch}, ${msg.timestamp})
       `;
     }
   },
 
-  async getLLMHistory(repoId: string): Promise<LLMMessage[]> {
+  async getLLMHistory(repoId: string, branch: string | null = null): Promise<LLMMessage[]> {
     const result = await sql`
       SELECT role, content, created_at as timestamp
       FROM llm_history
-      WHERE repo_id = ${repoId}
+      WHERE repo_id = ${repoId} AND branch IS NOT DISTINCT FROM ${branch}
       ORDER BY created_at ASC
     `;
     return Array.from(result) as LLMMessage[];
   },
+
+  // Every per-branch log, keyed by branch
+  async getLLMHistories(repoId: string): Promise<Record<string, LLMMessage[]>> {
+    const result = await sql`
+      SELECT branch, role, content, created_at as timestamp
+      FROM llm_history
+      WHERE repo_id = ${repoId} AND branch IS NOT NULL
+      ORDER BY created_at ASC
+    `;
+
+    const histories: Record<string, LLMMessage[]> = {};
+    for (const row of result) {
+      if (!histories[row.branch]) histories[row.branch] = [];
+      histories[row.branch].push({
+        role: row.role,
+        content: row.content,
+        timestamp: row.timestamp,
+      });
+    }
+    return histories;
+  },
 };
 
 export default db;
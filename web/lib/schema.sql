@@ -43,9 +43,14 @@ CREATE TABLE IF NOT EXISTS llm_history (
   repo_id TEXT NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
   role TEXT NOT NULL,
   content TEXT NOT NULL,
+  -- NULL for the shared log, the branch name for a per-branch log
+  branch TEXT,
   created_at TIMESTAMP NOT NULL
 );
 
+-- Databases created before per-branch histories
+ALTER TABLE llm_history ADD COLUMN IF NOT EXISTS branch TEXT;
+
 -- Create indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_branches_repo_id ON branches(repo_id);
 CREATE INDEX IF NOT EXISTS idx_commits_repo_id ON commits(repo_id);
@@ -53,3 +58,4 @@ CREATE INDEX IF NOT EXISTS idx_commits_branch_id ON commits(branch_id);
 CREATE INDEX IF NOT EXISTS idx_files_repo_id ON files(repo_id);
 CREATE INDEX IF NOT EXISTS idx_files_commit_id ON files(commit_id);
 CREATE INDEX IF NOT EXISTS idx_llm_history_repo_id ON llm_history(repo_id);
+CREATE INDEX IF NOT EXISTS idx_llm_history_branch ON llm_history(repo_id, branch);
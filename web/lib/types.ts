@@ -67,14 +67,44 @@ export interface PushRequest {
     content: string;
     timestamp: string;
   }[];
+  // One log per branch, sent when the repository keeps per-branch histories
+  histories?: Record<string, {
+    role: string;
+    content: string;
+    timestamp: string;
+  }[]>;
+  // Set when histories holds the pushed branch's log in place of history,
+  // which is then left alone
+  per_branch?: boolean;
+  // The branch as the client last saw it; the push is rejected if the
+  // branch has moved since, or (without allow_rewrite) if it would drop
+  // turns the remote has
+  lease?: {
+    head: string;
+  };
+  allow_rewrite?: boolean;
+}
+
+export interface PushResponse {
+  success: boolean;
+  message: string;
+  // Whether the push's lease was checked
+  lease_checked?: boolean;
 }
 
 export interface PullResponse {
   branch: string;
+  // Hash of the branch's newest commit, empty if it has none
+  head: string;
   files: Record<string, string>;
   history: {
     role: string;
     content: string;
     timestamp: string;
   }[];
+  histories?: Record<string, {
+    role: string;
+    content: string;
+    timestamp: string;
+  }[]>;
 }
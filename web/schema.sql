@@ -46,6 +46,8 @@ CREATE TABLE llm_history (
     repo_id UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
     role VARCHAR(20) NOT NULL CHECK (role IN ('user', 'assistant')),
     content TEXT NOT NULL,
+    -- NULL for the shared log, the branch name for a per-branch log
+    branch VARCHAR(255),
     created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
@@ -58,3 +60,4 @@ CREATE INDEX idx_files_repo_id ON files(repo_id);
 CREATE INDEX idx_files_commit_id ON files(commit_id);
 CREATE INDEX idx_llm_history_repo_id ON llm_history(repo_id);
 CREATE INDEX idx_llm_history_created_at ON llm_history(created_at);
+CREATE INDEX idx_llm_history_branch ON llm_history(repo_id, branch);